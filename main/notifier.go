@@ -1,31 +1,108 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"flag"
+	"fmt"
 	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/sony/micro-notifier/notifier"
 )
 
+const shutdownTimeout = 10 * time.Second
+
 func main() {
 	configFile := flag.String("c", "", "Config file name")
+	purge := flag.Bool("purge-empty-channels", false,
+		"Delete channels without subscribers from Redis and exit")
+	check := flag.Bool("check", false,
+		"Validate the config and print the effective configuration with secrets masked, then exit")
+	watch := flag.Bool("watch-config", false,
+		"Reload the config file when it is modified, in addition to SIGHUP")
+	drainDelay := flag.Duration("drain-delay", 0,
+		"Time to keep serving after SIGINT or SIGTERM, while /readyz reports unavailable")
 
 	flag.Parse()
 	config, err := notifier.ReadConfigFile(*configFile)
 	if err != nil {
 		log.Fatalf("cannot read config file: %v", err)
 	}
+	if *check {
+		out, err := json.MarshalIndent(config.Masked(), "", "    ")
+		if err != nil {
+			log.Fatalf("cannot print config: %v", err)
+		}
+		fmt.Println(string(out))
+		return
+	}
+
+	shutdownTracing, err := notifier.InitTracing(config)
+	if err != nil {
+		log.Fatalf("cannot initialize tracing: %v", err)
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if err := shutdownTracing(ctx); err != nil {
+			log.Printf("cannot flush traces: %v", err)
+		}
+	}()
 
 	s := notifier.NewSupervisor(config)
 	defer s.Finish()
 
-	server := notifier.NewServer(s)
-	if config.Certificate != "" && config.PrivateKey != "" {
-		err = server.ListenAndServeTLS(config.Certificate, config.PrivateKey)
-	} else {
-		err = server.ListenAndServe()
+	if *purge {
+		n, err := s.PurgeEmptyChannels()
+		if err != nil {
+			log.Fatalf("cannot purge empty channels: %v", err)
+		}
+		log.Printf("purged %d empty channels", n)
+		return
 	}
+
+	if *configFile != "" {
+		s.ReloadOnSignal(*configFile)
+		if *watch {
+			err = s.WatchConfigFile(*configFile)
+			if err != nil {
+				log.Fatalf("cannot watch config file: %v", err)
+			}
+		}
+	}
+
+	server, err := notifier.NewServer(s)
 	if err != nil {
+		log.Fatalf("cannot create server: %v", err)
+	}
+	shutdownOnSignal(s, server, *drainDelay)
+	err = server.ListenAndServe()
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
 		log.Fatalf("cannot listen and serve: %v", err)
 	}
 }
+
+// shutdownOnSignal shuts down the server gracefully on SIGINT or SIGTERM.
+// The server keeps serving for drainDelay as unready, so that load
+// balancers stop sending new requests in the meantime.
+func shutdownOnSignal(s *notifier.Supervisor, server *notifier.Server, drainDelay time.Duration) {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		sig := <-ch
+		log.Printf("%v received; shutting down", sig)
+		s.StartDraining()
+		time.Sleep(drainDelay)
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if err := server.Shutdown(ctx); err != nil {
+			log.Printf("shutdown: %v", err)
+		}
+	}()
+}
@@ -0,0 +1,351 @@
+package notifier
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Applications can be registered, updated and unregistered at runtime
+// via the admin API.  They are kept in Redis in distributed mode, so
+// that all the processes share them, or in Admin.AppsFile otherwise.
+
+var appNamePattern = regexp.MustCompile(`^[-a-zA-Z0-9_.]+$`)
+
+type adminAppRequest struct {
+	ConfigApplication
+	Overlap int `json:"overlap"` // seconds the old key and secret remain valid on rotation
+}
+
+type adminAppsResponse struct {
+	Applications []ConfigApplication `json:"applications"`
+}
+
+// activeKeys removes expired keys.
+func (ca *ConfigApplication) activeKeys(now time.Time) {
+	keys := make([]ConfigKey, 0, len(ca.Keys))
+	for _, k := range ca.Keys {
+		if k.Expires == nil || now.Before(*k.Expires) {
+			keys = append(keys, k)
+		}
+	}
+	if len(keys) == 0 {
+		keys = nil
+	}
+	ca.Keys = keys
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// readAppsFile reads the applications kept in the file.  A missing file
+// means no applications.
+func readAppsFile(file string) ([]ConfigApplication, error) {
+	data, err := os.ReadFile(file)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var apps []ConfigApplication
+	err = json.Unmarshal(data, &apps)
+	if err != nil {
+		return nil, err
+	}
+	return apps, nil
+}
+
+// writeAppsFile replaces the file atomically.
+func writeAppsFile(file string, apps []ConfigApplication) error {
+	data, err := json.MarshalIndent(apps, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(file), filepath.Base(file)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Close()
+	} else {
+		_ = tmp.Close()
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), file)
+}
+
+// loadDynamicApps reads the applications registered via the admin API.
+func (s *Supervisor) loadDynamicApps() ([]ConfigApplication, error) {
+	if s.db != nil {
+		return s.db.GetApplications()
+	}
+	if file := s.fileConfig.Admin.AppsFile; file != "" {
+		apps, err := readAppsFile(file)
+		if err != nil {
+			return nil, wrapErr(500, err)
+		}
+		return apps, nil
+	}
+	return s.dynamicApps, nil
+}
+
+// refreshDynamicApps reloads the applications registered via the admin
+// API, which may have been changed by another process.
+func (s *Supervisor) refreshDynamicApps() {
+	s.reloadMu.Lock()
+	defer s.reloadMu.Unlock()
+
+	apps, apperr := s.loadDynamicApps()
+	if apperr != nil {
+		s.logger.Errorw("cannot load applications", "error", apperr)
+		return
+	}
+	s.dynamicApps = apps
+	s.applyConfig()
+}
+
+// putDynamicApp registers or updates the application.
+func (s *Supervisor) putDynamicApp(ca *ConfigApplication, create bool) error {
+	if errs := ca.validate(""); len(errs) > 0 {
+		return appErr(400, errs.Error())
+	}
+
+	s.reloadMu.Lock()
+	defer s.reloadMu.Unlock()
+
+	if s.fileConfig.GetApp(ca.Name) != nil {
+		return appErr(409, "Application is defined in the config file")
+	}
+	for _, other := range s.config().Applications {
+		if other.Name == ca.Name {
+			continue
+		}
+		if len(other.secretsFor(ca.Key, time.Now())) > 0 {
+			return appErr(409, "Key is used by another application")
+		}
+	}
+
+	apps := make([]ConfigApplication, 0, len(s.dynamicApps)+1)
+	found := false
+	for _, other := range s.dynamicApps {
+		if other.Name == ca.Name {
+			found = true
+			apps = append(apps, *ca)
+		} else {
+			apps = append(apps, other)
+		}
+	}
+	if create && found {
+		return appErr(409, "Application already exists")
+	}
+	if !create && !found {
+		return appErr(404, "No such application")
+	}
+	if !found {
+		apps = append(apps, *ca)
+	}
+
+	if s.db != nil {
+		if apperr := s.db.PutApplication(ca, create); apperr != nil {
+			return apperr
+		}
+	} else if file := s.fileConfig.Admin.AppsFile; file != "" {
+		if err := writeAppsFile(file, apps); err != nil {
+			return wrapErr(500, err)
+		}
+	}
+	s.dynamicApps = apps
+	s.applyConfig()
+	return nil
+}
+
+// deleteDynamicApp unregisters the application.  Its connections are
+// closed.
+func (s *Supervisor) deleteDynamicApp(appname string) error {
+	s.reloadMu.Lock()
+	defer s.reloadMu.Unlock()
+
+	if s.fileConfig.GetApp(appname) != nil {
+		return appErr(409, "Application is defined in the config file")
+	}
+	apps := make([]ConfigApplication, 0, len(s.dynamicApps))
+	for _, other := range s.dynamicApps {
+		if other.Name != appname {
+			apps = append(apps, other)
+		}
+	}
+	if len(apps) == len(s.dynamicApps) {
+		return appErr(404, "No such application")
+	}
+
+	if s.db != nil {
+		if apperr := s.db.DeleteApplication(appname); apperr != nil {
+			return apperr
+		}
+	} else if file := s.fileConfig.Admin.AppsFile; file != "" {
+		if err := writeAppsFile(file, apps); err != nil {
+			return wrapErr(500, err)
+		}
+	}
+	s.dynamicApps = apps
+	s.applyConfig()
+	return nil
+}
+
+// getDynamicApp returns the application registered via the admin API.
+func (s *Supervisor) getDynamicApp(appname string) (*ConfigApplication, error) {
+	s.reloadMu.Lock()
+	defer s.reloadMu.Unlock()
+	if s.fileConfig.GetApp(appname) != nil {
+		return nil, appErr(409, "Application is defined in the config file")
+	}
+	for _, ca := range s.dynamicApps {
+		if ca.Name == appname {
+			return &ca, nil
+		}
+	}
+	return nil, appErr(404, "No such application")
+}
+
+// adminMiddleware requires the bearer token of the admin API.
+func (s *Supervisor) adminMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := s.config().Admin.Token
+		if token == "" {
+			returnErr(s, w, appErr(http.StatusNotFound, "Admin API is disabled"))
+			return
+		}
+		given := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			returnErr(s, w, appErr(http.StatusUnauthorized, "Invalid admin token"))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func decodeAdminAppRequest(r *http.Request) (*adminAppRequest, error) {
+	var req adminAppRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		return nil, wrapErr(400, err)
+	}
+	if req.Overlap < 0 {
+		return nil, appErr(400, "Overlap must not be negative")
+	}
+	return &req, nil
+}
+
+func (s *Supervisor) adminListApps(w http.ResponseWriter, r *http.Request) {
+	s.reloadMu.Lock()
+	apps := make([]ConfigApplication, 0, len(s.dynamicApps))
+	for _, ca := range s.dynamicApps {
+		apps = append(apps, ca.masked())
+	}
+	s.reloadMu.Unlock()
+	returnJSON(w, adminAppsResponse{Applications: apps})
+}
+
+func (s *Supervisor) adminCreateApp(w http.ResponseWriter, r *http.Request) {
+	req, apperr := decodeAdminAppRequest(r)
+	if apperr != nil {
+		returnErr(s, w, apperr)
+		return
+	}
+	ca := req.ConfigApplication
+	if !appNamePattern.MatchString(ca.Name) {
+		returnErr(s, w, appErr(400, "Invalid application name"))
+		return
+	}
+	if ca.Key == "" {
+		ca.Key = randomHex(10)
+	}
+	if ca.Secret == "" {
+		ca.Secret = randomHex(20)
+	}
+	apperr = s.putDynamicApp(&ca, true)
+	if apperr != nil {
+		returnErr(s, w, apperr)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	returnJSON(w, ca)
+}
+
+// adminUpdateApp replaces the settings of the application.  Empty key
+// and secret are kept as they are.  If the key or the secret is changed
+// and overlap is given, the old pair remains valid for overlap seconds.
+func (s *Supervisor) adminUpdateApp(w http.ResponseWriter, r *http.Request) {
+	appname := mux.Vars(r)["app"]
+	req, apperr := decodeAdminAppRequest(r)
+	if apperr != nil {
+		returnErr(s, w, apperr)
+		return
+	}
+	ca := req.ConfigApplication
+	if ca.Name != "" && ca.Name != appname {
+		returnErr(s, w, appErr(400, "Application name can't be changed"))
+		return
+	}
+	ca.Name = appname
+
+	old, apperr := s.getDynamicApp(appname)
+	if apperr != nil {
+		returnErr(s, w, apperr)
+		return
+	}
+	if ca.Key == "" {
+		ca.Key = old.Key
+	}
+	if ca.Secret == "" {
+		ca.Secret = old.Secret
+	}
+	if ca.Keys == nil {
+		ca.Keys = old.Keys
+	}
+	now := time.Now()
+	if req.Overlap > 0 && (ca.Key != old.Key || ca.Secret != old.Secret) {
+		expires := now.Add(time.Duration(req.Overlap) * time.Second)
+		ca.Keys = append(ca.Keys, ConfigKey{
+			Key:     old.Key,
+			Secret:  old.Secret,
+			Expires: &expires,
+		})
+	}
+	ca.activeKeys(now)
+
+	apperr = s.putDynamicApp(&ca, false)
+	if apperr != nil {
+		returnErr(s, w, apperr)
+		return
+	}
+	returnJSON(w, ca)
+}
+
+func (s *Supervisor) adminDeleteApp(w http.ResponseWriter, r *http.Request) {
+	apperr := s.deleteDynamicApp(mux.Vars(r)["app"])
+	if apperr != nil {
+		returnErr(s, w, apperr)
+		return
+	}
+	returnJSON(w, struct{}{})
+}
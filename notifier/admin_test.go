@@ -0,0 +1,158 @@
+package notifier
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func doAdminRequest(t *testing.T, router http.Handler,
+	method string, path string, body string,
+	expectedCode int) *httptest.ResponseRecorder {
+	req, err := http.NewRequest(method, path, strings.NewReader(body))
+	require.Nil(t, err)
+	req.Header.Set("Authorization", "Bearer admintoken")
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	require.Equal(t, expectedCode, rr.Code, rr.Body.String())
+	return rr
+}
+
+func initAdminTest(t *testing.T, appsFile string) *Supervisor {
+	config, err := ReadConfigFile("../config/sample.json")
+	require.Nil(t, err)
+	config.Admin = ConfigAdmin{Token: "admintoken", AppsFile: appsFile}
+	return NewSupervisor(config)
+}
+
+func TestAdminAuth(t *testing.T) {
+	s := initTest(t, DefaultConfig)
+	defer s.Finish()
+	router := newRouter(s)
+	_ = doRequest(t, router, "GET", "/admin/apps", "", http.StatusNotFound)
+
+	s = initAdminTest(t, "")
+	defer s.Finish()
+	router = newRouter(s)
+	_ = doRequest(t, router, "GET", "/admin/apps", "", http.StatusUnauthorized)
+	rr := doAdminRequest(t, router, "GET", "/admin/apps", "", http.StatusOK)
+	require.Equal(t, J(`{"applications":[]}`), jsonBody(t, rr))
+}
+
+func TestAdminApps(t *testing.T) {
+	appsFile := filepath.Join(t.TempDir(), "apps.json")
+	s := initAdminTest(t, appsFile)
+	defer s.Finish()
+	router := newRouter(s)
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	_ = doAdminRequest(t, router, "POST", "/admin/apps",
+		`{"name":"testapp","key":"k"}`, http.StatusConflict)
+	_ = doAdminRequest(t, router, "POST", "/admin/apps",
+		`{"name":"bad/name"}`, http.StatusBadRequest)
+	_ = doAdminRequest(t, router, "POST", "/admin/apps",
+		`{"name":"newapp","max-connections":-1}`, http.StatusBadRequest)
+	_ = doAdminRequest(t, router, "POST", "/admin/apps",
+		`{"name":"newapp","key":"1234567890"}`, http.StatusConflict)
+
+	rr := doAdminRequest(t, router, "POST", "/admin/apps",
+		`{"name":"newapp","key":"newkey","max-connections":5}`, http.StatusCreated)
+	var created ConfigApplication
+	require.Nil(t, json.Unmarshal(rr.Body.Bytes(), &created))
+	require.Equal(t, "newkey", created.Key)
+	require.NotEqual(t, "", created.Secret)
+	require.Equal(t, 5, s.config().GetApp("newapp").MaxConnections)
+	_ = doAdminRequest(t, router, "POST", "/admin/apps",
+		`{"name":"newapp"}`, http.StatusConflict)
+
+	rr = doAdminRequest(t, router, "GET", "/admin/apps", "", http.StatusOK)
+	require.Equal(t, maskedSecret,
+		jsonBody(t, rr).Get("applications").GetIndex(0).Get("secret").MustString())
+
+	conn := dialTestSocket(t, server, "newkey")
+	defer conn.Close()
+	subscribeTestSocket(t, conn, "my-channel")
+
+	// Rotation keeps the old key valid for the overlap
+	_ = doAdminRequest(t, router, "PUT", "/admin/apps/newapp",
+		`{"key":"newkey2","secret":"newsecret","overlap":3600}`, http.StatusOK)
+	ca := s.config().GetAppFromKey("newkey")
+	require.NotNil(t, ca)
+	require.Equal(t, "newapp", ca.Name)
+	require.Equal(t, "newsecret", ca.Secret)
+	require.Equal(t, 0, ca.MaxConnections)
+	require.NotNil(t, s.config().GetAppFromKey("newkey2"))
+	conn2 := dialTestSocket(t, server, "newkey")
+	defer conn2.Close()
+
+	_ = doAdminRequest(t, router, "PUT", "/admin/apps/newapp",
+		`{"key":"newkey3"}`, http.StatusOK)
+	require.Nil(t, s.config().GetAppFromKey("newkey2"))
+	require.NotNil(t, s.config().GetAppFromKey("newkey"))
+	_ = doAdminRequest(t, router, "PUT", "/admin/apps/testapp",
+		`{}`, http.StatusConflict)
+	_ = doAdminRequest(t, router, "PUT", "/admin/apps/nosuchapp",
+		`{}`, http.StatusNotFound)
+
+	// Persisted in the file
+	s2 := initAdminTest(t, appsFile)
+	defer s2.Finish()
+	require.NotNil(t, s2.config().GetAppFromKey("newkey3"))
+
+	// Deleting closes the connections
+	_ = doAdminRequest(t, router, "DELETE", "/admin/apps/newapp", "", http.StatusOK)
+	data := readTestError(t, conn)
+	require.Equal(t, pusherCodeAppNotFound, data.Code)
+	_ = doAdminRequest(t, router, "DELETE", "/admin/apps/newapp", "", http.StatusNotFound)
+	_ = doAdminRequest(t, router, "DELETE", "/admin/apps/testapp", "", http.StatusConflict)
+
+	s3 := initAdminTest(t, appsFile)
+	defer s3.Finish()
+	require.Nil(t, s3.config().GetApp("newapp"))
+}
+
+func TestAdminSecretRotation(t *testing.T) {
+	s := initAdminTest(t, "")
+	defer s.Finish()
+	router := newRouter(s)
+
+	_ = doAdminRequest(t, router, "POST", "/admin/apps",
+		`{"name":"newapp","key":"newkey","secret":"oldsecret"}`, http.StatusCreated)
+	// Only the secret is rotated
+	_ = doAdminRequest(t, router, "PUT", "/admin/apps/newapp",
+		`{"secret":"newsecret","overlap":3600}`, http.StatusOK)
+	require.Equal(t, "newkey", s.config().GetApp("newapp").Key)
+
+	a, apperr := s.GetApp("newapp")
+	require.Nil(t, apperr)
+	u := &User{App: a}
+	sign := func(secret string) string {
+		return ChannelAuth("newkey", secret, "1.2", "private-chan")
+	}
+	require.True(t, s.checkSignature(u, "private-chan", "1.2", "", sign("newsecret")))
+	require.True(t, s.checkSignature(u, "private-chan", "1.2", "", sign("oldsecret")))
+
+	now := time.Now()
+	body := `{"name":"ev","channels":["chan0"],"data":"{}"}`
+	for _, secret := range []string{"newsecret", "oldsecret"} {
+		_ = doRequest(t, router, "POST",
+			signRESTPath("POST", "/apps/newapp/events", "newkey", secret, now, body),
+			body, http.StatusOK)
+	}
+
+	// Without the overlap, the previous secret is invalidated at once
+	_ = doAdminRequest(t, router, "PUT", "/admin/apps/newapp",
+		`{"secret":"newestsecret"}`, http.StatusOK)
+	require.True(t, s.checkSignature(u, "private-chan", "1.2", "", sign("newestsecret")))
+	require.False(t, s.checkSignature(u, "private-chan", "1.2", "", sign("newsecret")))
+	_ = doRequest(t, router, "POST",
+		signRESTPath("POST", "/apps/newapp/events", "newkey", "newsecret", now, body),
+		body, http.StatusUnauthorized)
+}
@@ -2,6 +2,8 @@ package notifier
 
 import (
 	"fmt"
+	"net"
+	"sync"
 
 	mapset "github.com/deckarep/golang-set"
 	"github.com/gorilla/websocket"
@@ -13,16 +15,26 @@ type User struct {
 	Connection *websocket.Conn
 	App        *Application
 	SocketID   string
+	Client     ClientInfo // negotiated on connection
+	RemoteIP   string
+
+	writeMu sync.Mutex // serializes writes to Connection
+
+	clientEvents tokenBucket // rate limit of client events
+
+	subMu         sync.Mutex
+	subscriptions map[string]int // channel name -> subscription count
 }
 
 // Event is the actual event to be sent.
 type Event struct {
-	Name string
-	Data string
+	Name    string
+	Data    string
+	Exclude string // socket ID not to deliver the event to, if any
 }
 
 // Channel corresponds to Pusher channel.  Channels are implicitly
-// created when subscribed and/or triggered.
+// created when subscribed, and removed when the last subscriber leaves.
 type Channel struct {
 	Name  string
 	Users map[int]int // user id -> subscription count
@@ -33,6 +45,9 @@ type Application struct {
 	Name     string
 	Channels map[string]*Channel
 	Users    mapset.Set // Set of User
+
+	mu         sync.Mutex     // guards Channels and connsPerIP in standalone mode
+	connsPerIP map[string]int // remote IP -> number of connections
 }
 
 //
@@ -53,7 +68,7 @@ func (s *Supervisor) InitApps() {
 
 // GetApp returns the named application.
 func (s *Supervisor) GetApp(name string) (*Application, error) {
-	for _, a := range s.Apps {
+	for _, a := range s.apps() {
 		if a.Name == name {
 			return a, nil
 		}
@@ -63,7 +78,7 @@ func (s *Supervisor) GetApp(name string) (*Application, error) {
 
 // GetAppFromKey returns the application with specified key.
 func (s *Supervisor) GetAppFromKey(key string) (*Application, error) {
-	ca := s.Config.GetAppFromKey(key)
+	ca := s.config().GetAppFromKey(key)
 	if ca != nil {
 		return s.GetApp(ca.Name)
 	}
@@ -80,7 +95,7 @@ func (s *Supervisor) GetChannels(appname string) (map[string]*Channel, error) {
 	if s.db != nil {
 		return s.db.GetChannels(appname)
 	}
-	return app.Channels, nil
+	return app.getChannels(), nil
 }
 
 // GetChannel returns the named channel in the named application.
@@ -96,6 +111,21 @@ func (s *Supervisor) GetChannel(appname string, channame string) (*Channel, erro
 	return a.getChannel(channame)
 }
 
+// LookupChannel returns the named channel in the named application.
+// If there's no such channel, an empty channel is returned without
+// creating it.
+func (s *Supervisor) LookupChannel(appname string, channame string) (*Channel, error) {
+	_, apperr := s.GetApp(appname)
+	if apperr != nil {
+		return nil, apperr
+	}
+	ch, apperr := s.GetChannel(appname, channame)
+	if e, ok := apperr.(*appError); ok && e.Code == 404 {
+		return &Channel{Name: channame, Users: make(map[int]int)}, nil
+	}
+	return ch, apperr
+}
+
 // GetOrCreateChannel returns the named channel in the named application.
 // If there's no such channel, create it.
 func (s *Supervisor) GetOrCreateChannel(appname string, channame string) (*Channel, error) {
@@ -110,24 +140,50 @@ func (s *Supervisor) GetOrCreateChannel(appname string, channame string) (*Chann
 }
 
 // AddUser creates a new user associated to an application, with the
-// given connection.
+// given connection.  If the application is over connection quota,
+// an error with pusherCodeOverQuota is returned.
 func (s *Supervisor) AddUser(appname string, conn *websocket.Conn) (*User, error) {
 	a, apperr := s.GetApp(appname)
 	if apperr != nil {
 		return nil, apperr
 	}
+	var quota ConfigApplication
+	if ca := s.config().GetApp(appname); ca != nil {
+		quota = *ca
+	}
+	ip := remoteIP(conn)
 
-	var uid int
 	if s.db != nil {
-		uid, apperr = s.db.allocateUserID(appname)
+		uid, apperr := s.db.allocateUserID(appname, quota.MaxConnections)
 		if apperr != nil {
 			return nil, apperr
 		}
-	} else {
-		uid = a.allocateUserID()
+		apperr = s.db.addConnection(appname, s.nodeID, uid, ip, quota.MaxConnectionsPerIP)
+		if apperr != nil {
+			_ = s.db.DeleteUserID(appname, uid)
+			return nil, apperr
+		}
+		u := a.registerUser(uid, conn)
+		u.RemoteIP = ip
+		return u, nil
 	}
 
-	u := a.registerUser(uid, conn)
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if quota.MaxConnections > 0 && a.Users.Cardinality() >= quota.MaxConnections {
+		return nil, overQuotaErr("Application is over connection quota")
+	}
+	if ip != "" {
+		if a.connsPerIP == nil {
+			a.connsPerIP = make(map[string]int)
+		}
+		if quota.MaxConnectionsPerIP > 0 && a.connsPerIP[ip] >= quota.MaxConnectionsPerIP {
+			return nil, overQuotaErr("Too many connections from " + ip)
+		}
+		a.connsPerIP[ip]++
+	}
+	u := a.registerUser(a.allocateUserID(), conn)
+	u.RemoteIP = ip
 	return u, nil
 }
 
@@ -139,6 +195,13 @@ func (s *Supervisor) RemoveUser(appname string, uid int) error {
 	if apperr != nil {
 		return apperr
 	}
+	return s.removeUser(a, uid)
+}
+
+// removeUser is RemoveUser on the given application, which may have been
+// removed from the configuration.
+func (s *Supervisor) removeUser(a *Application, uid int) error {
+	appname := a.Name
 	u := a.GetUserByID(uid)
 	if u == nil {
 		return appErr(500,
@@ -147,34 +210,54 @@ func (s *Supervisor) RemoveUser(appname string, uid int) error {
 	}
 
 	if s.db != nil {
-		chs, apperr := s.db.GetChannels(appname)
+		registered, apperr := s.db.removeConnection(appname, s.nodeID, uid)
 		if apperr != nil {
-			return apperr
+			s.logger.Infow("removeConnection failed",
+				"app", appname, "uid", uid, "error", apperr)
 		}
-		for _, c := range chs {
-			_, ok := c.Users[uid]
-			if ok {
-				apperr = s.db.DeleteUserIDFromChannel(appname,
-					c.Name, uid)
-				if apperr != nil {
-					s.logger.Infow("DeleteUserIDFromChannels failed (app=%s, channel=%s, uid=%d)",
-						appname, c.Name, uid)
-				}
+		// Unless another process has cleaned up after this one,
+		// regarding it as dead
+		if registered || apperr != nil {
+			apperr = s.dropRedisUser(appname, uid)
+			if apperr != nil {
+				return apperr
 			}
 		}
-		apperr = s.db.DeleteUserID(appname, uid)
-		if apperr != nil {
-			return apperr
-		}
 	} else {
-		for _, c := range u.App.Channels {
-			c.UnsubscribeUser(uid)
+		for _, cn := range a.dropUser(uid) {
+			s.subscriptionCountChanged(appname, cn)
 		}
+		a.releaseIP(u.RemoteIP)
 	}
 	a.unregisterUser(uid)
 	return nil
 }
 
+// dropRedisUser removes the user from the channels and the users of
+// the application in Redis.
+func (s *Supervisor) dropRedisUser(appname string, uid int) error {
+	chs, apperr := s.db.GetChannels(appname)
+	if apperr != nil {
+		return apperr
+	}
+	for _, c := range chs {
+		_, ok := c.Users[uid]
+		if ok {
+			apperr = s.db.DropUserIDFromChannel(appname,
+				c.Name, uid)
+			if apperr != nil {
+				s.logger.Infow("DropUserIDFromChannel failed",
+					"app", appname,
+					"channel", c.Name,
+					"uid", uid,
+					"error", apperr)
+			}
+			s.subscriptionCountChanged(appname, c.Name)
+		}
+	}
+	return s.db.DeleteUserID(appname, uid)
+}
+
 // Subscribe let the user subscribe the named channel
 // The user with UID must be managed by this process (when socket.go calls
 // this, it should.)
@@ -189,16 +272,25 @@ func (s *Supervisor) Subscribe(appname string, uid int, channame string) error {
 			fmt.Sprintf("Subscribe called on an unmanaged user (app=%s, uid=%d, channel=%s)",
 				appname, uid, channame))
 	}
+	var quota ConfigApplication
+	if ca := s.config().GetApp(appname); ca != nil {
+		quota = *ca
+	}
 
+	apperr = u.addSubscription(channame, quota.MaxSubscriptionsPerConnection)
+	if apperr != nil {
+		return apperr
+	}
 	if s.db != nil {
-		apperr = s.db.AddUserIDToChannel(appname, channame, uid)
-		if apperr != nil {
-			return apperr
-		}
+		apperr = s.db.AddUserIDToChannel(appname, channame, uid, quota.MaxChannels)
 	} else {
-		ch, _ := u.App.getOrCreateChannel(channame)
-		ch.SubscribeUser(uid)
+		apperr = a.subscribeUser(channame, uid, quota.MaxChannels)
+	}
+	if apperr != nil {
+		u.removeSubscription(channame)
+		return apperr
 	}
+	s.subscriptionCountChanged(appname, channame)
 	return nil
 }
 
@@ -217,17 +309,16 @@ func (s *Supervisor) Unsubscribe(appname string, uid int, channame string) error
 				appname, uid, channame))
 	}
 
+	u.removeSubscription(channame)
 	if s.db != nil {
 		apperr = s.db.DeleteUserIDFromChannel(appname, channame, uid)
 		if apperr != nil {
 			return apperr
 		}
 	} else {
-		ch, ok := u.App.Channels[channame]
-		if ok {
-			ch.UnsubscribeUser(uid)
-		}
+		a.unsubscribeUser(channame, uid)
 	}
+	s.subscriptionCountChanged(appname, channame)
 	return nil
 }
 
@@ -235,24 +326,103 @@ func (s *Supervisor) Unsubscribe(appname string, uid int, channame string) error
 // Applications
 //
 
-// This is only used in standalone mode
+// This is only used in standalone mode.
+// Returns a copy of the channel, as DB.GetChannel does.
 func (a *Application) getChannel(channame string) (*Channel, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
 	ch, ok := a.Channels[channame]
 	if ok {
-		return ch, nil
+		return ch.clone(), nil
 	}
 	return nil, appErr(404, "No such channel")
 }
 
+// This is only used in standalone mode.
+// Returns a snapshot of the channels.
+func (a *Application) getChannels() map[string]*Channel {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	chs := make(map[string]*Channel, len(a.Channels))
+	for name, ch := range a.Channels {
+		chs[name] = ch.clone()
+	}
+	return chs
+}
+
 // This is only used in standalone mode
 func (a *Application) getOrCreateChannel(channame string) (*Channel, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.getOrCreateChannelLocked(channame), nil
+}
+
+func (a *Application) getOrCreateChannelLocked(channame string) *Channel {
 	ch, ok := a.Channels[channame]
 	if ok {
-		return ch, nil
+		return ch
 	}
 	ch = &Channel{Name: channame, Users: make(map[int]int)}
 	a.Channels[channame] = ch
-	return ch, nil
+	return ch
+}
+
+// This is only used in standalone mode.
+// If maxChannels is positive, a new channel can't be created beyond it.
+func (a *Application) subscribeUser(channame string, uid int, maxChannels int) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if _, ok := a.Channels[channame]; !ok && maxChannels > 0 && len(a.Channels) >= maxChannels {
+		return overQuotaErr("Application is over channel quota")
+	}
+	a.getOrCreateChannelLocked(channame).SubscribeUser(uid)
+	return nil
+}
+
+// This is only used in standalone mode.
+// The channel is removed when the last subscription is removed.
+func (a *Application) unsubscribeUser(channame string, uid int) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	ch, ok := a.Channels[channame]
+	if !ok {
+		return
+	}
+	ch.UnsubscribeUser(uid)
+	if ch.UserCount() == 0 {
+		delete(a.Channels, channame)
+	}
+}
+
+// This is only used in standalone mode.
+// Removes all the subscriptions of the user, and returns the names of
+// the channels the user has subscribed.
+func (a *Application) dropUser(uid int) []string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	var names []string
+	for name, ch := range a.Channels {
+		if ch.DropUser(uid) > 0 {
+			names = append(names, name)
+			if ch.UserCount() == 0 {
+				delete(a.Channels, name)
+			}
+		}
+	}
+	return names
+}
+
+// This is only used in standalone mode.
+func (a *Application) releaseIP(ip string) {
+	if ip == "" {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.connsPerIP[ip]--
+	if a.connsPerIP[ip] <= 0 {
+		delete(a.connsPerIP, ip)
+	}
 }
 
 func newUser(id int, conn *websocket.Conn) *User {
@@ -262,6 +432,72 @@ func newUser(id int, conn *websocket.Conn) *User {
 	}
 }
 
+// GetUsage returns the number of connections and channels of the
+// application, to be compared against its quotas.
+func (s *Supervisor) GetUsage(appname string) (int, int, error) {
+	a, apperr := s.GetApp(appname)
+	if apperr != nil {
+		return 0, 0, apperr
+	}
+	if s.db != nil {
+		uids, apperr := s.db.GetAllUserIDs(appname)
+		if apperr != nil {
+			return 0, 0, apperr
+		}
+		chans, apperr := s.db.CountChannels(appname)
+		if apperr != nil {
+			return 0, 0, apperr
+		}
+		return len(uids), chans, nil
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.Users.Cardinality(), len(a.Channels), nil
+}
+
+// remoteIP returns the IP address of the peer of conn, or an empty
+// string if unknown.
+func remoteIP(conn *websocket.Conn) string {
+	if conn == nil {
+		return ""
+	}
+	addr := conn.RemoteAddr().String()
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	return host
+}
+
+// addSubscription records the user's subscription to the channel.
+// If max is positive, subscribing to more than max distinct channels
+// fails with an over quota error.
+func (u *User) addSubscription(channame string, max int) error {
+	u.subMu.Lock()
+	defer u.subMu.Unlock()
+	if u.subscriptions == nil {
+		u.subscriptions = make(map[string]int)
+	}
+	if _, ok := u.subscriptions[channame]; !ok && max > 0 && len(u.subscriptions) >= max {
+		return overQuotaErr("Too many subscriptions on this connection")
+	}
+	u.subscriptions[channame]++
+	return nil
+}
+
+// removeSubscription reverts addSubscription.
+func (u *User) removeSubscription(channame string) {
+	u.subMu.Lock()
+	defer u.subMu.Unlock()
+	if _, ok := u.subscriptions[channame]; !ok {
+		return
+	}
+	u.subscriptions[channame]--
+	if u.subscriptions[channame] <= 0 {
+		delete(u.subscriptions, channame)
+	}
+}
+
 // GetUserByID returns a user with the given ID, or nil.
 // (NB: Expect nil return value, for the user may not be managed by
 // this process.)
@@ -349,6 +585,22 @@ func (c *Channel) SubscribeUser(uid int) int {
 	return n + 1
 }
 
+// DropUser removes all the subscriptions of the user of uid.
+// Returns the number of removed subscriptions.
+func (c *Channel) DropUser(uid int) int {
+	n := c.Users[uid]
+	delete(c.Users, uid)
+	return n
+}
+
+func (c *Channel) clone() *Channel {
+	users := make(map[int]int, len(c.Users))
+	for uid, n := range c.Users {
+		users[uid] = n
+	}
+	return &Channel{Name: c.Name, Users: users}
+}
+
 // UnsubscribeUser let the user of uid unsubscribe the channel.
 // Returns the updated number of subscribers of the channel.
 func (c *Channel) UnsubscribeUser(uid int) int {
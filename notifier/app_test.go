@@ -1,6 +1,7 @@
 package notifier
 
 import (
+	"net/http"
 	"testing"
 
 	mapset "github.com/deckarep/golang-set"
@@ -21,3 +22,97 @@ func TestRegsiterUser(t *testing.T) {
 	_ = app.registerUser(30, nil)
 	require.Equal(t, app.Users.Cardinality(), 3)
 }
+
+func TestChannelLifecycle(t *testing.T) {
+	s := initTest(t, DefaultConfig)
+	router := newRouter(s)
+
+	_ = doRequest(t, router, "GET", "/apps/testapp/channels/chan0", "", http.StatusOK)
+	_ = doRequest(t, router, "GET", "/apps/testapp/channels/chan0/users", "", http.StatusOK)
+	_ = doRequest(t, router, "POST", "/apps/testapp/events",
+		`{"name":"ev","channels":["chan0"],"data":"{}"}`, http.StatusOK)
+	chs, apperr := s.GetChannels("testapp")
+	require.Nil(t, apperr)
+	require.Equal(t, 0, len(chs))
+
+	u0, apperr := s.AddUser("testapp", nil)
+	require.Nil(t, apperr)
+	u1, apperr := s.AddUser("testapp", nil)
+	require.Nil(t, apperr)
+
+	require.Nil(t, s.Subscribe("testapp", u0.ID, "chan0"))
+	require.Nil(t, s.Subscribe("testapp", u0.ID, "chan0"))
+	require.Nil(t, s.Subscribe("testapp", u0.ID, "chan1"))
+	require.Nil(t, s.Subscribe("testapp", u1.ID, "chan1"))
+
+	chs, apperr = s.GetChannels("testapp")
+	require.Nil(t, apperr)
+	require.Equal(t, 2, len(chs))
+
+	require.Nil(t, s.Unsubscribe("testapp", u0.ID, "chan0"))
+	ch, apperr := s.GetChannel("testapp", "chan0")
+	require.Nil(t, apperr)
+	require.Equal(t, map[int]int{u0.ID: 1}, ch.Users)
+
+	require.Nil(t, s.Unsubscribe("testapp", u0.ID, "chan0"))
+	_, apperr = s.GetChannel("testapp", "chan0")
+	require.NotNil(t, apperr)
+
+	// Removing user drops all the subscriptions
+	require.Nil(t, s.Subscribe("testapp", u1.ID, "chan1"))
+	require.Nil(t, s.RemoveUser("testapp", u1.ID))
+	ch, apperr = s.GetChannel("testapp", "chan1")
+	require.Nil(t, apperr)
+	require.Equal(t, map[int]int{u0.ID: 1}, ch.Users)
+
+	require.Nil(t, s.RemoveUser("testapp", u0.ID))
+	chs, apperr = s.GetChannels("testapp")
+	require.Nil(t, apperr)
+	require.Equal(t, 0, len(chs))
+}
+
+func TestQuotas(t *testing.T) {
+	s := initTest(t, DefaultConfig)
+	defer s.Finish()
+	router := newRouter(s)
+	ca := &s.Config.Applications[0]
+	require.Equal(t, "testapp", ca.Name)
+	ca.MaxConnections = 2
+	ca.MaxSubscriptionsPerConnection = 2
+	ca.MaxChannels = 3
+
+	u0, apperr := s.AddUser("testapp", nil)
+	require.Nil(t, apperr)
+	u1, apperr := s.AddUser("testapp", nil)
+	require.Nil(t, apperr)
+	_, apperr = s.AddUser("testapp", nil)
+	require.NotNil(t, apperr)
+	require.Equal(t, pusherCodeOverQuota, apperr.(*appError).PusherCode)
+
+	require.Nil(t, s.Subscribe("testapp", u0.ID, "chan0"))
+	require.Nil(t, s.Subscribe("testapp", u0.ID, "chan1"))
+	require.Nil(t, s.Subscribe("testapp", u0.ID, "chan1"))
+	apperr = s.Subscribe("testapp", u0.ID, "chan2")
+	require.NotNil(t, apperr)
+	require.Equal(t, 429, apperr.(*appError).Code)
+
+	require.Nil(t, s.Subscribe("testapp", u1.ID, "chan2"))
+	apperr = s.Subscribe("testapp", u1.ID, "chan3")
+	require.NotNil(t, apperr)
+	require.Equal(t, pusherCodeOverQuota, apperr.(*appError).PusherCode)
+	_, apperr = s.GetChannel("testapp", "chan3")
+	require.NotNil(t, apperr)
+
+	rr := doRequest(t, router, "GET", "/apps/testapp/usage", "", http.StatusOK)
+	require.Equal(t, J(`{"connections":2,"channels":3,
+		"limits":{"max-connections":2,"max-connections-per-ip":0,
+		"max-subscriptions-per-connection":2,"max-channels":3}}`),
+		jsonBody(t, rr))
+
+	// Quotas are released on removal
+	require.Nil(t, s.Unsubscribe("testapp", u0.ID, "chan0"))
+	require.Nil(t, s.Subscribe("testapp", u0.ID, "chan3"))
+	require.Nil(t, s.RemoveUser("testapp", u1.ID))
+	_, apperr = s.AddUser("testapp", nil)
+	require.Nil(t, apperr)
+}
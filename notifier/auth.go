@@ -0,0 +1,167 @@
+package notifier
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// REST API requests are signed by Pusher server libraries.
+// See https://pusher.com/docs/channels/library_auth_reference/rest-api/#authentication
+
+const (
+	// Requests signed more than this far from now are rejected.
+	maxAuthTimestampSkew = 600 * time.Second
+)
+
+// restSigningString returns the string signed for the request, which
+// is the method, the path and the sorted query parameters except
+// auth_signature, separated by newlines.
+func restSigningString(method string, path string, query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		if k != "auth_signature" {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	params := make([]string, 0, len(keys))
+	for _, k := range keys {
+		for _, v := range query[k] {
+			params = append(params, strings.ToLower(k)+"="+v)
+		}
+	}
+	return method + "\n" + path + "\n" + strings.Join(params, "&")
+}
+
+// hmacHex returns HMAC SHA256 hex digest of the data.
+func hmacHex(secret string, data string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	_, _ = mac.Write([]byte(data))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// SignRESTQuery returns the query parameters of the REST API request
+// with the signature added, as Pusher server libraries make.  The body
+// is that of POST requests, or nil.
+func SignRESTQuery(method string, path string, query url.Values, body []byte,
+	key string, secret string, now time.Time) url.Values {
+	signed := url.Values{}
+	for k, v := range query {
+		signed[k] = v
+	}
+	signed.Set("auth_key", key)
+	signed.Set("auth_timestamp", strconv.FormatInt(now.Unix(), 10))
+	signed.Set("auth_version", "1.0")
+	if len(body) > 0 {
+		digest := md5.Sum(body)
+		signed.Set("body_md5", hex.EncodeToString(digest[:]))
+	}
+	signed.Set("auth_signature", hmacHex(secret, restSigningString(method, path, signed)))
+	return signed
+}
+
+// ChannelAuth returns the auth to subscribe to the private channel,
+// "<key>:<signature>", which is usually made by the auth endpoint of
+// the application server.
+func ChannelAuth(key string, secret string, socketID string, channel string) string {
+	return key + ":" + hmacHex(secret, socketID+":"+channel)
+}
+
+// PresenceChannelAuth returns the auth to subscribe to the presence
+// channel, which signs channelData, the JSON of the user, as well.
+func PresenceChannelAuth(key string, secret string, socketID string, channel string, channelData string) string {
+	return key + ":" + hmacHex(secret, socketID+":"+channel+":"+channelData)
+}
+
+// checkRESTAuth verifies the signature of the REST API request.
+// Signatures made with the secret of any active key are accepted.
+func (ca *ConfigApplication) checkRESTAuth(r *http.Request, body []byte, now time.Time) error {
+	query := r.URL.Query()
+	secrets := ca.secretsFor(query.Get("auth_key"), now)
+	if len(secrets) == 0 {
+		return appErr(http.StatusUnauthorized, "Unknown auth_key")
+	}
+	ts, err := strconv.ParseInt(query.Get("auth_timestamp"), 10, 64)
+	if err != nil {
+		return appErr(http.StatusUnauthorized, "Invalid auth_timestamp")
+	}
+	skew := now.Sub(time.Unix(ts, 0))
+	if skew > maxAuthTimestampSkew || skew < -maxAuthTimestampSkew {
+		return appErr(http.StatusUnauthorized,
+			"Timestamp expired: check the clock of the server")
+	}
+	if len(body) > 0 {
+		digest := md5.Sum(body)
+		if query.Get("body_md5") != hex.EncodeToString(digest[:]) {
+			return appErr(http.StatusUnauthorized, "Invalid body_md5")
+		}
+	}
+
+	signString := restSigningString(r.Method, r.URL.Path, query)
+	for _, secret := range secrets {
+		expected := hmacHex(secret, signString)
+		if hmac.Equal([]byte(query.Get("auth_signature")), []byte(expected)) {
+			return nil
+		}
+	}
+	return appErr(http.StatusUnauthorized, "Invalid signature")
+}
+
+// authMiddleware verifies signed REST API requests.  Unsigned requests
+// are rejected only if the application requires signatures.  The size
+// of the body is limited, for it's read before the verification.
+func (s *Supervisor) authMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		appname := mux.Vars(r)["app"]
+		if appname == "" || r.Method == http.MethodOptions {
+			next.ServeHTTP(w, r)
+			return
+		}
+		ca := s.config().GetApp(appname)
+		if ca == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, ca.maxBodySize())
+		if !r.URL.Query().Has("auth_signature") && !ca.RequireSignature {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			returnErr(s, w, bodyErr(err))
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		if apperr := ca.checkRESTAuth(r, body, time.Now()); apperr != nil {
+			authFailures.WithLabelValues(appname, "api").Inc()
+			returnErr(s, w, apperr)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// warnUnsignedAPI warns of the applications accepting unsigned REST API
+// requests, with which anyone reaching the API can trigger events.
+func (s *Supervisor) warnUnsignedAPI() {
+	for _, ca := range s.config().Applications {
+		if !ca.RequireSignature {
+			s.logger.Warnw("REST API accepts unsigned requests; set require-signature to reject them",
+				"app", ca.Name)
+		}
+	}
+}
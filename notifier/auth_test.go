@@ -0,0 +1,161 @@
+package notifier
+
+import (
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// signRESTPath signs the request as Pusher server libraries do.
+func signRESTPath(method string, path string, key string, secret string,
+	ts time.Time, body string) string {
+	return signRESTPathQuery(method, path, url.Values{}, key, secret, ts, body)
+}
+
+// signRESTPathQuery signs the request with the query parameters.
+func signRESTPathQuery(method string, path string, params url.Values, key string, secret string,
+	ts time.Time, body string) string {
+	params.Set("auth_key", key)
+	params.Set("auth_timestamp", fmt.Sprint(ts.Unix()))
+	params.Set("auth_version", "1.0")
+	if body != "" {
+		digest := md5.Sum([]byte(body))
+		params.Set("body_md5", hex.EncodeToString(digest[:]))
+	}
+	query, _ := url.QueryUnescape(params.Encode())
+	mac := hmac.New(sha256.New, []byte(secret))
+	_, _ = mac.Write([]byte(method + "\n" + path + "\n" + query))
+	params.Set("auth_signature", hex.EncodeToString(mac.Sum(nil)))
+	return path + "?" + params.Encode()
+}
+
+func TestRESTAuth(t *testing.T) {
+	s := initTest(t, DefaultConfig)
+	defer s.Finish()
+	expired := time.Now().Add(-time.Hour)
+	ca := &s.Config.Applications[0]
+	ca.RequireSignature = true
+	ca.Keys = []ConfigKey{
+		{Key: "oldkey", Secret: "oldsecret", Expires: nil},
+		{Key: "expiredkey", Secret: "expiredsecret", Expires: &expired},
+		// Only the secret is rotated
+		{Key: "1234567890", Secret: "oldabcdefghij"},
+		{Key: "1234567890", Secret: "expiredabcdefghij", Expires: &expired},
+	}
+	router := newRouter(s)
+	now := time.Now()
+	body := `{"name":"ev","channels":["chan0"],"data":"{}"}`
+
+	_ = doRequest(t, router, "GET", "/apps/testapp/channels", "", http.StatusUnauthorized)
+	_ = doRequest(t, router, "GET",
+		signRESTPath("GET", "/apps/testapp/channels", "1234567890", "abcdefghij", now, ""),
+		"", http.StatusOK)
+	_ = doRequest(t, router, "POST",
+		signRESTPath("POST", "/apps/testapp/events", "1234567890", "abcdefghij", now, body),
+		body, http.StatusOK)
+	_ = doRequest(t, router, "POST",
+		signRESTPath("POST", "/apps/testapp/events", "oldkey", "oldsecret", now, body),
+		body, http.StatusOK)
+	_ = doRequest(t, router, "POST",
+		signRESTPath("POST", "/apps/testapp/events", "1234567890", "oldabcdefghij", now, body),
+		body, http.StatusOK)
+
+	for _, path := range []string{
+		signRESTPath("POST", "/apps/testapp/events", "expiredkey", "expiredsecret", now, body),
+		signRESTPath("POST", "/apps/testapp/events", "1234567890", "wrongsecret", now, body),
+		signRESTPath("POST", "/apps/testapp/events", "1234567890", "expiredabcdefghij", now, body),
+		signRESTPath("POST", "/apps/testapp/events", "1234567890", "abcdefghij",
+			now.Add(-time.Hour), body),
+		signRESTPath("POST", "/apps/testapp/events", "1234567890", "abcdefghij", now, "{}"),
+	} {
+		_ = doRequest(t, router, "POST", path, body, http.StatusUnauthorized)
+	}
+
+	// As signed by notifierctl
+	query := SignRESTQuery("GET", "/apps/testapp/channels", url.Values{"filter_by_prefix": {"presence-"}},
+		nil, "1234567890", "abcdefghij", now)
+	_ = doRequest(t, router, "GET", "/apps/testapp/channels?"+query.Encode(), "", http.StatusOK)
+	query = SignRESTQuery("POST", "/apps/testapp/events", nil, []byte(body), "oldkey", "oldsecret", now)
+	_ = doRequest(t, router, "POST", "/apps/testapp/events?"+query.Encode(), body, http.StatusOK)
+
+	// Signed requests are verified even if not required
+	_ = doRequest(t, router, "GET", "/apps/testapp2/channels", "", http.StatusOK)
+	path := signRESTPath("GET", "/apps/testapp2/channels", "anystringwilldo", "xyzzy", now, "")
+	_ = doRequest(t, router, "GET", path, "", http.StatusOK)
+	_ = doRequest(t, router, "GET", strings.Replace(path, "auth_signature=", "auth_signature=0", 1),
+		"", http.StatusUnauthorized)
+}
+
+func TestChannelAuthWithKeys(t *testing.T) {
+	s := initTest(t, DefaultConfig)
+	defer s.Finish()
+	expired := time.Now().Add(-time.Hour)
+	s.Config.Applications[0].Keys = []ConfigKey{
+		{Key: "oldkey", Secret: "oldsecret"},
+		{Key: "expiredkey", Secret: "expiredsecret", Expires: &expired},
+		// Only the secret is rotated
+		{Key: "1234567890", Secret: "oldabcdefghij"},
+		{Key: "1234567890", Secret: "expiredabcdefghij", Expires: &expired},
+	}
+	a, apperr := s.GetApp("testapp")
+	require.Nil(t, apperr)
+	u := &User{App: a}
+
+	sign := func(key string, secret string) string {
+		return key + ":" + webhookSignature(secret, []byte("1.2:private-chan"))
+	}
+	require.True(t, s.checkSignature(u, "private-chan", "1.2", "", sign("1234567890", "abcdefghij")))
+	require.True(t, s.checkSignature(u, "private-chan", "1.2", "", sign("oldkey", "oldsecret")))
+	require.False(t, s.checkSignature(u, "private-chan", "1.2", "", sign("oldkey", "abcdefghij")))
+	require.False(t, s.checkSignature(u, "private-chan", "1.2", "", sign("expiredkey", "expiredsecret")))
+	require.True(t, s.checkSignature(u, "private-chan", "1.2", "", sign("1234567890", "oldabcdefghij")))
+	require.False(t, s.checkSignature(u, "private-chan", "1.2", "", sign("1234567890", "expiredabcdefghij")))
+	require.False(t, s.checkSignature(u, "private-chan", "1.2", "", sign("1234567890", "oldsecret")))
+	require.Equal(t, sign("oldkey", "oldsecret"), ChannelAuth("oldkey", "oldsecret", "1.2", "private-chan"))
+
+	require.NotNil(t, s.config().GetAppFromKey("oldkey"))
+	require.Nil(t, s.config().GetAppFromKey("expiredkey"))
+}
+
+func TestRESTAuthLimits(t *testing.T) {
+	s := initTest(t, DefaultConfig)
+	defer s.Finish()
+	ca := &s.Config.Applications[0]
+	ca.RequireSignature = true
+	ca.APIRateLimit = ConfigRateLimit{Rate: 0.1, Burst: 1}
+	ca.MaxPayloadSize = 10
+	router := newRouter(s)
+	now := time.Now()
+
+	// Requests failing the authentication don't take the tokens
+	for i := 0; i < 3; i++ {
+		_ = doRequest(t, router, "GET", "/apps/testapp/channels", "", http.StatusUnauthorized)
+		_ = doRequest(t, router, "GET",
+			signRESTPath("GET", "/apps/testapp/channels", "1234567890", "wrongsecret", now, ""),
+			"", http.StatusUnauthorized)
+	}
+	_ = doRequest(t, router, "GET",
+		signRESTPath("GET", "/apps/testapp/channels", "1234567890", "abcdefghij", now, ""),
+		"", http.StatusOK)
+	_ = doRequest(t, router, "GET",
+		signRESTPath("GET", "/apps/testapp/channels", "1234567890", "abcdefghij", now, ""),
+		"", http.StatusTooManyRequests)
+
+	// Bodies are limited before the verification
+	large := strings.Repeat("x", int(ca.maxBodySize())+1)
+	_ = doRequest(t, router, "POST",
+		signRESTPath("POST", "/apps/testapp/events", "1234567890", "abcdefghij", now, "{}"),
+		large, http.StatusRequestEntityTooLarge)
+	large = strings.Repeat("x", int(s.Config.Applications[1].maxBodySize())+1)
+	_ = doRequest(t, router, "POST", "/apps/testapp2/events", `{"name":"`+large+`"}`,
+		http.StatusRequestEntityTooLarge)
+}
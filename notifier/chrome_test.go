@@ -0,0 +1,94 @@
+//go:build chrome_test
+
+package notifier
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/chromedp/chromedp"
+	"github.com/stretchr/testify/require"
+)
+
+// Smoke test with pusher-js in Chrome.  The protocol is covered by the
+// tests with pushertest; this makes sure that the real client library
+// works with the notifier.
+
+// pusherJSContent is the page subscribing to a private channel.
+func pusherJSContent(port string) string {
+	return `
+<head>
+  <title>Pusher Test</title>
+  <script src="https://js.pusher.com/4.4/pusher.min.js"></script>
+  <script>
+    Pusher.logToConsole = true;
+
+    var pusher = new Pusher('1234567890', {
+      wsHost: 'localhost',
+      wsPort: ` + port + `,
+      authEndpoint: '/auth',
+      forceTLS: false
+    });
+
+    var events = [];
+
+    var channel = pusher.subscribe('private-my-channel');
+    channel.bind('my-event', function(data) {
+      events.push(data.message);
+    });
+  </script>
+</head>
+`
+}
+
+func TestPusherJS(t *testing.T) {
+	ts := spawnServers(t, DefaultConfig)
+	defer ts.Close()
+	client := ts.PusherClient
+
+	// Application server with the auth endpoint
+	port := regexp.MustCompile(`:(\d+)$`).FindStringSubmatch(ts.Notifier.URL)[1]
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, pusherJSContent(port))
+	})
+	mux.HandleFunc("/auth", func(w http.ResponseWriter, r *http.Request) {
+		params, _ := io.ReadAll(r.Body)
+		response, err := client.AuthenticatePrivateChannel(params)
+		if err != nil {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write(response)
+	})
+	content := httptest.NewServer(mux)
+	defer content.Close()
+
+	ctx, cancel := chromedp.NewContext(context.Background())
+	defer cancel()
+	ctx, cancel = context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	require.Nil(t, chromedp.Run(ctx, chromedp.Navigate(content.URL)))
+
+	// Wait for subscription
+	require.Eventually(t, func() bool {
+		users, err := client.GetChannelUsers("private-my-channel")
+		require.Nil(t, err)
+		return len(users.List) == 1
+	}, 10*time.Second, 50*time.Millisecond)
+
+	err := client.Trigger("private-my-channel", "my-event", map[string]string{"message": "knock, knock"})
+	require.Nil(t, err)
+	var res []string
+	require.Eventually(t, func() bool {
+		require.Nil(t, chromedp.Run(ctx, chromedp.Evaluate("events", &res)))
+		return len(res) > 0
+	}, 10*time.Second, 50*time.Millisecond)
+	require.Equal(t, []string{"knock, knock"}, res)
+}
@@ -0,0 +1,88 @@
+package notifier
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// isAuthenticatedChannel returns true if subscriptions to the channel
+// must be signed by the application server.
+func isAuthenticatedChannel(name string) bool {
+	return strings.HasPrefix(name, "private-") ||
+		strings.HasPrefix(name, "presence-")
+}
+
+// isClientEventChannel returns true if clients can trigger events on
+// the channel.  Only authenticated channels are allowed.
+// See https://pusher.com/docs/channels/using_channels/events/#triggering-client-events
+func isClientEventChannel(name string) bool {
+	return isAuthenticatedChannel(name)
+}
+
+// subscribed returns true if the user subscribes the channel.
+func (u *User) subscribed(channame string) bool {
+	u.subMu.Lock()
+	defer u.subMu.Unlock()
+	return u.subscriptions[channame] > 0
+}
+
+// handleClientEvent broadcasts an event sent by the client to the other
+// subscribers of the channel.  Errors are reported to the client, and
+// the connection remains.
+func (s *Supervisor) handleClientEvent(u *User, name string, channame string, data any) {
+	ca := s.config().GetApp(u.App.Name)
+	if ca == nil || !ca.ClientEvents {
+		s.socketSendError(u, pusherErr(http.StatusForbidden, pusherCodeGeneric,
+			"Client events are not enabled for this application"))
+		return
+	}
+	if apperr := validateEventName(name); apperr != nil {
+		s.socketSendError(u, apperr)
+		return
+	}
+	if !isClientEventChannel(channame) {
+		s.socketSendError(u, pusherErr(http.StatusForbidden, pusherCodeGeneric,
+			"Client events are only supported on private and presence channels"))
+		return
+	}
+	if !u.subscribed(channame) {
+		s.socketSendError(u, pusherErr(http.StatusForbidden, pusherCodeGeneric,
+			"Client event sent to a channel not subscribed: "+channame))
+		return
+	}
+	if !u.takeClientEventToken(ca) {
+		rateLimited.WithLabelValues(u.App.Name, "client-event").Inc()
+		s.socketSendError(u, pusherErr(http.StatusTooManyRequests, pusherCodeRateLimit,
+			"Client event rejected due to rate limit"))
+		return
+	}
+
+	// Data is usually an object; relay it as the encoded string.
+	payload, ok := data.(string)
+	if !ok {
+		b, err := json.Marshal(data)
+		if err != nil {
+			s.socketSendInvalid(u, name)
+			return
+		}
+		payload = string(b)
+	}
+	if apperr := validatePayload(ca, payload); apperr != nil {
+		s.socketSendError(u, apperr)
+		return
+	}
+
+	eventsTriggered.WithLabelValues(u.App.Name, "client").Inc()
+	s.emitDebug(&debugEvent{Type: debugClientEvent, App: u.App.Name,
+		SocketID: u.SocketID, Channel: channame, Event: name, Data: payload})
+	apperr := s.Broadcast(context.Background(), u.App,
+		&Event{Name: name, Data: payload, Exclude: u.SocketID}, channame)
+	if apperr != nil {
+		s.logger.Errorw("client event broadcast error",
+			"app", u.App.Name,
+			"channel", channame,
+			"error", apperr)
+	}
+}
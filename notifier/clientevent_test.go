@@ -0,0 +1,82 @@
+package notifier
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/require"
+)
+
+func subscribePrivateTestSocket(t *testing.T, conn *websocket.Conn,
+	secret string, socketID string, channel string) {
+	auth := "1234567890:" + webhookSignature(secret, []byte(socketID+":"+channel))
+	err := conn.WriteJSON(map[string]any{
+		"event": "pusher:subscribe",
+		"data":  map[string]any{"channel": channel, "auth": auth},
+	})
+	require.Nil(t, err)
+	ev := readTestEvent(t, conn)
+	require.Equal(t, "pusher_internal:subscription_succeeded", ev.Event)
+}
+
+func sendTestClientEvent(t *testing.T, conn *websocket.Conn, channel string) {
+	require.Nil(t, conn.WriteJSON(map[string]any{
+		"event":   "client-typing",
+		"channel": channel,
+		"data":    map[string]any{"user": "alice"},
+	}))
+}
+
+func TestClientEvents(t *testing.T) {
+	s := initTest(t, DefaultConfig)
+	defer s.Finish()
+	ca := &s.Config.Applications[0]
+	ca.ClientEvents = true
+	ca.ClientEventRateLimit = ConfigRateLimit{Rate: 0.1, Burst: 1}
+	server := httptest.NewServer(newRouter(s))
+	defer server.Close()
+
+	conn1, id1 := dialTestSocketID(t, server, "1234567890", testClientQuery)
+	defer conn1.Close()
+	conn2, id2 := dialTestSocketID(t, server, "1234567890", testClientQuery)
+	defer conn2.Close()
+	subscribePrivateTestSocket(t, conn1, ca.Secret, id1, "private-chat")
+	subscribePrivateTestSocket(t, conn2, ca.Secret, id2, "private-chat")
+	subscribeTestSocket(t, conn1, "public-chat")
+
+	// Relayed to the others, but not to the sender
+	sendTestClientEvent(t, conn1, "private-chat")
+	ev := readTestEvent(t, conn2)
+	require.Equal(t, "client-typing", ev.Event)
+	require.Equal(t, "private-chat", ev.Channel)
+	require.Equal(t, J(`{"user":"alice"}`), J(ev.Data))
+
+	// Public channels are rejected
+	sendTestClientEvent(t, conn1, "public-chat")
+	data := readTestError(t, conn1)
+	require.Equal(t, pusherCodeGeneric, data.Code)
+
+	// Burst of 1 is exhausted by now
+	sendTestClientEvent(t, conn1, "private-chat")
+	data = readTestError(t, conn1)
+	require.Equal(t, pusherCodeRateLimit, data.Code)
+
+	// The connection remains
+	subscribeTestSocket(t, conn1, "another-chat")
+}
+
+func TestClientEventsDisabled(t *testing.T) {
+	s := initTest(t, DefaultConfig)
+	defer s.Finish()
+	server := httptest.NewServer(newRouter(s))
+	defer server.Close()
+
+	conn, id := dialTestSocketID(t, server, "1234567890", testClientQuery)
+	defer conn.Close()
+	subscribePrivateTestSocket(t, conn, "abcdefghij", id, "private-chat")
+
+	sendTestClientEvent(t, conn, "private-chat")
+	data := readTestError(t, conn)
+	require.Equal(t, pusherCodeGeneric, data.Code)
+}
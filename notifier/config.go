@@ -1,49 +1,171 @@
 package notifier
 
 import (
+	"bytes"
 	"encoding/json"
 	"io"
+	"net"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
 )
 
 // ConfigApplication is the configuration of individual applications.
 type ConfigApplication struct {
-	Name   string `json:"name"`
-	Key    string `json:"key"`
-	Secret string `json:"secret"`
+	Name                  string                  `json:"name"`
+	Key                   string                  `json:"key"`
+	Secret                string                  `json:"secret"`
+	SecretFile            string                  `json:"secret-file"`              // read Secret from the file
+	MaxPayloadSize        int                     `json:"max-payload-size"`         // bytes; 0 for default
+	MaxChannelsPerTrigger int                     `json:"max-channels-per-trigger"` // 0 for default
+	AllowedOrigins        []string                `json:"allowed-origins"`
+	SubscriptionCount     ConfigSubscriptionCount `json:"subscription-count"`
+
+	// Quotas; 0 for unlimited
+	MaxConnections                int `json:"max-connections"`
+	MaxConnectionsPerIP           int `json:"max-connections-per-ip"`
+	MaxSubscriptionsPerConnection int `json:"max-subscriptions-per-connection"`
+	MaxChannels                   int `json:"max-channels"`
+
+	// Client events and rate limits
+	ClientEvents         bool            `json:"client-events"`
+	APIRateLimit         ConfigRateLimit `json:"api-rate-limit"`
+	ClientEventRateLimit ConfigRateLimit `json:"client-event-rate-limit"`
+
+	// Additional key/secret pairs, e.g. the old ones being rotated
+	Keys []ConfigKey `json:"keys"`
+	// Reject REST API requests without signature
+	RequireSignature bool `json:"require-signature"`
+}
+
+// ConfigKey is a key/secret pair of an application, which is valid
+// until Expires if it's given.
+type ConfigKey struct {
+	Key     string     `json:"key"`
+	Secret  string     `json:"secret"`
+	Expires *time.Time `json:"expires,omitempty"`
+}
+
+// ConfigAdmin is an optional setting of the admin API.
+type ConfigAdmin struct {
+	Token     string `json:"token"`      // bearer token; admin API is disabled if empty
+	TokenFile string `json:"token-file"` // read Token from the file
+	AppsFile  string `json:"apps-file"`  // where to keep applications in standalone mode
+}
+
+// ConfigLogging is an optional setting of the logs.
+type ConfigLogging struct {
+	Level     string             `json:"level"`      // debug, info, warn or error; "info" if empty
+	Format    string             `json:"format"`     // "json" or "console"; "json" if empty
+	Output    string             `json:"output"`     // "stdout", "stderr" or a file; "stderr" if empty
+	Sampling  *ConfigLogSampling `json:"sampling"`   // 100 and 100 if omitted
+	AccessLog string             `json:"access-log"` // "stdout", "stderr", a file or "off"; "stdout" if empty
+}
+
+// ConfigLogSampling limits the logs of the same message per second.
+// The first Initial ones are logged, and every Thereafter-th after
+// that.  Sampling is disabled if Initial is 0.
+type ConfigLogSampling struct {
+	Initial    int `json:"initial"`
+	Thereafter int `json:"thereafter"`
+}
+
+// ConfigTracing is an optional setting of OpenTelemetry tracing.
+type ConfigTracing struct {
+	Exporter    string  `json:"exporter"`     // "otlp" or "stdout"; tracing is disabled if empty
+	Endpoint    string  `json:"endpoint"`     // host:port of OTLP/HTTP collector
+	Insecure    bool    `json:"insecure"`     // use plain HTTP to the collector
+	ServiceName string  `json:"service-name"` // "micro-notifier" if empty
+	SampleRatio float64 `json:"sample-ratio"` // ratio of traces sampled; 1 if 0
+}
+
+// ConfigSubscriptionCount is an optional per-application setting to
+// notify subscribers of the number of subscriptions of a channel.
+type ConfigSubscriptionCount struct {
+	Enabled    bool   `json:"enabled"`
+	Interval   int    `json:"interval"`    // milliseconds; 0 for default
+	WebhookURL string `json:"webhook-url"` // optional
+}
+
+// ConfigRateLimit is a token bucket rate limit.  Rate tokens are added
+// per second up to Burst, and each request takes one.
+type ConfigRateLimit struct {
+	Rate  float64 `json:"rate"`  // per second; 0 for default, negative for unlimited
+	Burst int     `json:"burst"` // 0 for the ceiling of Rate
 }
 
 // ConfigRedis is an optional Redis configuration parameters.
 type ConfigRedis struct {
-	Address  string `json:"address"`
-	Database int    `json:"database"`
-	Password string `json:"password"`
-	Sentinel bool   `json:"sentinel"`
-	Secure   bool   `json:"secure"`
+	Address      string `json:"address"`
+	Database     int    `json:"database"`
+	Password     string `json:"password"`
+	PasswordFile string `json:"password-file"` // read Password from the file
+	Sentinel     bool   `json:"sentinel"`
+	Secure       bool   `json:"secure"`
+}
+
+// ConfigListener is the address and TLS settings of a listener.
+type ConfigListener struct {
+	Host        string `json:"host"`
+	Port        int    `json:"port"`
+	Socket      string `json:"socket"` // Unix domain socket path instead of host and port
+	Certificate string `json:"certificate"`
+	PrivateKey  string `json:"private-key"`
+	ClientCA    string `json:"client-ca"` // CA bundle to verify client certificates
+}
+
+// ConfigListeners is optional listeners separated from the main one,
+// which serves WebSocket connections.
+type ConfigListeners struct {
+	API ConfigListener `json:"api"` // REST and admin API
+	Ops ConfigListener `json:"ops"` // metrics and profiling
 }
 
 // Config holds the enture configuration parameters.
 type Config struct {
 	Host         string              `json:"host"`
 	Port         int                 `json:"port"`
+	Socket       string              `json:"socket"` // Unix domain socket path instead of host and port
 	Certificate  string              `json:"certificate"`
 	PrivateKey   string              `json:"private-key"`
+	ClientCA     string              `json:"client-ca"` // CA bundle to verify client certificates of REST API
+	Listeners    ConfigListeners     `json:"listeners"`
 	Redis        ConfigRedis         `json:"redis"`
+	Admin        ConfigAdmin         `json:"admin"`
+	Logging      ConfigLogging       `json:"logging"`
+	Tracing      ConfigTracing       `json:"tracing"`
 	Applications []ConfigApplication `json:"applications"`
 }
 
 // ConfigError will be returned when something bad occur during reading
-// config file.
+// config file.  Path is the field in question, e.g.
+// "applications[0].secret", if any.
 type ConfigError struct {
+	path  string
 	msg   string
 	inner error
 }
 
 func (e *ConfigError) Error() string {
+	msg := e.msg
+	if e.path != "" {
+		msg = e.path + ": " + msg
+	}
 	if e.inner != nil {
-		return e.msg + ":" + e.inner.Error()
+		return msg + ":" + e.inner.Error()
 	}
-	return e.msg
+	return msg
+}
+
+// Path returns the path to the field in question, or "" if the error
+// isn't about a specific field.
+func (e *ConfigError) Path() string {
+	return e.path
 }
 
 // Unwrap returns inner error
@@ -51,11 +173,22 @@ func (e *ConfigError) Unwrap() error {
 	return e.inner
 }
 
+const maskedSecret = "********"
+
+// Config file formats
+const (
+	formatJSON = "json"
+	formatYAML = "yaml"
+	formatTOML = "toml"
+)
+
 // ReadConfigFile reads a config file and returns Config struct
-// or an error.
+// or an error.  The format is detected by the extension; .yaml and .yml
+// for YAML, .toml for TOML, and JSON otherwise.
 func ReadConfigFile(file string) (*Config, error) {
 	if file == "" {
-		return &Config{}, nil // default case
+		// default case; still can be configured by environment variables
+		return readConfig(strings.NewReader("{}"), formatJSON)
 	}
 
 	f, err := os.Open(file)
@@ -67,51 +200,185 @@ func ReadConfigFile(file string) (*Config, error) {
 	}
 	defer f.Close()
 
-	return ReadConfig(f)
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".yaml", ".yml":
+		return readConfig(f, formatYAML)
+	case ".toml":
+		return readConfig(f, formatTOML)
+	}
+	return readConfig(f, formatJSON)
 }
 
-// ReadConfig reads configuration data and returns Config struct
+// ReadConfig reads configuration data in JSON and returns Config struct
 // or an error.
 func ReadConfig(r io.Reader) (*Config, error) {
-	decoder := json.NewDecoder(r)
+	return readConfig(r, formatJSON)
+}
+
+// decodeConfig decodes configuration data in the format.  YAML and TOML
+// are converted to JSON, so that the field names are the same as JSON.
+// Unknown fields are returned separately.
+func decodeConfig(r io.Reader, format string) (*Config, ConfigErrors, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	var tree map[string]any
+	switch format {
+	case formatYAML:
+		err = yaml.Unmarshal(data, &tree)
+	case formatTOML:
+		err = toml.Unmarshal(data, &tree)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if tree != nil {
+		data, err = json.Marshal(tree)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
 	config := Config{}
-	err := decoder.Decode(&config)
+	err = json.NewDecoder(bytes.NewReader(data)).Decode(&config)
 	if err != nil {
-		return nil, &ConfigError{
-			msg:   "Invalid config file format",
+		return nil, nil, err
+	}
+	unknown, err := checkUnknownFields(data)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &config, unknown, nil
+}
+
+// readSecretFile reads a secret from the file, without trailing newline.
+func readSecretFile(file string) (string, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return "", &ConfigError{
+			msg:   "Cannot read secret file `" + file + "' ",
 			inner: err,
 		}
 	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
 
-	if config.Certificate != "" {
-		_, err = os.Stat(config.Certificate)
+// readSecretFiles fills the secrets given by files.
+func (c *Config) readSecretFiles() error {
+	var err error
+	if c.Redis.PasswordFile != "" {
+		c.Redis.Password, err = readSecretFile(c.Redis.PasswordFile)
 		if err != nil {
-			return nil, &ConfigError{
-				msg: "Cannot access certificate file `" +
-					config.Certificate + "' ",
-				inner: err,
-			}
+			return err
 		}
 	}
-	if config.PrivateKey != "" {
-		_, err = os.Stat(config.PrivateKey)
+	if c.Admin.TokenFile != "" {
+		c.Admin.Token, err = readSecretFile(c.Admin.TokenFile)
 		if err != nil {
-			return nil, &ConfigError{
-				msg: "Cannot access private key file `" +
-					config.PrivateKey + "' ",
-				inner: err,
+			return err
+		}
+	}
+	for i := range c.Applications {
+		ca := &c.Applications[i]
+		if ca.SecretFile != "" {
+			ca.Secret, err = readSecretFile(ca.SecretFile)
+			if err != nil {
+				return err
 			}
 		}
 	}
-	if (config.Certificate != "" && config.PrivateKey == "") ||
-		(config.Certificate == "" && config.PrivateKey != "") {
+	return nil
+}
+
+// readConfig reads configuration data in the format, applies the
+// environment variables and the secret files, and validates the result.
+// All the problems found by the validation are returned at once as
+// ConfigErrors.
+func readConfig(r io.Reader, format string) (*Config, error) {
+	config, unknown, err := decodeConfig(r, format)
+	if err != nil {
 		return nil, &ConfigError{
-			msg:   "To use https, both certificate and private-key must be specified",
-			inner: nil,
+			msg:   "Invalid config file format",
+			inner: err,
 		}
 	}
+	config, err = config.applyEnv(os.Environ())
+	if err != nil {
+		return nil, err
+	}
+	err = config.readSecretFiles()
+	if err != nil {
+		return nil, err
+	}
 
-	return &config, nil
+	errs := append(unknown, config.validate()...)
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	return config, nil
+}
+
+// Masked returns a copy of the configuration whose secrets are masked.
+func (c *Config) Masked() *Config {
+	masked := *c
+	if masked.Redis.Password != "" {
+		masked.Redis.Password = maskedSecret
+	}
+	if masked.Admin.Token != "" {
+		masked.Admin.Token = maskedSecret
+	}
+	masked.Applications = make([]ConfigApplication, 0, len(c.Applications))
+	for _, ca := range c.Applications {
+		masked.Applications = append(masked.Applications, ca.masked())
+	}
+	return &masked
+}
+
+// masked returns a copy of the application whose secrets are masked.
+func (ca ConfigApplication) masked() ConfigApplication {
+	if ca.Secret != "" {
+		ca.Secret = maskedSecret
+	}
+	keys := make([]ConfigKey, 0, len(ca.Keys))
+	for _, k := range ca.Keys {
+		if k.Secret != "" {
+			k.Secret = maskedSecret
+		}
+		keys = append(keys, k)
+	}
+	if len(keys) > 0 {
+		ca.Keys = keys
+	}
+	return ca
+}
+
+// mainListener returns the main listener given at the top level.
+func (c *Config) mainListener() ConfigListener {
+	return ConfigListener{
+		Host:        c.Host,
+		Port:        c.Port,
+		Socket:      c.Socket,
+		Certificate: c.Certificate,
+		PrivateKey:  c.PrivateKey,
+		ClientCA:    c.ClientCA,
+	}
+}
+
+// configured returns true if the listener is configured.
+func (l *ConfigListener) configured() bool {
+	return l.Port != 0 || l.Socket != ""
+}
+
+// address returns the network and the address to listen on.
+func (l *ConfigListener) address() (string, string) {
+	if l.Socket != "" {
+		return "unix", l.Socket
+	}
+	port := l.Port
+	if port == 0 {
+		port = DefaultPort
+	}
+	return "tcp", net.JoinHostPort(l.Host, strconv.Itoa(port))
 }
 
 // GetApp extracts ConfigApplication of the named application, or nil
@@ -127,11 +394,32 @@ func (c *Config) GetApp(name string) *ConfigApplication {
 
 // GetAppFromKey extracts ConfigApplication of the application with
 // given key, or nil if no such application is defined in the config.
+// Any active key of the application matches.
 func (c *Config) GetAppFromKey(key string) *ConfigApplication {
+	now := time.Now()
 	for _, ca := range c.Applications {
-		if ca.Key == key {
+		if len(ca.secretsFor(key, now)) > 0 {
 			return &ca
 		}
 	}
 	return nil
 }
+
+// secretsFor returns the secrets paired with the key that are active at
+// now, the current one first.  A key may have several secrets while
+// only the secret is rotated.
+func (ca *ConfigApplication) secretsFor(key string, now time.Time) []string {
+	if key == "" {
+		return nil
+	}
+	var secrets []string
+	if ca.Key == key {
+		secrets = append(secrets, ca.Secret)
+	}
+	for _, k := range ca.Keys {
+		if k.Key == key && (k.Expires == nil || now.Before(*k.Expires)) {
+			secrets = append(secrets, k.Secret)
+		}
+	}
+	return secrets
+}
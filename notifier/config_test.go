@@ -1,6 +1,10 @@
 package notifier
 
 import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/require"
@@ -44,3 +48,252 @@ func TestConfigReadFile(t *testing.T) {
 	require.Equal(t, config.Host, "localhost")
 	require.Equal(t, config.Port, 8150)
 }
+
+func TestConfigFormats(t *testing.T) {
+	expected, err := ReadConfigFile("../config/sample.json")
+	require.Nil(t, err)
+	for _, file := range []string{"../config/sample.yaml", "../config/sample.toml"} {
+		config, err := ReadConfigFile(file)
+		require.Nil(t, err, file)
+		require.Equal(t, expected, config, file)
+	}
+
+	dir := t.TempDir()
+	file := filepath.Join(dir, "bad.yaml")
+	require.Nil(t, os.WriteFile(file, []byte("port: [\n"), 0o600))
+	_, err = ReadConfigFile(file)
+	require.NotNil(t, err)
+}
+
+func TestConfigEnv(t *testing.T) {
+	config, err := ReadConfigFile("../config/sample.json")
+	require.Nil(t, err)
+
+	config, err = config.applyEnv([]string{
+		"HOME=/root",
+		"MICRO_NOTIFIER_PORT=9000",
+		"MICRO_NOTIFIER_REDIS_PASSWORD=redispass",
+		"MICRO_NOTIFIER_APPLICATIONS_TESTAPP_SECRET=newsecret",
+		"MICRO_NOTIFIER_APPLICATIONS_1_MAX_CONNECTIONS=10",
+		"MICRO_NOTIFIER_APPLICATIONS_TESTAPP2_SUBSCRIPTION_COUNT_ENABLED=true",
+		`MICRO_NOTIFIER_APPLICATIONS_TESTAPP_ALLOWED_ORIGINS=["https://example.com"]`,
+	})
+	require.Nil(t, err)
+	require.Equal(t, 9000, config.Port)
+	require.Equal(t, "redispass", config.Redis.Password)
+	require.Equal(t, "newsecret", config.GetApp("testapp").Secret)
+	require.Equal(t, []string{"https://example.com"}, config.GetApp("testapp").AllowedOrigins)
+	require.Equal(t, 10, config.GetApp("testapp2").MaxConnections)
+	require.True(t, config.GetApp("testapp2").SubscriptionCount.Enabled)
+
+	for _, env := range []string{
+		"MICRO_NOTIFIER_NO_SUCH_FIELD=1",
+		"MICRO_NOTIFIER_APPLICATIONS_NOSUCHAPP_SECRET=x",
+		"MICRO_NOTIFIER_PORT=abc",
+	} {
+		_, err = config.applyEnv([]string{env})
+		require.NotNil(t, err, env)
+	}
+
+	// Applications can be given as a whole
+	config, err = (&Config{}).applyEnv([]string{
+		`MICRO_NOTIFIER_APPLICATIONS=[{"name":"envapp","key":"k","secret":"s"}]`,
+	})
+	require.Nil(t, err)
+	require.Equal(t, "s", config.GetApp("envapp").Secret)
+}
+
+func TestConfigSecretFiles(t *testing.T) {
+	dir := t.TempDir()
+	secretFile := filepath.Join(dir, "secret")
+	require.Nil(t, os.WriteFile(secretFile, []byte("filesecret\n"), 0o600))
+	file := filepath.Join(dir, "config.yaml")
+	require.Nil(t, os.WriteFile(file, []byte(`
+redis:
+  address: localhost:6379
+  password-file: `+secretFile+`
+applications:
+  - name: testapp
+    key: "1234567890"
+    secret-file: `+secretFile+`
+`), 0o600))
+
+	config, err := ReadConfigFile(file)
+	require.Nil(t, err)
+	require.Equal(t, "filesecret", config.Redis.Password)
+	require.Equal(t, "filesecret", config.GetApp("testapp").Secret)
+
+	masked := config.Masked()
+	require.Equal(t, maskedSecret, masked.Redis.Password)
+	require.Equal(t, maskedSecret, masked.GetApp("testapp").Secret)
+	require.Equal(t, "filesecret", config.GetApp("testapp").Secret)
+
+	require.Nil(t, os.Remove(secretFile))
+	_, err = ReadConfigFile(file)
+	require.NotNil(t, err)
+}
+
+func TestConfigValidate(t *testing.T) {
+	cert := "../config/sample-self-signed-cert.pem"
+	key := "../config/sample-self-signed-key.pem"
+	tests := []struct {
+		name   string
+		config string
+		paths  []string // paths of the expected errors
+	}{
+		{
+			name:   "valid",
+			config: `{"port": 8150, "applications": [{"name": "a", "key": "k", "secret": "s"}]}`,
+		},
+		{
+			name:   "empty",
+			config: `{}`,
+		},
+		{
+			name:   "invalid port",
+			config: `{"port": 70000}`,
+			paths:  []string{"port"},
+		},
+		{
+			name:   "negative port",
+			config: `{"port": -1}`,
+			paths:  []string{"port"},
+		},
+		{
+			name:   "logging",
+			config: `{"logging": {"level": "verbose", "format": "text", "sampling": {"initial": -1}}}`,
+			paths:  []string{"logging.format", "logging.level", "logging.sampling.initial"},
+		},
+		{
+			name:   "tracing",
+			config: `{"tracing": {"exporter": "jaeger", "sample-ratio": 1.5}}`,
+			paths:  []string{"tracing.exporter", "tracing.sample-ratio"},
+		},
+		{
+			name:   "unknown fields",
+			config: `{"prot": 8150, "redis": {"adress": "x"}, "applications": [{"name": "a", "key": "k", "secret": "s", "max-conections": 1}]}`,
+			paths:  []string{"applications[0].max-conections", "prot", "redis.adress"},
+		},
+		{
+			name:   "missing fields",
+			config: `{"applications": [{"name": "a"}, {"key": "k", "secret": "s", "keys": [{"key": "k2"}]}]}`,
+			paths: []string{
+				"applications[0].key", "applications[0].secret",
+				"applications[1].name", "applications[1].keys[0].secret",
+			},
+		},
+		{
+			name: "duplicates",
+			config: `{"applications": [
+				{"name": "a", "key": "k1", "secret": "s"},
+				{"name": "a", "key": "k2", "secret": "s"},
+				{"name": "b", "key": "k1", "secret": "s"},
+				{"name": "c", "key": "k3", "secret": "s", "keys": [{"key": "k2", "secret": "s"}]},
+				{"name": "d", "key": "k4", "secret": "s", "keys": [{"key": "k3", "secret": "s"}]}]}`,
+			paths: []string{
+				"applications[1].name", "applications[2].key", "applications[3].keys[0].key",
+				"applications[4].keys[0].key",
+			},
+		},
+		{
+			name: "secret rotation",
+			config: `{"applications": [
+				{"name": "a", "key": "k1", "secret": "new", "keys": [{"key": "k1", "secret": "old"}, {"key": "k1", "secret": "older"}]}]}`,
+		},
+		{
+			name:   "invalid application settings",
+			config: `{"applications": [{"name": "a/b", "key": "k", "secret": "s", "max-connections": -1, "api-rate-limit": {"rate": -1, "burst": -1}, "allowed-origins": [""], "subscription-count": {"webhook-url": "example.com/hook"}}]}`,
+			paths: []string{
+				"applications[0].name", "applications[0].max-connections",
+				"applications[0].api-rate-limit.burst", "applications[0].allowed-origins[0]",
+				"applications[0].subscription-count.webhook-url",
+			},
+		},
+		{
+			name:   "redis",
+			config: `{"redis": {"address": "localhost:6379", "database": 1}}`,
+		},
+		{
+			name:   "redis without port",
+			config: `{"redis": {"address": "localhost"}}`,
+			paths:  []string{"redis.address"},
+		},
+		{
+			name:   "redis with invalid port",
+			config: `{"redis": {"address": "localhost:99999", "database": -1}}`,
+			paths:  []string{"redis.address", "redis.database"},
+		},
+		{
+			name:   "redis without address",
+			config: `{"redis": {"sentinel": true}}`,
+			paths:  []string{"redis.address"},
+		},
+		{
+			name:   "tls",
+			config: `{"certificate": "` + cert + `", "private-key": "` + key + `"}`,
+		},
+		{
+			name:   "tls without private key",
+			config: `{"certificate": "` + cert + `"}`,
+			paths:  []string{"private-key"},
+		},
+		{
+			name:   "tls without certificate",
+			config: `{"private-key": "` + key + `"}`,
+			paths:  []string{"certificate"},
+		},
+		{
+			name:   "listeners",
+			config: `{"port": 8150, "listeners": {"api": {"host": "localhost", "port": 8151}, "ops": {"socket": "/tmp/ops.sock"}}}`,
+		},
+		{
+			name:   "listeners with the same address",
+			config: `{"listeners": {"api": {"port": 8111}, "ops": {"port": 8111}}}`,
+			paths:  []string{"listeners.api.port", "listeners.ops.port"},
+		},
+		{
+			name:   "listener without port",
+			config: `{"listeners": {"api": {"host": "localhost"}}}`,
+			paths:  []string{"listeners.api.port"},
+		},
+		{
+			name:   "listener with socket and port",
+			config: `{"socket": "/tmp/main.sock", "port": 8150, "listeners": {"ops": {"port": 70000, "client-ca": "` + cert + `"}}}`,
+			paths:  []string{"socket", "listeners.ops.port", "listeners.ops.client-ca"},
+		},
+		{
+			name:   "client ca without tls",
+			config: `{"client-ca": "` + cert + `"}`,
+			paths:  []string{"client-ca"},
+		},
+		{
+			name:   "client ca without certificates",
+			config: `{"certificate": "` + cert + `", "private-key": "` + key + `", "client-ca": "` + key + `"}`,
+			paths:  []string{"client-ca"},
+		},
+		{
+			name:   "tls with missing files",
+			config: `{"certificate": "nosuchfile", "private-key": "nosuchfile"}`,
+			paths:  []string{"certificate", "private-key"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config, err := ReadConfig(strings.NewReader(tt.config))
+			if len(tt.paths) == 0 {
+				require.Nil(t, err)
+				require.NotNil(t, config)
+				return
+			}
+			require.Nil(t, config)
+			var errs ConfigErrors
+			require.True(t, errors.As(err, &errs), err)
+			paths := make([]string, 0, len(errs))
+			for _, e := range errs {
+				paths = append(paths, e.Path())
+			}
+			require.ElementsMatch(t, tt.paths, paths, err.Error())
+		})
+	}
+}
@@ -0,0 +1,367 @@
+package notifier
+
+import (
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ConfigErrors is the list of problems found in the configuration.
+type ConfigErrors []*ConfigError
+
+func (es ConfigErrors) Error() string {
+	msgs := make([]string, 0, len(es))
+	for _, e := range es {
+		msgs = append(msgs, e.Error())
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// configErr makes a ConfigError of the field at path.
+func configErr(path, format string, args ...any) *ConfigError {
+	return &ConfigError{path: path, msg: fmt.Sprintf(format, args...)}
+}
+
+func fieldPath(parent, name string) string {
+	if parent == "" {
+		return name
+	}
+	return parent + "." + name
+}
+
+func indexPath(parent string, i int) string {
+	return parent + "[" + strconv.Itoa(i) + "]"
+}
+
+var textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+
+// unknownFields returns errors for the fields in the decoded tree that
+// don't exist in the type, which are typos in most cases.
+func unknownFields(node any, t reflect.Type, path string) ConfigErrors {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if reflect.PtrTo(t).Implements(textUnmarshalerType) {
+		return nil
+	}
+	var errs ConfigErrors
+	switch n := node.(type) {
+	case map[string]any:
+		if t.Kind() != reflect.Struct {
+			return nil
+		}
+		fields := make(map[string]reflect.Type)
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+			if name != "" && name != "-" {
+				fields[name] = f.Type
+			}
+		}
+		keys := make([]string, 0, len(n))
+		for k := range n {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			ft, ok := fields[k]
+			if !ok {
+				errs = append(errs, configErr(fieldPath(path, k), "Unknown field"))
+				continue
+			}
+			errs = append(errs, unknownFields(n[k], ft, fieldPath(path, k))...)
+		}
+	case []any:
+		if t.Kind() != reflect.Slice {
+			return nil
+		}
+		for i, v := range n {
+			errs = append(errs, unknownFields(v, t.Elem(), indexPath(path, i))...)
+		}
+	}
+	return errs
+}
+
+// checkUnknownFields decodes data in JSON into a tree and returns
+// errors for the unknown fields in it.
+func checkUnknownFields(data []byte) (ConfigErrors, error) {
+	var tree any
+	err := json.Unmarshal(data, &tree)
+	if err != nil {
+		return nil, err
+	}
+	return unknownFields(tree, reflect.TypeOf(Config{}), ""), nil
+}
+
+// validatePort checks the port number.  0 is allowed if optional.
+func validatePort(path string, port int, optional bool) *ConfigError {
+	if (port == 0 && optional) || (port > 0 && port <= 65535) {
+		return nil
+	}
+	return configErr(path, "Invalid port number %d", port)
+}
+
+// validate checks the consistency of the configuration, and returns all
+// the problems found, or nil.
+func (c *Config) validate() ConfigErrors {
+	var errs ConfigErrors
+	add := func(e *ConfigError) {
+		if e != nil {
+			errs = append(errs, e)
+		}
+	}
+
+	listeners := []struct {
+		path string
+		l    ConfigListener
+	}{
+		{"", c.mainListener()},
+		{"listeners.api", c.Listeners.API},
+		{"listeners.ops", c.Listeners.Ops},
+	}
+	addrs := make(map[string]string)
+	for _, x := range listeners {
+		main := x.path == ""
+		errs = append(errs, x.l.validate(x.path, main)...)
+		if !main && !x.l.configured() {
+			continue
+		}
+		name := x.path
+		if main {
+			name = "the main listener"
+		}
+		_, addr := x.l.address()
+		if other, ok := addrs[addr]; ok {
+			add(configErr(fieldPath(x.path, "port"), "Same address %s as %s", addr, other))
+		} else {
+			addrs[addr] = name
+		}
+	}
+
+	errs = append(errs, c.Redis.validate("redis")...)
+	errs = append(errs, c.Logging.validate("logging")...)
+	errs = append(errs, c.Tracing.validate("tracing")...)
+
+	names := make(map[string]int)
+	keys := make(map[string]keyUse)
+	for i := range c.Applications {
+		ca := &c.Applications[i]
+		path := indexPath("applications", i)
+		errs = append(errs, ca.validate(path)...)
+
+		if ca.Name != "" {
+			if j, ok := names[ca.Name]; ok {
+				add(configErr(fieldPath(path, "name"),
+					"Duplicate application name %q; also used by applications[%d]", ca.Name, j))
+			} else {
+				names[ca.Name] = i
+			}
+		}
+		// The same key may appear in keys with older secrets of the
+		// application, but not in the other applications.
+		checkKey := func(p string, key string) {
+			if key == "" {
+				return
+			}
+			if other, ok := keys[key]; !ok {
+				keys[key] = keyUse{app: i, path: p}
+			} else if other.app != i {
+				add(configErr(p, "Duplicate key %q; also used by %s", key, other.path))
+			}
+		}
+		checkKey(fieldPath(path, "key"), ca.Key)
+		for j, k := range ca.Keys {
+			checkKey(fieldPath(indexPath(fieldPath(path, "keys"), j), "key"), k.Key)
+		}
+	}
+	return errs
+}
+
+// keyUse is where an application key is first used in the config.
+type keyUse struct {
+	app  int
+	path string
+}
+
+// validate checks the address and the TLS settings of the listener.
+// Unconfigured listeners are valid unless required.
+func (l *ConfigListener) validate(path string, required bool) ConfigErrors {
+	var errs ConfigErrors
+	add := func(e *ConfigError) {
+		if e != nil {
+			errs = append(errs, e)
+		}
+	}
+	if !required && *l == (ConfigListener{}) {
+		return nil
+	}
+	if !required && !l.configured() {
+		add(configErr(fieldPath(path, "port"), "Either port or socket is required"))
+	}
+	if l.Socket != "" && (l.Host != "" || l.Port != 0) {
+		add(configErr(fieldPath(path, "socket"), "Can't be specified with host and port"))
+	}
+	add(validatePort(fieldPath(path, "port"), l.Port, true))
+
+	tlsErr := "To use https, both certificate and private-key must be specified"
+	if l.Certificate != "" && l.PrivateKey == "" {
+		add(configErr(fieldPath(path, "private-key"), tlsErr))
+	}
+	if l.Certificate == "" && l.PrivateKey != "" {
+		add(configErr(fieldPath(path, "certificate"), tlsErr))
+	}
+	if l.Certificate != "" {
+		if _, err := os.Stat(l.Certificate); err != nil {
+			add(&ConfigError{path: fieldPath(path, "certificate"),
+				msg: "Cannot access certificate file", inner: err})
+		}
+	}
+	if l.PrivateKey != "" {
+		if _, err := os.Stat(l.PrivateKey); err != nil {
+			add(&ConfigError{path: fieldPath(path, "private-key"),
+				msg: "Cannot access private key file", inner: err})
+		}
+	}
+	if l.ClientCA != "" {
+		if l.Certificate == "" {
+			add(configErr(fieldPath(path, "client-ca"),
+				"Client certificates can be verified only with https"))
+		}
+		if _, err := loadCertPool(l.ClientCA); err != nil {
+			add(&ConfigError{path: fieldPath(path, "client-ca"),
+				msg: "Cannot read CA certificates", inner: err})
+		}
+	}
+	return errs
+}
+
+// validate checks the Redis settings, if Redis is configured.
+func (cr *ConfigRedis) validate(path string) ConfigErrors {
+	if *cr == (ConfigRedis{}) {
+		return nil
+	}
+	var errs ConfigErrors
+	if cr.Address == "" {
+		return append(errs, configErr(fieldPath(path, "address"),
+			"Address is required to use Redis"))
+	}
+	host, port, err := net.SplitHostPort(cr.Address)
+	if err != nil || host == "" {
+		errs = append(errs, configErr(fieldPath(path, "address"),
+			"Invalid address %q; must be host:port", cr.Address))
+	} else if p, err := strconv.Atoi(port); err != nil || validatePort("", p, false) != nil {
+		errs = append(errs, configErr(fieldPath(path, "address"),
+			"Invalid port number in address %q", cr.Address))
+	}
+	if cr.Database < 0 {
+		errs = append(errs, configErr(fieldPath(path, "database"),
+			"Must not be negative"))
+	}
+	return errs
+}
+
+func (cl *ConfigLogging) validate(path string) ConfigErrors {
+	var errs ConfigErrors
+	if _, err := parseLogLevel(cl.Level); err != nil {
+		errs = append(errs, configErr(fieldPath(path, "level"),
+			"Unknown level %q; must be debug, info, warn or error", cl.Level))
+	}
+	switch cl.Format {
+	case "", logFormatJSON, logFormatConsole:
+	default:
+		errs = append(errs, configErr(fieldPath(path, "format"),
+			"Unknown format %q; must be %q or %q", cl.Format, logFormatJSON, logFormatConsole))
+	}
+	if cl.Sampling != nil {
+		if cl.Sampling.Initial < 0 {
+			errs = append(errs, configErr(fieldPath(path, "sampling.initial"),
+				"Must not be negative"))
+		}
+		if cl.Sampling.Thereafter < 0 {
+			errs = append(errs, configErr(fieldPath(path, "sampling.thereafter"),
+				"Must not be negative"))
+		}
+	}
+	return errs
+}
+
+func (ct *ConfigTracing) validate(path string) ConfigErrors {
+	var errs ConfigErrors
+	switch ct.Exporter {
+	case "", exporterOTLP, exporterStdout:
+	default:
+		errs = append(errs, configErr(fieldPath(path, "exporter"),
+			"Unknown exporter %q; must be %q or %q", ct.Exporter, exporterOTLP, exporterStdout))
+	}
+	if ct.SampleRatio < 0 || ct.SampleRatio > 1 {
+		errs = append(errs, configErr(fieldPath(path, "sample-ratio"),
+			"Must be between 0 and 1"))
+	}
+	return errs
+}
+
+// validate checks the settings of the application.  Names and keys
+// duplicated among applications are checked by Config.validate.
+func (ca *ConfigApplication) validate(path string) ConfigErrors {
+	var errs ConfigErrors
+	required := func(field, value string) {
+		if value == "" {
+			errs = append(errs, configErr(fieldPath(path, field), "Required"))
+		}
+	}
+	nonNegative := func(field string, value int) {
+		if value < 0 {
+			errs = append(errs, configErr(fieldPath(path, field), "Must not be negative"))
+		}
+	}
+
+	required("name", ca.Name)
+	if strings.Contains(ca.Name, "/") {
+		// used as a path component of the REST API and Redis keys
+		errs = append(errs, configErr(fieldPath(path, "name"),
+			"Must not contain '/'"))
+	}
+	required("key", ca.Key)
+	required("secret", ca.Secret)
+	for i, k := range ca.Keys {
+		kpath := indexPath(fieldPath(path, "keys"), i)
+		if k.Key == "" {
+			errs = append(errs, configErr(fieldPath(kpath, "key"), "Required"))
+		}
+		if k.Secret == "" {
+			errs = append(errs, configErr(fieldPath(kpath, "secret"), "Required"))
+		}
+	}
+
+	nonNegative("max-payload-size", ca.MaxPayloadSize)
+	nonNegative("max-channels-per-trigger", ca.MaxChannelsPerTrigger)
+	nonNegative("max-connections", ca.MaxConnections)
+	nonNegative("max-connections-per-ip", ca.MaxConnectionsPerIP)
+	nonNegative("max-subscriptions-per-connection", ca.MaxSubscriptionsPerConnection)
+	nonNegative("max-channels", ca.MaxChannels)
+	nonNegative("api-rate-limit.burst", ca.APIRateLimit.Burst)
+	nonNegative("client-event-rate-limit.burst", ca.ClientEventRateLimit.Burst)
+	nonNegative("subscription-count.interval", ca.SubscriptionCount.Interval)
+
+	for i, origin := range ca.AllowedOrigins {
+		if origin == "" {
+			errs = append(errs, configErr(indexPath(fieldPath(path, "allowed-origins"), i),
+				"Must not be empty"))
+		}
+	}
+	if hook := ca.SubscriptionCount.WebhookURL; hook != "" {
+		u, err := url.Parse(hook)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs = append(errs, configErr(fieldPath(path, "subscription-count.webhook-url"),
+				"Invalid URL %q; must be an absolute http or https URL", hook))
+		}
+	}
+	return errs
+}
@@ -0,0 +1,180 @@
+package notifier
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Dashboard, a web UI for operators served at /dashboard/ when the admin
+// API is enabled.  The page is static; it asks for the admin token and
+// calls the admin API:
+//
+//   - GET  /admin/overview: nodes and applications with their usage
+//   - GET  /admin/apps/<application>/recent-events
+//   - GET  /admin/apps/<application>/channels, .../channels/<channel>,
+//     .../channels/<channel>/users, .../usage: as the REST API
+//   - POST /admin/apps/<application>/events: triggers an event
+//
+// The REST API handlers are mounted under /admin so that the dashboard
+// needn't know the secrets of the applications.
+
+//go:embed dashboard
+var dashboardFiles embed.FS
+
+const (
+	// Number of the recent events kept per application
+	recentEventsSize = 50
+
+	// Data of the recent events is truncated to this many bytes.
+	recentEventDataLimit = 1024
+)
+
+// recentEvent is an event broadcast recently.
+type recentEvent struct {
+	Time      time.Time `json:"time"`
+	Channel   string    `json:"channel"`
+	Event     string    `json:"event"`
+	Data      string    `json:"data"`
+	Truncated bool      `json:"truncated,omitempty"`
+}
+
+// recentEvents keeps the recent events of each application in a ring.
+// Every process receives all the events in distributed mode, so that
+// they are those of the cluster.
+type recentEvents struct {
+	mu     sync.Mutex
+	events map[string][]recentEvent // by application
+	next   map[string]int           // index to overwrite once full
+}
+
+func newRecentEvents() *recentEvents {
+	return &recentEvents{
+		events: make(map[string][]recentEvent),
+		next:   make(map[string]int),
+	}
+}
+
+func (re *recentEvents) add(appname string, ev recentEvent) {
+	if len(ev.Data) > recentEventDataLimit {
+		ev.Data = strings.ToValidUTF8(ev.Data[:recentEventDataLimit], "")
+		ev.Truncated = true
+	}
+	re.mu.Lock()
+	defer re.mu.Unlock()
+	events := re.events[appname]
+	if len(events) < recentEventsSize {
+		re.events[appname] = append(events, ev)
+		return
+	}
+	i := re.next[appname]
+	events[i] = ev
+	re.next[appname] = (i + 1) % recentEventsSize
+}
+
+// list returns the events of the application, newest first.
+func (re *recentEvents) list(appname string) []recentEvent {
+	re.mu.Lock()
+	defer re.mu.Unlock()
+	events := re.events[appname]
+	next := re.next[appname]
+	list := make([]recentEvent, 0, len(events))
+	for i := len(events) - 1; i >= 0; i-- {
+		list = append(list, events[(next+i)%len(events)])
+	}
+	return list
+}
+
+// recordEvent keeps the event for the dashboard.  Internal events are
+// not of interest.
+func (s *Supervisor) recordEvent(a *Application, e *Event, cn string) {
+	if strings.HasPrefix(e.Name, "pusher_internal:") {
+		return
+	}
+	s.recent.add(a.Name, recentEvent{
+		Time:    time.Now(),
+		Channel: cn,
+		Event:   e.Name,
+		Data:    e.Data,
+	})
+}
+
+type overviewResponse struct {
+	Nodes        []nodeRecord  `json:"nodes"`
+	Applications []overviewApp `json:"applications"`
+}
+
+type overviewApp struct {
+	Name        string `json:"name"`
+	Dynamic     bool   `json:"dynamic"` // registered via the admin API
+	Connections int    `json:"connections"`
+	Channels    int    `json:"channels"`
+}
+
+type recentEventsResponse struct {
+	Events []recentEvent `json:"events"`
+}
+
+// adminOverview returns the nodes and the applications with their usage.
+func (s *Supervisor) adminOverview(w http.ResponseWriter, r *http.Request) {
+	nodes, apperr := s.clusterNodes()
+	if apperr != nil {
+		returnErr(s, w, apperr)
+		return
+	}
+	s.reloadMu.Lock()
+	dynamic := make(map[string]bool, len(s.dynamicApps))
+	for _, ca := range s.dynamicApps {
+		dynamic[ca.Name] = true
+	}
+	s.reloadMu.Unlock()
+
+	resp := overviewResponse{Nodes: nodes, Applications: []overviewApp{}}
+	for _, a := range s.apps() {
+		conns, chans, apperr := s.GetUsage(a.Name)
+		if apperr != nil {
+			returnErr(s, w, apperr)
+			return
+		}
+		resp.Applications = append(resp.Applications, overviewApp{
+			Name:        a.Name,
+			Dynamic:     dynamic[a.Name],
+			Connections: conns,
+			Channels:    chans,
+		})
+	}
+	returnJSON(w, resp)
+}
+
+// adminRecentEvents returns the recent events of the application.
+func (s *Supervisor) adminRecentEvents(w http.ResponseWriter, r *http.Request) {
+	a, apperr := s.GetApp(mux.Vars(r)["app"])
+	if apperr != nil {
+		returnErr(s, w, apperr)
+		return
+	}
+	returnJSON(w, recentEventsResponse{Events: s.recent.list(a.Name)})
+}
+
+// dashboardHandler serves the static files of the dashboard, which is
+// unavailable as the admin API is when no admin token is configured.
+func (s *Supervisor) dashboardHandler() http.Handler {
+	root, err := fs.Sub(dashboardFiles, "dashboard")
+	if err != nil {
+		panic(err)
+	}
+	files := http.StripPrefix("/dashboard/", http.FileServer(http.FS(root)))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.config().Admin.Token == "" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("X-Frame-Options", "DENY")
+		files.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,216 @@
+// Dashboard of the notifier, built on the admin API.
+"use strict";
+
+const refreshInterval = 5000; // milliseconds
+const tokenKey = "notifier-admin-token";
+
+let selectedApp = null;
+let timer = null;
+
+function $(id) {
+  return document.getElementById(id);
+}
+
+class APIError extends Error {
+  constructor(status, message) {
+    super(message);
+    this.status = status;
+  }
+}
+
+async function api(method, path, body) {
+  const headers = { Authorization: "Bearer " + sessionStorage.getItem(tokenKey) };
+  if (body !== undefined) {
+    headers["Content-Type"] = "application/json";
+  }
+  const resp = await fetch(path, {
+    method: method,
+    headers: headers,
+    body: body === undefined ? undefined : JSON.stringify(body),
+  });
+  const text = await resp.text();
+  if (!resp.ok) {
+    let message = text;
+    try {
+      message = JSON.parse(text).error.message;
+    } catch (e) {
+      // not a JSON error
+    }
+    throw new APIError(resp.status, message);
+  }
+  return text ? JSON.parse(text) : {};
+}
+
+function appPath(app) {
+  return "/admin/apps/" + encodeURIComponent(app);
+}
+
+// row creates a table row of the cells, given as text or elements.
+function row(cells) {
+  const tr = document.createElement("tr");
+  for (const cell of cells) {
+    const td = document.createElement("td");
+    if (cell instanceof Node) {
+      td.appendChild(cell);
+    } else {
+      td.textContent = cell;
+    }
+    tr.appendChild(td);
+  }
+  return tr;
+}
+
+function fill(tbody, rows) {
+  tbody.replaceChildren(...rows);
+}
+
+function formatDuration(seconds) {
+  const s = Math.floor(seconds);
+  const d = Math.floor(s / 86400);
+  const h = Math.floor((s % 86400) / 3600);
+  const m = Math.floor((s % 3600) / 60);
+  return (d ? d + "d " : "") + h + "h " + m + "m";
+}
+
+function formatTime(time) {
+  return new Date(time).toLocaleTimeString();
+}
+
+function showError(err) {
+  if (err instanceof APIError && err.status === 401) {
+    sessionStorage.removeItem(tokenKey);
+    showLogin("Invalid admin token");
+    return;
+  }
+  $("error").textContent = err.message;
+  $("error").hidden = false;
+}
+
+function showLogin(message) {
+  clearTimeout(timer);
+  $("main").hidden = true;
+  $("login").hidden = false;
+  $("error").textContent = message || "";
+  $("error").hidden = !message;
+}
+
+function renderNodes(nodes) {
+  fill($("nodes"), nodes.map((n) => {
+    const ready = document.createElement("span");
+    ready.textContent = n.ready ? "yes" : "no" + (n.reason ? " (" + n.reason + ")" : "");
+    if (!n.ready) {
+      ready.className = "not-ready";
+    }
+    return row([n["node-id"], n.mode, ready, n.connections,
+      formatDuration(n.uptime), formatTime(n["updated-at"])]);
+  }));
+}
+
+function renderApps(apps) {
+  fill($("apps"), apps.map((a) => {
+    const button = document.createElement("button");
+    button.type = "button";
+    button.textContent = "Show";
+    button.addEventListener("click", () => selectApp(a.name));
+    const name = a.name + (a.dynamic ? " (dynamic)" : "");
+    const tr = row([name, a.connections, a.channels, button]);
+    if (a.name === selectedApp) {
+      tr.className = "selected";
+    }
+    return tr;
+  }));
+}
+
+async function renderChannels(app) {
+  const resp = await api("GET", appPath(app) + "/channels?info=subscription_count");
+  const names = Object.keys(resp.channels).sort();
+  const rows = await Promise.all(names.map(async (name) => {
+    let users = "";
+    if (name.startsWith("presence-")) {
+      const path = appPath(app) + "/channels/" + encodeURIComponent(name) + "/users";
+      const u = await api("GET", path);
+      users = (u.users || []).map((user) => user.id).join(", ");
+    }
+    return row([name, resp.channels[name].subscription_count, users]);
+  }));
+  fill($("channels"), rows);
+}
+
+async function renderEvents(app) {
+  const resp = await api("GET", appPath(app) + "/recent-events");
+  fill($("events"), resp.events.map((e) => {
+    const tr = row([formatTime(e.time), e.channel, e.event,
+      e.data + (e.truncated ? "…" : "")]);
+    tr.lastChild.className = "data";
+    return tr;
+  }));
+}
+
+async function refresh() {
+  clearTimeout(timer);
+  try {
+    const overview = await api("GET", "/admin/overview");
+    renderNodes(overview.nodes);
+    if (selectedApp && !overview.applications.some((a) => a.name === selectedApp)) {
+      selectedApp = null;
+    }
+    renderApps(overview.applications);
+    $("app").hidden = !selectedApp;
+    if (selectedApp) {
+      $("app-name").textContent = selectedApp;
+      await Promise.all([renderChannels(selectedApp), renderEvents(selectedApp)]);
+    }
+    $("main").hidden = false;
+    $("login").hidden = true;
+    $("error").hidden = true;
+    $("updated").textContent = "Updated " + new Date().toLocaleTimeString();
+  } catch (err) {
+    showError(err);
+    if (err instanceof APIError && err.status === 401) {
+      return;
+    }
+  }
+  timer = setTimeout(refresh, refreshInterval);
+}
+
+function selectApp(app) {
+  selectedApp = app;
+  $("trigger-result").textContent = "";
+  refresh();
+}
+
+$("login").addEventListener("submit", (ev) => {
+  ev.preventDefault();
+  sessionStorage.setItem(tokenKey, $("token").value);
+  $("token").value = "";
+  refresh();
+});
+
+$("logout").addEventListener("click", () => {
+  sessionStorage.removeItem(tokenKey);
+  showLogin();
+});
+
+$("trigger").addEventListener("submit", async (ev) => {
+  ev.preventDefault();
+  const result = $("trigger-result");
+  try {
+    await api("POST", appPath(selectedApp) + "/events", {
+      name: $("trigger-event").value,
+      channels: [$("trigger-channel").value],
+      data: $("trigger-data").value,
+    });
+    result.textContent = "Triggered";
+    result.className = "";
+    refresh();
+  } catch (err) {
+    result.textContent = err.message;
+    result.className = "error";
+  }
+});
+
+if (sessionStorage.getItem(tokenKey)) {
+  refresh();
+} else {
+  showLogin();
+}
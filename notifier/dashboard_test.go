@@ -0,0 +1,72 @@
+package notifier
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecentEvents(t *testing.T) {
+	re := newRecentEvents()
+	for i := 0; i < recentEventsSize+2; i++ {
+		re.add("app", recentEvent{Event: fmt.Sprint(i)})
+	}
+	list := re.list("app")
+	require.Len(t, list, recentEventsSize)
+	require.Equal(t, fmt.Sprint(recentEventsSize+1), list[0].Event)
+	require.Equal(t, "2", list[recentEventsSize-1].Event)
+	require.Empty(t, re.list("other"))
+
+	re.add("app", recentEvent{Data: strings.Repeat("é", recentEventDataLimit)})
+	ev := re.list("app")[0]
+	require.True(t, ev.Truncated)
+	require.Len(t, ev.Data, recentEventDataLimit)
+}
+
+func TestDashboard(t *testing.T) {
+	s := initAdminTest(t, "")
+	defer s.Finish()
+	router := newRouter(s)
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	_ = doRequest(t, router, "GET", "/admin/overview", "", http.StatusUnauthorized)
+	rr := doRequest(t, router, "GET", "/dashboard/", "", http.StatusOK)
+	require.Contains(t, rr.Body.String(), "dashboard.js")
+	_ = doRequest(t, router, "GET", "/dashboard/dashboard.js", "", http.StatusOK)
+	_ = doRequest(t, router, "GET", "/dashboard", "", http.StatusMovedPermanently)
+
+	conn, _ := dialTestSocketID(t, server, "1234567890", testClientQuery)
+	defer conn.Close()
+	subscribeTestSocket(t, conn, "chan1")
+
+	rr = doAdminRequest(t, router, "GET", "/admin/overview", "", http.StatusOK)
+	body := jsonBody(t, rr)
+	require.Equal(t, s.nodeID, body.Get("nodes").GetIndex(0).Get("node-id").MustString())
+	require.Equal(t, J(`{"name":"testapp","dynamic":false,"connections":1,"channels":1}`),
+		body.Get("applications").GetIndex(0))
+
+	// REST API handlers without the signature
+	rr = doAdminRequest(t, router, "GET", "/admin/apps/testapp/channels?info=subscription_count", "", http.StatusOK)
+	require.Equal(t, J(`{"channels":{"chan1":{"subscription_count":1}}}`), jsonBody(t, rr))
+	_ = doAdminRequest(t, router, "POST", "/admin/apps/testapp/events",
+		`{"name":"ev","channels":["chan1"],"data":"{}"}`, http.StatusOK)
+	require.Equal(t, "ev", readTestEvent(t, conn).Event)
+
+	// Internal events are not recorded
+	rr = doAdminRequest(t, router, "GET", "/admin/apps/testapp/recent-events", "", http.StatusOK)
+	events := jsonBody(t, rr).Get("events")
+	require.Len(t, events.MustArray(), 1)
+	require.Equal(t, "ev", events.GetIndex(0).Get("event").MustString())
+	require.Equal(t, "chan1", events.GetIndex(0).Get("channel").MustString())
+	_ = doAdminRequest(t, router, "GET", "/admin/apps/nosuchapp/recent-events", "", http.StatusNotFound)
+
+	// Unavailable without the admin token
+	s2 := initTest(t, DefaultConfig)
+	defer s2.Finish()
+	_ = doRequest(t, newRouter(s2), "GET", "/dashboard/", "", http.StatusNotFound)
+}
@@ -0,0 +1,305 @@
+package notifier
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+)
+
+// Debug console, like that of the Pusher dashboard.  The activity of
+// an application is streamed over WebSocket from
+//
+//	GET /apps/<application>/debug?channel-prefix=<prefix>&event=<name>
+//
+// which requires a signed request as the REST API.  In distributed
+// mode, the activity is published to the Redis pubsub channel
+// <application>/debug, to which the processes with watchers of the
+// application subscribe, so that the activity of all the processes is
+// streamed.
+
+// Types of debug events
+const (
+	debugConnection    = "connection"
+	debugDisconnection = "disconnection"
+	debugSubscribed    = "subscribed"
+	debugUnsubscribed  = "unsubscribed"
+	debugAPIMessage    = "api_message"
+	debugClientEvent   = "client_event"
+	debugError         = "error"
+)
+
+const (
+	// Events are dropped if the watcher falls behind this much.
+	debugBufferSize = 256
+
+	// Interval of WebSocket pings to keep the console connected.
+	debugPingInterval = 30 * time.Second
+
+	// If nobody watched an application on the last publish, its
+	// activity isn't published for this period in distributed mode.
+	debugIdleInterval = time.Second
+)
+
+// debugEvent is an activity shown in the debug console.
+type debugEvent struct {
+	Type     string    `json:"type"`
+	Time     time.Time `json:"time"`
+	NodeID   string    `json:"node-id"`
+	App      string    `json:"app"`
+	SocketID string    `json:"socket-id,omitempty"`
+	Channel  string    `json:"channel,omitempty"`
+	Event    string    `json:"event,omitempty"`
+	Data     string    `json:"data,omitempty"`
+	Code     int       `json:"code,omitempty"`    // Pusher error code
+	Message  string    `json:"message,omitempty"` // error message
+}
+
+func debugChannel(appname string) string {
+	return appname + "/debug"
+}
+
+// debugWatcher is a connection of the debug console.
+type debugWatcher struct {
+	app           string
+	channelPrefix string // only the events of the channels with the prefix
+	event         string // only the events of the name
+	ch            chan *debugEvent
+}
+
+func (dw *debugWatcher) matches(ev *debugEvent) bool {
+	if dw.channelPrefix != "" && !strings.HasPrefix(ev.Channel, dw.channelPrefix) {
+		return false
+	}
+	if dw.event != "" && ev.Event != dw.event {
+		return false
+	}
+	return true
+}
+
+// debugHub dispatches the debug events to the watchers.
+type debugHub struct {
+	mu       sync.Mutex
+	watchers map[string]map[*debugWatcher]bool // by application
+	psc      *redis.PubSubConn                 // nil unless subscribing Redis
+	idle     map[string]time.Time              // applications not watched, until the time
+}
+
+func newDebugHub() *debugHub {
+	return &debugHub{
+		watchers: make(map[string]map[*debugWatcher]bool),
+		idle:     make(map[string]time.Time),
+	}
+}
+
+// add registers the watcher, and subscribes the debug channel of the
+// application in distributed mode.
+func (h *debugHub) add(dw *debugWatcher) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	ws, ok := h.watchers[dw.app]
+	if !ok {
+		ws = make(map[*debugWatcher]bool)
+		h.watchers[dw.app] = ws
+		delete(h.idle, dw.app)
+		if h.psc != nil {
+			if err := h.psc.Subscribe(debugChannel(dw.app)); err != nil {
+				return err
+			}
+		}
+	}
+	ws[dw] = true
+	return nil
+}
+
+func (h *debugHub) remove(dw *debugWatcher) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	ws := h.watchers[dw.app]
+	delete(ws, dw)
+	if len(ws) == 0 {
+		delete(h.watchers, dw.app)
+		if h.psc != nil {
+			_ = h.psc.Unsubscribe(debugChannel(dw.app))
+		}
+	}
+}
+
+// setPubSub is called when the Redis subscription is (re)established
+// or lost.  The debug channels of the watched applications are
+// subscribed again.
+func (h *debugHub) setPubSub(psc *redis.PubSubConn) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.psc = psc
+	if psc == nil || len(h.watchers) == 0 {
+		return nil
+	}
+	channels := make([]any, 0, len(h.watchers))
+	for app := range h.watchers {
+		channels = append(channels, debugChannel(app))
+	}
+	return psc.Subscribe(channels...)
+}
+
+func (h *debugHub) watched(appname string) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.watchers[appname]) > 0
+}
+
+// isIdle returns true if the activity of the application needn't be
+// published, for nobody watched it recently.
+func (h *debugHub) isIdle(appname string, now time.Time) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return now.Before(h.idle[appname])
+}
+
+func (h *debugHub) setIdle(appname string, until time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.idle[appname] = until
+}
+
+// dispatch sends the event to the matching watchers of this process.
+// Slow watchers miss events rather than blocking the caller.
+func (h *debugHub) dispatch(ev *debugEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for dw := range h.watchers[ev.App] {
+		if !dw.matches(ev) {
+			continue
+		}
+		select {
+		case dw.ch <- ev:
+		default:
+		}
+	}
+}
+
+// emitDebug reports the activity to the debug console.
+func (s *Supervisor) emitDebug(ev *debugEvent) {
+	now := time.Now()
+	ev.Time, ev.NodeID = now, s.nodeID
+	if s.db == nil {
+		if s.debug.watched(ev.App) {
+			s.debug.dispatch(ev)
+		}
+		return
+	}
+	if s.debug.isIdle(ev.App, now) {
+		return
+	}
+	data, err := json.Marshal(ev)
+	if err != nil {
+		s.logger.Errorw("debug event encoding error", "error", err)
+		return
+	}
+	c, err := s.db.getPool()
+	if err != nil {
+		s.logger.Errorw("debug event publish error", "error", err)
+		return
+	}
+	defer c.Close()
+	n, err := redis.Int(c.Do("PUBLISH", debugChannel(ev.App), data))
+	if err != nil {
+		s.logger.Errorw("debug event publish error", "error", err)
+		return
+	}
+	if n == 0 {
+		s.debug.setIdle(ev.App, now.Add(debugIdleInterval))
+	}
+}
+
+// handleRedisDebugEvent dispatches the debug event published by any of
+// the processes.
+func (s *Supervisor) handleRedisDebugEvent(data []byte) {
+	var ev debugEvent
+	if err := json.Unmarshal(data, &ev); err != nil {
+		s.logger.Errorw("debug event decoding error", "error", err)
+		return
+	}
+	s.debug.dispatch(&ev)
+}
+
+// debugConsole streams the activity of the application.
+func (s *Supervisor) debugConsole(w http.ResponseWriter, r *http.Request) {
+	a, apperr := s.GetApp(mux.Vars(r)["app"])
+	if apperr != nil {
+		returnErr(s, w, apperr)
+		return
+	}
+	// Verified by authMiddleware if given
+	query := r.URL.Query()
+	if !query.Has("auth_signature") {
+		returnErr(s, w, appErr(http.StatusUnauthorized, "Signature required"))
+		return
+	}
+
+	dw := &debugWatcher{
+		app:           a.Name,
+		channelPrefix: query.Get("channel-prefix"),
+		event:         query.Get("event"),
+		ch:            make(chan *debugEvent, debugBufferSize),
+	}
+	// Registered first not to miss the activity right after connecting
+	if err := s.debug.add(dw); err != nil {
+		s.debug.remove(dw)
+		returnErr(s, w, wrapErr(http.StatusInternalServerError, err))
+		return
+	}
+	upgrader := websocket.Upgrader{
+		// Authenticated by the signature
+		CheckOrigin: func(r *http.Request) bool { return true },
+	}
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		s.debug.remove(dw)
+		s.logger.Infow("websocket upgrade failed", "error", err)
+		return
+	}
+	s.logger.Infow("debug console connected", "app", a.Name,
+		"channel-prefix", dw.channelPrefix, "event", dw.event)
+	go s.debugConsoleLoop(conn, dw)
+}
+
+func (s *Supervisor) debugConsoleLoop(conn *websocket.Conn, dw *debugWatcher) {
+	defer conn.Close()
+	defer s.debug.remove(dw)
+
+	// Messages from the console are ignored; read them to notice closing.
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := conn.NextReader(); err != nil {
+				return
+			}
+		}
+	}()
+
+	ticker := time.NewTicker(debugPingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case ev := <-dw.ch:
+			if err := conn.WriteJSON(ev); err != nil {
+				return
+			}
+		case <-ticker.C:
+			err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(10*time.Second))
+			if err != nil {
+				return
+			}
+		case <-closed:
+			s.logger.Infow("debug console disconnected", "app", dw.app)
+			return
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package notifier
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/require"
+)
+
+// dialDebugConsole connects to the debug console of testapp.
+func dialDebugConsole(t *testing.T, server *httptest.Server, params url.Values) *websocket.Conn {
+	path := signRESTPathQuery("GET", "/apps/testapp/debug", params,
+		"1234567890", "abcdefghij", time.Now(), "")
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + path
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	require.Nil(t, err)
+	return conn
+}
+
+func readDebugEvent(t *testing.T, conn *websocket.Conn) debugEvent {
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var ev debugEvent
+	require.Nil(t, conn.ReadJSON(&ev))
+	return ev
+}
+
+func TestDebugConsole(t *testing.T) {
+	s := initTest(t, DefaultConfig)
+	defer s.Finish()
+	router := newRouter(s)
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	// Signature is required even if the application doesn't require it
+	_ = doRequest(t, router, "GET", "/apps/testapp/debug", "", http.StatusUnauthorized)
+
+	all := dialDebugConsole(t, server, url.Values{})
+	defer all.Close()
+	filtered := dialDebugConsole(t, server, url.Values{
+		"channel-prefix": {"chan1"},
+		"event":          {"ev"},
+	})
+	defer filtered.Close()
+
+	conn, socketID := dialTestSocketID(t, server, "1234567890", testClientQuery)
+	ev := readDebugEvent(t, all)
+	require.Equal(t, debugConnection, ev.Type)
+	require.Equal(t, "testapp", ev.App)
+	require.Equal(t, socketID, ev.SocketID)
+	require.Equal(t, s.nodeID, ev.NodeID)
+
+	subscribeTestSocket(t, conn, "chan0")
+	ev = readDebugEvent(t, all)
+	require.Equal(t, debugSubscribed, ev.Type)
+	require.Equal(t, "chan0", ev.Channel)
+
+	_ = doRequest(t, router, "POST", "/apps/testapp/events",
+		`{"name":"other","channels":["chan1"],"data":"{}"}`, http.StatusOK)
+	_ = doRequest(t, router, "POST", "/apps/testapp/events",
+		`{"name":"ev","channels":["chan0","chan1"],"data":"{\"x\":1}"}`, http.StatusOK)
+	for _, expected := range []string{"other/chan1", "ev/chan0", "ev/chan1"} {
+		ev = readDebugEvent(t, all)
+		require.Equal(t, debugAPIMessage, ev.Type)
+		require.Equal(t, expected, ev.Event+"/"+ev.Channel)
+	}
+	require.Equal(t, `{"x":1}`, ev.Data)
+	ev = readDebugEvent(t, filtered)
+	require.Equal(t, "ev/chan1", ev.Event+"/"+ev.Channel)
+	require.Equal(t, "ev", readTestEvent(t, conn).Event)
+
+	// Errors sent to the clients
+	require.Nil(t, conn.WriteJSON(map[string]any{"event": "pusher:unknown"}))
+	_ = readTestError(t, conn)
+	ev = readDebugEvent(t, all)
+	require.Equal(t, debugError, ev.Type)
+	require.Equal(t, pusherCodeGeneric, ev.Code)
+
+	conn.Close()
+	ev = readDebugEvent(t, all)
+	require.Equal(t, debugDisconnection, ev.Type)
+	require.Equal(t, socketID, ev.SocketID)
+
+	// Watchers are removed on disconnection
+	all.Close()
+	filtered.Close()
+	require.Eventually(t, func() bool { return !s.debug.watched("testapp") },
+		5*time.Second, 10*time.Millisecond)
+}
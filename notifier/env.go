@@ -0,0 +1,141 @@
+package notifier
+
+import (
+	"encoding/json"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Any field of the configuration can be overridden by an environment
+// variable named MICRO_NOTIFIER_ followed by the path to the field,
+// in upper case with non-alphanumeric characters replaced by '_'.
+// An application in the array is specified by its name or index.
+// For example:
+//
+//	MICRO_NOTIFIER_PORT=8080
+//	MICRO_NOTIFIER_REDIS_PASSWORD=...
+//	MICRO_NOTIFIER_APPLICATIONS_TESTAPP_SECRET=...
+//	MICRO_NOTIFIER_APPLICATIONS_0_ALLOWED_ORIGINS=["https://example.com"]
+//
+// Strings are taken as they are, numbers and booleans are parsed, and
+// the other values are parsed as JSON.
+const envPrefix = "MICRO_NOTIFIER_"
+
+// envName converts a field name into the form used in environment
+// variables.
+func envName(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		}
+		return '_'
+	}, s)
+}
+
+// lookupEnvPath finds the field specified by the tokens of environment
+// variable name, and returns the object containing it and its key.
+// Since field names may contain '_', every split is tried.
+func lookupEnvPath(node any, tokens []string) (map[string]any, string) {
+	for i := 1; i <= len(tokens); i++ {
+		name := strings.Join(tokens[:i], "_")
+		rest := tokens[i:]
+		switch n := node.(type) {
+		case map[string]any:
+			for k, v := range n {
+				if envName(k) != name {
+					continue
+				}
+				if len(rest) == 0 {
+					return n, k
+				}
+				if m, key := lookupEnvPath(v, rest); m != nil {
+					return m, key
+				}
+			}
+		case []any:
+			if len(rest) == 0 {
+				continue
+			}
+			for idx, v := range n {
+				m, ok := v.(map[string]any)
+				if !ok {
+					continue
+				}
+				elemName, _ := m["name"].(string)
+				if envName(elemName) != name && strconv.Itoa(idx) != name {
+					continue
+				}
+				if m, key := lookupEnvPath(m, rest); m != nil {
+					return m, key
+				}
+			}
+		}
+	}
+	return nil, ""
+}
+
+// parseEnvValue parses the value according to the type of the current
+// value of the field.
+func parseEnvValue(current any, value string) (any, error) {
+	switch current.(type) {
+	case string:
+		return value, nil
+	case float64:
+		return strconv.ParseFloat(value, 64)
+	case bool:
+		return strconv.ParseBool(value)
+	}
+	var v any
+	err := json.Unmarshal([]byte(value), &v)
+	return v, err
+}
+
+// applyEnv returns a copy of the configuration overridden by the
+// environment variables, given in the form of os.Environ().
+func (c *Config) applyEnv(environ []string) (*Config, error) {
+	sort.Strings(environ)
+	var tree map[string]any
+	for _, kv := range environ {
+		if !strings.HasPrefix(kv, envPrefix) {
+			continue
+		}
+		name, value, _ := strings.Cut(kv, "=")
+		if tree == nil {
+			data, err := json.Marshal(c)
+			if err != nil {
+				return nil, err
+			}
+			err = json.Unmarshal(data, &tree)
+			if err != nil {
+				return nil, err
+			}
+		}
+		m, key := lookupEnvPath(tree, strings.Split(strings.TrimPrefix(name, envPrefix), "_"))
+		if m == nil {
+			return nil, &ConfigError{msg: "Unknown config field in environment variable " + name}
+		}
+		v, err := parseEnvValue(m[key], value)
+		if err != nil {
+			return nil, &ConfigError{msg: "Invalid value of environment variable " + name, inner: err}
+		}
+		m[key] = v
+	}
+	if tree == nil {
+		return c, nil
+	}
+
+	data, err := json.Marshal(tree)
+	if err != nil {
+		return nil, err
+	}
+	config := Config{}
+	err = json.Unmarshal(data, &config)
+	if err != nil {
+		return nil, &ConfigError{msg: "Invalid value of environment variable", inner: err}
+	}
+	return &config, nil
+}
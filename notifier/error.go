@@ -1,17 +1,53 @@
 package notifier
 
+import (
+	"errors"
+	"net/http"
+)
+
 // appError wraps application error with HTTP response code.
 type appError struct {
-	Code     int    // HTTP response code
-	Message  string // custom message
-	Internal error  // original error, if any
+	Code       int    // HTTP response code
+	Message    string // custom message
+	Internal   error  // original error, if any
+	PusherCode int    // Pusher error code sent over WebSocket, if any
 }
 
+// Pusher error codes sent over WebSocket.  Codes in 4000-4299 are also
+// used as close codes, which tell the client whether to reconnect.
+// See https://pusher.com/docs/channels/library_auth_reference/pusher-websockets-protocol/#error-codes
+const (
+	// 4000-4099: The client shouldn't reconnect.
+	pusherCodeAppNotFound = 4001 // application does not exist
+	pusherCodeOverQuota   = 4004 // application is over connection quota
+
+	// 4100-4199: The client should reconnect after backing off.
+	pusherCodeOverCapacity = 4100 // over capacity, or the server can't accept the connection
+
+	// 4200-4299: The client should reconnect immediately.
+	pusherCodeReconnect = 4200 // generic reconnect immediately
+
+	// 4300-4399: Other errors.  The connection remains.
+	pusherCodeGeneric   = 4300
+	pusherCodeRateLimit = 4301 // client event rejected due to rate limit
+)
+
 // appErr returns a new appError including the given HTTP response code.
 func appErr(code int, message string) error {
 	return &appError{Code: code, Message: message, Internal: nil}
 }
 
+// pusherErr returns a new appError including the given HTTP response
+// code and Pusher error code.
+func pusherErr(code int, pusherCode int, message string) error {
+	return &appError{Code: code, Message: message, PusherCode: pusherCode}
+}
+
+// overQuotaErr returns an appError for exceeding an application quota.
+func overQuotaErr(message string) error {
+	return pusherErr(429, pusherCodeOverQuota, message)
+}
+
 // wrapErr returns a new appError wrapping the given error.
 func wrapErr(code int, err error) error {
 	if err == nil {
@@ -20,6 +56,16 @@ func wrapErr(code int, err error) error {
 	return &appError{Code: code, Message: err.Error(), Internal: err}
 }
 
+// bodyErr returns the error of reading the request body; 413 if it's
+// over the limit given by http.MaxBytesReader, 400 otherwise.
+func bodyErr(err error) error {
+	var maxErr *http.MaxBytesError
+	if errors.As(err, &maxErr) {
+		return wrapErr(http.StatusRequestEntityTooLarge, err)
+	}
+	return wrapErr(http.StatusBadRequest, err)
+}
+
 func (e *appError) Error() string {
 	return e.Message
 }
@@ -0,0 +1,234 @@
+package notifier
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"sort"
+	"time"
+)
+
+// Health endpoints for load balancers and Kubernetes probes.
+//
+//   - /healthz: the process is up
+//   - /readyz:  the process can serve; Redis is reachable and the event
+//     subscription is active in distributed mode, and it isn't draining
+//   - /status:  the status document of the node
+
+const (
+	modeStandalone  = "standalone"
+	modeDistributed = "distributed"
+)
+
+const (
+	// In distributed mode, the status of each process is kept in Redis
+	// this often, and the ones not updated for nodeExpiry are regarded
+	// as gone.
+	nodeHeartbeatInterval = 10 * time.Second
+	nodeExpiry            = 3 * nodeHeartbeatInterval
+
+	// Readiness probes fail if Redis doesn't respond in this period,
+	// which should be shorter than the timeout of the probes.
+	readyTimeout = time.Second
+)
+
+type healthResponse struct {
+	Status string `json:"status"`
+	Reason string `json:"reason,omitempty"`
+}
+
+type statusResponse struct {
+	NodeID       string    `json:"node-id"`
+	Mode         string    `json:"mode"`
+	StartedAt    time.Time `json:"started-at"`
+	Uptime       float64   `json:"uptime"` // seconds
+	Ready        bool      `json:"ready"`
+	Reason       string    `json:"reason,omitempty"` // why not ready
+	Draining     bool      `json:"draining"`
+	Applications int       `json:"applications"`
+	Connections  int       `json:"connections"`
+}
+
+// nodeRecord is the status of a process kept in Redis.
+type nodeRecord struct {
+	statusResponse
+	UpdatedAt time.Time `json:"updated-at"`
+}
+
+// newNodeID returns an identifier of the process, which is unique even
+// if the processes share a host name.
+func newNodeID() string {
+	host, err := os.Hostname()
+	if err != nil || host == "" {
+		host = "node"
+	}
+	return host + "-" + randomHex(4)
+}
+
+// StartDraining makes the node unready, so that load balancers stop
+// sending new connections before it shuts down.
+func (s *Supervisor) StartDraining() {
+	s.draining.Store(true)
+	s.logger.Infow("draining", "node-id", s.nodeID)
+}
+
+// checkReady returns the reason why the node isn't ready, or "".
+func (s *Supervisor) checkReady() string {
+	if s.draining.Load() {
+		return "draining"
+	}
+	if s.db == nil {
+		return ""
+	}
+	if err := s.db.ping(readyTimeout); err != nil {
+		return "redis unreachable: " + err.Error()
+	}
+	if !s.subscribed.Load() {
+		return "redis subscription inactive"
+	}
+	return ""
+}
+
+// connectionCount returns the number of connections to this process.
+func (s *Supervisor) connectionCount() int {
+	n := 0
+	for _, a := range s.apps() {
+		n += a.Users.Cardinality()
+	}
+	return n
+}
+
+func returnStatus(w http.ResponseWriter, code int, val any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	returnJSON(w, val)
+}
+
+func (s *Supervisor) healthz(w http.ResponseWriter, r *http.Request) {
+	returnStatus(w, http.StatusOK, healthResponse{Status: "ok"})
+}
+
+func (s *Supervisor) readyz(w http.ResponseWriter, r *http.Request) {
+	if reason := s.checkReady(); reason != "" {
+		returnStatus(w, http.StatusServiceUnavailable,
+			healthResponse{Status: "unavailable", Reason: reason})
+		return
+	}
+	returnStatus(w, http.StatusOK, healthResponse{Status: "ok"})
+}
+
+// status returns the status document of this process.
+func (s *Supervisor) status() statusResponse {
+	mode := modeStandalone
+	if s.db != nil {
+		mode = modeDistributed
+	}
+	reason := s.checkReady()
+	return statusResponse{
+		NodeID:       s.nodeID,
+		Mode:         mode,
+		StartedAt:    s.startedAt,
+		Uptime:       time.Since(s.startedAt).Seconds(),
+		Ready:        reason == "",
+		Reason:       reason,
+		Draining:     s.draining.Load(),
+		Applications: len(s.apps()),
+		Connections:  s.connectionCount(),
+	}
+}
+
+func (s *Supervisor) nodeStatus(w http.ResponseWriter, r *http.Request) {
+	returnStatus(w, http.StatusOK, s.status())
+}
+
+// heartbeatLoop keeps the status of this process in Redis until the
+// Supervisor finishes.
+func (s *Supervisor) heartbeatLoop() {
+	ticker := time.NewTicker(nodeHeartbeatInterval)
+	defer ticker.Stop()
+	for {
+		apperr := s.db.PutNode(s.nodeID, nodeRecord{s.status(), time.Now()})
+		if apperr != nil {
+			s.logger.Errorw("node status update error", "error", apperr)
+		}
+		// Clean up after the dead processes
+		if _, apperr := s.clusterNodes(); apperr != nil {
+			s.logger.Errorw("node status error", "error", apperr)
+		}
+		select {
+		case <-ticker.C:
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+// clusterNodes returns the statuses of all the processes sorted by the
+// node ID, or only this one in standalone mode.  Expired ones are
+// removed.
+func (s *Supervisor) clusterNodes() ([]nodeRecord, error) {
+	now := time.Now()
+	self := nodeRecord{s.status(), now}
+	if s.db == nil {
+		return []nodeRecord{self}, nil
+	}
+	values, apperr := s.db.GetNodes()
+	if apperr != nil {
+		return nil, apperr
+	}
+	nodes := []nodeRecord{self}
+	var expired []string
+	for id, data := range values {
+		if id == s.nodeID {
+			continue
+		}
+		var n nodeRecord
+		if err := json.Unmarshal(data, &n); err != nil || now.Sub(n.UpdatedAt) > nodeExpiry {
+			expired = append(expired, id)
+			continue
+		}
+		nodes = append(nodes, n)
+	}
+	for _, id := range expired {
+		s.reapNode(id)
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].NodeID < nodes[j].NodeID })
+	return nodes, nil
+}
+
+// reapNode removes the status of the dead process and the connections
+// to it, which would otherwise count toward the quotas forever.  Only
+// the process that removes the status cleans up, when several ones
+// find it expired at once.
+func (s *Supervisor) reapNode(nodeID string) {
+	removed, apperr := s.db.DeleteNode(nodeID)
+	if apperr != nil {
+		s.logger.Errorw("node status delete error", "node-id", nodeID, "error", apperr)
+		return
+	}
+	if removed {
+		s.logger.Warnw("cleaning up after dead node", "node-id", nodeID)
+		s.releaseNodeConnections(nodeID)
+	}
+}
+
+// releaseNodeConnections unregisters the connections to the process
+// from Redis.
+func (s *Supervisor) releaseNodeConnections(nodeID string) {
+	conns, apperr := s.db.GetNodeConnections(nodeID)
+	if apperr != nil {
+		s.logger.Errorw("node connections error", "node-id", nodeID, "error", apperr)
+		return
+	}
+	for _, nc := range conns {
+		registered, apperr := s.db.removeConnection(nc.App, nodeID, nc.UID)
+		if apperr == nil && registered {
+			apperr = s.dropRedisUser(nc.App, nc.UID)
+		}
+		if apperr != nil {
+			s.logger.Errorw("node connection cleanup error",
+				"node-id", nodeID, "app", nc.App, "uid", nc.UID, "error", apperr)
+		}
+	}
+}
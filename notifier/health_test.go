@@ -0,0 +1,45 @@
+package notifier
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHealth(t *testing.T) {
+	s := initTest(t, DefaultConfig)
+	defer s.Finish()
+	server := httptest.NewServer(newRouter(s))
+	defer server.Close()
+	router := newRouter(s)
+
+	rr := doRequest(t, router, "GET", "/healthz", "", http.StatusOK)
+	require.Equal(t, J(`{"status":"ok"}`), jsonBody(t, rr))
+	rr = doRequest(t, router, "GET", "/readyz", "", http.StatusOK)
+	require.Equal(t, J(`{"status":"ok"}`), jsonBody(t, rr))
+
+	conn := dialTestSocket(t, server, "1234567890")
+	defer conn.Close()
+	rr = doRequest(t, router, "GET", "/status", "", http.StatusOK)
+	var status statusResponse
+	require.Nil(t, json.Unmarshal(rr.Body.Bytes(), &status))
+	require.Equal(t, modeStandalone, status.Mode)
+	require.Equal(t, s.nodeID, status.NodeID)
+	require.NotEqual(t, "", status.NodeID)
+	require.True(t, status.Ready)
+	require.False(t, status.Draining)
+	require.Equal(t, 2, status.Applications)
+	require.Equal(t, 1, status.Connections)
+
+	s.StartDraining()
+	_ = doRequest(t, router, "GET", "/healthz", "", http.StatusOK)
+	rr = doRequest(t, router, "GET", "/readyz", "", http.StatusServiceUnavailable)
+	require.Equal(t, J(`{"status":"unavailable","reason":"draining"}`), jsonBody(t, rr))
+	rr = doRequest(t, router, "GET", "/status", "", http.StatusOK)
+	require.Nil(t, json.Unmarshal(rr.Body.Bytes(), &status))
+	require.False(t, status.Ready)
+	require.True(t, status.Draining)
+}
@@ -0,0 +1,153 @@
+package notifier
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/gorilla/handlers"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+const (
+	logFormatJSON    = "json"
+	logFormatConsole = "console"
+
+	accessLogOff = "off"
+
+	// Replaces secrets in the logs
+	redacted = "[REDACTED]"
+)
+
+var defaultLogSampling = ConfigLogSampling{Initial: 100, Thereafter: 100}
+
+// parseLogLevel parses the level name, e.g. "debug".  Empty means info.
+func parseLogLevel(name string) (zapcore.Level, error) {
+	var level zapcore.Level
+	err := level.UnmarshalText([]byte(name))
+	return level, err
+}
+
+// newLogger builds the logger as configured.  Its level can be changed
+// at runtime via the returned AtomicLevel.
+func newLogger(cl ConfigLogging) (*zap.Logger, zap.AtomicLevel, error) {
+	zc := zap.NewProductionConfig()
+	level, err := parseLogLevel(cl.Level)
+	if err != nil {
+		return nil, zc.Level, err
+	}
+	zc.Level.SetLevel(level)
+	if cl.Format == logFormatConsole {
+		zc.Encoding = logFormatConsole
+		zc.EncoderConfig = zap.NewDevelopmentEncoderConfig()
+	}
+	if cl.Output != "" {
+		zc.OutputPaths = []string{cl.Output}
+	}
+	sampling := defaultLogSampling
+	if cl.Sampling != nil {
+		sampling = *cl.Sampling
+	}
+	if sampling.Initial > 0 {
+		zc.Sampling = &zap.SamplingConfig{
+			Initial:    sampling.Initial,
+			Thereafter: sampling.Thereafter,
+		}
+	} else {
+		zc.Sampling = nil
+	}
+	logger, err := zc.Build()
+	if err != nil {
+		return nil, zc.Level, err
+	}
+	return logger, zc.Level, nil
+}
+
+// openAccessLog opens the destination of the access log, or returns nil
+// if it's disabled.
+func openAccessLog(dest string) (io.Writer, error) {
+	switch dest {
+	case "", "stdout":
+		return os.Stdout, nil
+	case "stderr":
+		return os.Stderr, nil
+	case accessLogOff:
+		return nil, nil
+	}
+	return os.OpenFile(dest, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+}
+
+// writeAccessLog writes the request in Common Log Format as
+// handlers.LoggingHandler does, except that the signatures of the REST
+// API in the query are redacted, for they can be replayed for a while.
+func writeAccessLog(w io.Writer, params handlers.LogFormatterParams) {
+	req := params.Request
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		host = req.RemoteAddr
+	}
+	u := params.URL
+	u.RawQuery = redactQuery(u.RawQuery)
+	fmt.Fprintf(w, "%s - - [%s] %q %d %d\n", host,
+		params.TimeStamp.Format("02/Jan/2006:15:04:05 -0700"),
+		req.Method+" "+u.RequestURI()+" "+req.Proto,
+		params.StatusCode, params.Size)
+}
+
+// redactQuery hides auth_signature and body_md5 in the raw query,
+// keeping the order of the parameters.
+func redactQuery(rawQuery string) string {
+	if rawQuery == "" {
+		return ""
+	}
+	params := strings.Split(rawQuery, "&")
+	for i, p := range params {
+		name, _, _ := strings.Cut(p, "=")
+		if name == "auth_signature" || name == "body_md5" {
+			params[i] = name + "=" + redacted
+		}
+	}
+	return strings.Join(params, "&")
+}
+
+// redactAuth hides the signature of "<key>:<signature>", leaving the
+// key to tell which one is used.
+func redactAuth(auth string) string {
+	if key, _, ok := strings.Cut(auth, ":"); ok {
+		return key + ":" + redacted
+	}
+	return redacted
+}
+
+type logLevelPayload struct {
+	Level string `json:"level"`
+}
+
+// adminGetLogLevel returns the current log level.
+func (s *Supervisor) adminGetLogLevel(w http.ResponseWriter, r *http.Request) {
+	returnJSON(w, logLevelPayload{Level: s.logLevel.Level().String()})
+}
+
+// adminSetLogLevel changes the log level.  It lasts until restart, or
+// until the level in the config file is changed.
+func (s *Supervisor) adminSetLogLevel(w http.ResponseWriter, r *http.Request) {
+	var req logLevelPayload
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		returnErr(s, w, wrapErr(400, err))
+		return
+	}
+	level, err := parseLogLevel(req.Level)
+	if err != nil || req.Level == "" {
+		returnErr(s, w, appErr(400, "Invalid log level: "+req.Level))
+		return
+	}
+	s.logLevel.SetLevel(level)
+	s.logger.Infow("log level changed", "level", level.String())
+	returnJSON(w, logLevelPayload{Level: level.String()})
+}
@@ -0,0 +1,121 @@
+package notifier
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zapcore"
+)
+
+func TestNewLogger(t *testing.T) {
+	output := filepath.Join(t.TempDir(), "notifier.log")
+	logger, level, err := newLogger(ConfigLogging{
+		Level:    "warn",
+		Format:   logFormatConsole,
+		Output:   output,
+		Sampling: &ConfigLogSampling{},
+	})
+	require.Nil(t, err)
+	sugar := logger.Sugar()
+	sugar.Infow("hidden")
+	sugar.Warnw("shown", "key", "value")
+	level.SetLevel(zapcore.InfoLevel)
+	sugar.Infow("shown after level change")
+	_ = logger.Sync()
+
+	data, err := os.ReadFile(output)
+	require.Nil(t, err)
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	require.Len(t, lines, 2)
+	require.Contains(t, lines[0], "WARN")
+	require.Contains(t, lines[0], "shown")
+	require.Contains(t, lines[1], "shown after level change")
+
+	_, _, err = newLogger(ConfigLogging{Level: "verbose"})
+	require.NotNil(t, err)
+}
+
+func TestRedactAuth(t *testing.T) {
+	require.Equal(t, "278d425bdf160c739803:"+redacted,
+		redactAuth("278d425bdf160c739803:58df8b0c36d6982b82c3ecf6b4662e34fe8c25bba48f5369f135bf843651c3a4"))
+	require.Equal(t, redacted, redactAuth("garbage"))
+}
+
+func TestAccessLog(t *testing.T) {
+	dir := t.TempDir()
+	config, err := ReadConfigFile("../config/sample.json")
+	require.Nil(t, err)
+	config.Host, config.Port = "", 0
+	config.Socket = filepath.Join(dir, "main.sock")
+	config.Logging.AccessLog = filepath.Join(dir, "access.log")
+	s := NewSupervisor(config)
+	defer s.Finish()
+
+	server, err := NewServer(s)
+	require.Nil(t, err)
+	done := make(chan error, 1)
+	go func() {
+		done <- server.ListenAndServe()
+	}()
+
+	body := `{"name":"ev","channels":["chan0"],"data":"{}"}`
+	path := signRESTPath("POST", "/apps/testapp/events", "1234567890", "abcdefghij", time.Now(), body)
+	signature := path[strings.Index(path, "auth_signature=")+len("auth_signature="):]
+	signature, _, _ = strings.Cut(signature, "&")
+	client := unixClient(config.Socket)
+	var resp *http.Response
+	for i := 0; i < 50; i++ {
+		resp, err = client.Post("http://notifier"+path, "application/json", strings.NewReader(body))
+		if err == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	require.Nil(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	require.Nil(t, server.Shutdown(ctx))
+	require.ErrorIs(t, <-done, http.ErrServerClosed)
+
+	data, err := os.ReadFile(config.Logging.AccessLog)
+	require.Nil(t, err)
+	log := string(data)
+	require.Contains(t, log, `"POST /apps/testapp/events?auth_key=1234567890&`)
+	require.Contains(t, log, "auth_signature="+redacted)
+	require.Contains(t, log, "body_md5="+redacted)
+	require.Contains(t, log, `HTTP/1.1" 200 `)
+	require.NotContains(t, log, signature)
+}
+
+func TestAdminLogLevel(t *testing.T) {
+	s := initAdminTest(t, "")
+	defer s.Finish()
+	router := newRouter(s)
+
+	_ = doRequest(t, router, "GET", "/admin/log-level", "", http.StatusUnauthorized)
+	rr := doAdminRequest(t, router, "GET", "/admin/log-level", "", http.StatusOK)
+	require.Equal(t, J(`{"level":"info"}`), jsonBody(t, rr))
+
+	rr = doAdminRequest(t, router, "PUT", "/admin/log-level", `{"level":"debug"}`, http.StatusOK)
+	require.Equal(t, J(`{"level":"debug"}`), jsonBody(t, rr))
+	require.True(t, s.logger.Desugar().Core().Enabled(zapcore.DebugLevel))
+
+	_ = doAdminRequest(t, router, "PUT", "/admin/log-level", `{"level":"verbose"}`, http.StatusBadRequest)
+	_ = doAdminRequest(t, router, "PUT", "/admin/log-level", `{}`, http.StatusBadRequest)
+
+	// Changes of the level in the config file are applied on reload
+	config := *s.fileConfig
+	config.Logging.Level = "error"
+	s.Reload(&config)
+	rr = doAdminRequest(t, router, "GET", "/admin/log-level", "", http.StatusOK)
+	require.Equal(t, J(`{"level":"error"}`), jsonBody(t, rr))
+}
@@ -0,0 +1,223 @@
+package notifier
+
+import (
+	"strconv"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const (
+	metricsNamespace = "micro_notifier"
+
+	// Maximum number of distinct values of a label taken from user
+	// input.  Further values are reported as labelOther.
+	maxLabelValues = 50
+	labelOther     = "other"
+)
+
+var (
+	clientConnections = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "client_connections_total",
+		Help:      "Number of WebSocket connections by protocol version, client library and its version.",
+	}, []string{"protocol", "client", "version"})
+
+	rejectedOrigins = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "rejected_origins_total",
+		Help:      "Number of WebSocket connections rejected by the origin allow-list.",
+	}, []string{"app"})
+
+	rateLimited = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "rate_limited_total",
+		Help:      "Number of requests rejected by rate limits, by kind (api or client-event).",
+	}, []string{"app", "kind"})
+
+	// Application metrics.  Labels are application names, which are
+	// bounded by the configuration, and never channel names.
+
+	eventsTriggered = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "events_triggered_total",
+		Help:      "Number of events triggered to channels, by source (api or client).",
+	}, []string{"app", "source"})
+
+	messagesDelivered = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "messages_delivered_total",
+		Help:      "Number of events delivered to the subscribers connected to this process.",
+	}, []string{"app"})
+
+	sentBytes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "sent_bytes_total",
+		Help:      "Number of bytes of WebSocket messages sent.",
+	}, []string{"app"})
+
+	authFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "auth_failures_total",
+		Help:      "Number of authentication failures, by kind (channel or api).",
+	}, []string{"app", "kind"})
+
+	websocketErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "websocket_errors_total",
+		Help:      "Number of WebSocket connections closed with errors, by close code.",
+	}, []string{"app", "code"})
+
+	broadcastDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "broadcast_duration_seconds",
+		Help:      "Time taken to deliver an event to the subscribers connected to this process.",
+		Buckets:   prometheus.ExponentialBuckets(0.0001, 4, 10),
+	}, []string{"app"})
+
+	redisDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "redis_command_duration_seconds",
+		Help:      "Latency of Redis commands, by command name.",
+		Buckets:   prometheus.ExponentialBuckets(0.0001, 4, 10),
+	}, []string{"command"})
+
+	redisReconnects = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "redis_pubsub_reconnects_total",
+		Help:      "Number of reconnections of the Redis pubsub subscription.",
+	})
+)
+
+// labelLimiter guards label cardinality of metrics.  It passes
+// through up to max distinct values, and maps the rest to labelOther.
+type labelLimiter struct {
+	mu     sync.Mutex
+	max    int
+	values map[string]bool
+}
+
+func newLabelLimiter(max int) *labelLimiter {
+	return &labelLimiter{max: max, values: make(map[string]bool)}
+}
+
+func (l *labelLimiter) get(value string) string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.values[value] {
+		return value
+	}
+	if len(l.values) >= l.max {
+		return labelOther
+	}
+	l.values[value] = true
+	return value
+}
+
+var (
+	clientLabels        = newLabelLimiter(maxLabelValues)
+	clientVersionLabels = newLabelLimiter(maxLabelValues)
+	closeCodeLabels     = newLabelLimiter(maxLabelValues)
+	redisCommandLabels  = newLabelLimiter(maxLabelValues)
+)
+
+// observeWebsocketError counts a connection closed with the error code.
+func observeWebsocketError(appname string, code int) {
+	websocketErrors.WithLabelValues(appname,
+		closeCodeLabels.get(strconv.Itoa(code))).Inc()
+}
+
+// appCollector reports the gauges of the applications, computed from
+// the state of the live supervisors on each scrape.  The numbers are
+// of the connections to this process, even in distributed mode.
+type appCollector struct {
+	mu          sync.Mutex
+	supervisors map[*Supervisor]bool
+
+	connections   *prometheus.Desc
+	channels      *prometheus.Desc
+	subscriptions *prometheus.Desc
+}
+
+type appGauges struct {
+	connections   int
+	channels      map[string]bool
+	subscriptions int
+}
+
+var appMetrics = newAppCollector()
+
+func newAppCollector() *appCollector {
+	return &appCollector{
+		supervisors: make(map[*Supervisor]bool),
+		connections: prometheus.NewDesc(metricsNamespace+"_connections",
+			"Number of WebSocket connections.", []string{"app"}, nil),
+		channels: prometheus.NewDesc(metricsNamespace+"_channels",
+			"Number of channels subscribed by the connections.", []string{"app"}, nil),
+		subscriptions: prometheus.NewDesc(metricsNamespace+"_subscriptions",
+			"Number of channel subscriptions of the connections.", []string{"app"}, nil),
+	}
+}
+
+func init() {
+	prometheus.MustRegister(appMetrics)
+}
+
+func (ac *appCollector) register(s *Supervisor) {
+	ac.mu.Lock()
+	defer ac.mu.Unlock()
+	ac.supervisors[s] = true
+}
+
+func (ac *appCollector) unregister(s *Supervisor) {
+	ac.mu.Lock()
+	defer ac.mu.Unlock()
+	delete(ac.supervisors, s)
+}
+
+// Describe implements prometheus.Collector.
+func (ac *appCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- ac.connections
+	ch <- ac.channels
+	ch <- ac.subscriptions
+}
+
+// Collect implements prometheus.Collector.
+func (ac *appCollector) Collect(ch chan<- prometheus.Metric) {
+	ac.mu.Lock()
+	supervisors := make([]*Supervisor, 0, len(ac.supervisors))
+	for s := range ac.supervisors {
+		supervisors = append(supervisors, s)
+	}
+	ac.mu.Unlock()
+
+	gauges := make(map[string]*appGauges)
+	for _, s := range supervisors {
+		for _, a := range s.apps() {
+			g, ok := gauges[a.Name]
+			if !ok {
+				g = &appGauges{channels: make(map[string]bool)}
+				gauges[a.Name] = g
+			}
+			for _, x := range a.Users.ToSlice() {
+				u := x.(*User)
+				g.connections++
+				u.subMu.Lock()
+				for cn, n := range u.subscriptions {
+					g.channels[cn] = true
+					g.subscriptions += n
+				}
+				u.subMu.Unlock()
+			}
+		}
+	}
+	for name, g := range gauges {
+		ch <- prometheus.MustNewConstMetric(ac.connections,
+			prometheus.GaugeValue, float64(g.connections), name)
+		ch <- prometheus.MustNewConstMetric(ac.channels,
+			prometheus.GaugeValue, float64(len(g.channels)), name)
+		ch <- prometheus.MustNewConstMetric(ac.subscriptions,
+			prometheus.GaugeValue, float64(g.subscriptions), name)
+	}
+}
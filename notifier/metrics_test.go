@@ -0,0 +1,102 @@
+package notifier
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLabelLimiter(t *testing.T) {
+	l := newLabelLimiter(2)
+	require.Equal(t, "a", l.get("a"))
+	require.Equal(t, "b", l.get("b"))
+	require.Equal(t, labelOther, l.get("c"))
+	require.Equal(t, "a", l.get("a"))
+}
+
+// gatherAppGauges returns the gauges reported by the collector, keyed
+// by "<metric name>/<app>".
+func gatherAppGauges(t *testing.T, ac *appCollector) map[string]float64 {
+	reg := prometheus.NewPedanticRegistry()
+	require.Nil(t, reg.Register(ac))
+	mfs, err := reg.Gather()
+	require.Nil(t, err)
+	gauges := make(map[string]float64)
+	for _, mf := range mfs {
+		for _, m := range mf.GetMetric() {
+			gauges[mf.GetName()+"/"+m.GetLabel()[0].GetValue()] = m.GetGauge().GetValue()
+		}
+	}
+	return gauges
+}
+
+func TestAppMetrics(t *testing.T) {
+	s := initTest(t, DefaultConfig)
+	defer s.Finish()
+	server := httptest.NewServer(newRouter(s))
+	defer server.Close()
+	router := newRouter(s)
+	ac := newAppCollector()
+	ac.register(s)
+
+	triggered := testutil.ToFloat64(eventsTriggered.WithLabelValues("testapp", "api"))
+	delivered := testutil.ToFloat64(messagesDelivered.WithLabelValues("testapp"))
+	authFailed := testutil.ToFloat64(authFailures.WithLabelValues("testapp", "channel"))
+	notFound := testutil.ToFloat64(websocketErrors.WithLabelValues("", "4001"))
+
+	conn1 := dialTestSocket(t, server, "1234567890")
+	defer conn1.Close()
+	subscribeTestSocket(t, conn1, "my-channel")
+	conn2 := dialTestSocket(t, server, "1234567890")
+	defer conn2.Close()
+	subscribeTestSocket(t, conn2, "my-channel")
+	subscribeTestSocket(t, conn2, "other-channel")
+
+	gauges := gatherAppGauges(t, ac)
+	require.Equal(t, 2.0, gauges["micro_notifier_connections/testapp"])
+	require.Equal(t, 2.0, gauges["micro_notifier_channels/testapp"])
+	require.Equal(t, 3.0, gauges["micro_notifier_subscriptions/testapp"])
+	require.Equal(t, 0.0, gauges["micro_notifier_connections/testapp2"])
+
+	_ = doRequest(t, router, "POST", "/apps/testapp/events",
+		`{"name":"ev","channels":["my-channel"],"data":"{}"}`, http.StatusOK)
+	require.Equal(t, "ev", readTestEvent(t, conn1).Event)
+	require.Equal(t, "ev", readTestEvent(t, conn2).Event)
+	require.Equal(t, triggered+1,
+		testutil.ToFloat64(eventsTriggered.WithLabelValues("testapp", "api")))
+	require.Equal(t, delivered+2,
+		testutil.ToFloat64(messagesDelivered.WithLabelValues("testapp")))
+
+	err := conn1.WriteJSON(map[string]any{
+		"event": "pusher:subscribe",
+		"data":  map[string]any{"channel": "private-chan", "auth": "1234567890:bad"},
+	})
+	require.Nil(t, err)
+	require.Equal(t, "pusher:subscription_error", readTestEvent(t, conn1).Event)
+	require.Equal(t, authFailed+1,
+		testutil.ToFloat64(authFailures.WithLabelValues("testapp", "channel")))
+
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/app/nosuchkey" + testClientQuery
+	conn3, _, err := websocket.DefaultDialer.Dial(url, nil)
+	require.Nil(t, err)
+	defer conn3.Close()
+	require.Equal(t, pusherCodeAppNotFound, readTestError(t, conn3).Code)
+	require.Equal(t, notFound+1,
+		testutil.ToFloat64(websocketErrors.WithLabelValues("", "4001")))
+
+	// Closed connections are no longer counted
+	require.Nil(t, conn2.Close())
+	require.Eventually(t, func() bool {
+		gauges := gatherAppGauges(t, ac)
+		return gauges["micro_notifier_connections/testapp"] == 1 &&
+			gauges["micro_notifier_channels/testapp"] == 1 &&
+			gauges["micro_notifier_subscriptions/testapp"] == 1
+	}, 5*time.Second, 10*time.Millisecond)
+}
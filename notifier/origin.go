@@ -0,0 +1,88 @@
+package notifier
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// originAllowed checks the origin against the patterns.  A pattern is
+// either "*", an exact origin such as "https://example.com", or an
+// origin with a wildcard subdomain such as "https://*.example.com".
+func originAllowed(patterns []string, origin string) bool {
+	origin = strings.ToLower(origin)
+	for _, p := range patterns {
+		p = strings.ToLower(p)
+		if p == "*" || p == origin {
+			return true
+		}
+		i := strings.Index(p, "*.")
+		if i < 0 {
+			continue
+		}
+		prefix, suffix := p[:i], p[i+1:]
+		if strings.HasPrefix(origin, prefix) && strings.HasSuffix(origin, suffix) {
+			sub := origin[len(prefix) : len(origin)-len(suffix)]
+			if sub != "" && !strings.ContainsAny(sub, "/:") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// socketOriginAllowed checks the Origin header of WebSocket upgrade
+// request.  If allowed-origins isn't configured, any origin is allowed.
+// Requests without Origin are from non-browser clients, and allowed.
+func (ca *ConfigApplication) socketOriginAllowed(origin string) bool {
+	if len(ca.AllowedOrigins) == 0 || origin == "" {
+		return true
+	}
+	return originAllowed(ca.AllowedOrigins, origin)
+}
+
+// corsOriginAllowed checks if the origin can access the REST API of the
+// named application.  If appname is empty, the origin must be allowed
+// by any of the applications.  Unlike WebSocket, cross-origin requests
+// are denied unless allowed-origins is configured.
+func (c *Config) corsOriginAllowed(appname string, origin string) bool {
+	for _, ca := range c.Applications {
+		if appname != "" && ca.Name != appname {
+			continue
+		}
+		if originAllowed(ca.AllowedOrigins, origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// corsMiddleware applies CORS policy of the application to REST API.
+// Preflight requests are answered here.
+func (s *Supervisor) corsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Add("Vary", "Origin")
+		allowed := s.config().corsOriginAllowed(mux.Vars(r)["app"], origin)
+		if allowed {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+		}
+		if r.Method == http.MethodOptions {
+			if !allowed {
+				returnErr(s, w, appErr(http.StatusForbidden, "Origin not allowed"))
+				return
+			}
+			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
+			w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+			w.Header().Set("Access-Control-Max-Age", "600")
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
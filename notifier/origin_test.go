@@ -0,0 +1,125 @@
+package notifier
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOriginAllowed(t *testing.T) {
+	patterns := []string{"https://example.com", "https://*.example.org"}
+	for _, tc := range []struct {
+		origin  string
+		allowed bool
+	}{
+		{"https://example.com", true},
+		{"HTTPS://EXAMPLE.COM", true},
+		{"http://example.com", false},
+		{"https://example.com:8443", false},
+		{"https://www.example.com", false},
+		{"https://www.example.org", true},
+		{"https://a.b.example.org", true},
+		{"https://example.org", false},
+		{"https://.example.org", false},
+		{"https://evil.com/.example.org", false},
+		{"https://evilexample.org", false},
+	} {
+		require.Equal(t, tc.allowed, originAllowed(patterns, tc.origin), tc.origin)
+	}
+	require.True(t, originAllowed([]string{"*"}, "https://anything.example"))
+	require.False(t, originAllowed(nil, "https://example.com"))
+}
+
+func initOriginTest(t *testing.T) *Supervisor {
+	config := &Config{
+		Applications: []ConfigApplication{
+			{
+				Name:           "testapp",
+				Key:            "1234567890",
+				Secret:         "abcdefghij",
+				AllowedOrigins: []string{"https://*.example.com"},
+			},
+			{
+				Name:   "testapp2",
+				Key:    "anystringwilldo",
+				Secret: "xyzzy",
+			},
+		},
+	}
+	return NewSupervisor(config)
+}
+
+func TestSocketOrigin(t *testing.T) {
+	s := initOriginTest(t)
+	defer s.Finish()
+	server := httptest.NewServer(newRouter(s))
+	defer server.Close()
+
+	dial := func(key string, origin string) (*websocket.Conn, *http.Response, error) {
+		url := "ws" + strings.TrimPrefix(server.URL, "http") + "/app/" + key + testClientQuery
+		header := http.Header{}
+		if origin != "" {
+			header.Set("Origin", origin)
+		}
+		return websocket.DefaultDialer.Dial(url, header)
+	}
+
+	conn, _, err := dial("1234567890", "https://www.example.com")
+	require.Nil(t, err)
+	conn.Close()
+
+	conn, _, err = dial("1234567890", "")
+	require.Nil(t, err)
+	conn.Close()
+
+	_, resp, err := dial("1234567890", "https://evil.com")
+	require.NotNil(t, err)
+	require.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	// allowed-origins isn't configured
+	conn, _, err = dial("anystringwilldo", "https://evil.com")
+	require.Nil(t, err)
+	conn.Close()
+}
+
+func TestCORS(t *testing.T) {
+	s := initOriginTest(t)
+	defer s.Finish()
+	router := newRouter(s)
+
+	request := func(method string, path string, origin string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest(method, path, nil)
+		require.Nil(t, err)
+		req.Header.Set("Origin", origin)
+		req.Header.Set("Access-Control-Request-Method", "POST")
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := request("OPTIONS", "/apps/testapp/events", "https://admin.example.com")
+	require.Equal(t, http.StatusNoContent, rr.Code)
+	require.Equal(t, "https://admin.example.com", rr.Header().Get("Access-Control-Allow-Origin"))
+	require.Contains(t, rr.Header().Get("Access-Control-Allow-Methods"), "POST")
+
+	rr = request("GET", "/apps/testapp/channels", "https://admin.example.com")
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.Equal(t, "https://admin.example.com", rr.Header().Get("Access-Control-Allow-Origin"))
+
+	rr = request("GET", "/apps", "https://admin.example.com")
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.Equal(t, "https://admin.example.com", rr.Header().Get("Access-Control-Allow-Origin"))
+
+	rr = request("OPTIONS", "/apps/testapp/events", "https://evil.com")
+	require.Equal(t, http.StatusForbidden, rr.Code)
+
+	rr = request("GET", "/apps/testapp/channels", "https://evil.com")
+	require.Equal(t, "", rr.Header().Get("Access-Control-Allow-Origin"))
+
+	rr = request("OPTIONS", "/apps/testapp2/events", "https://admin.example.com")
+	require.Equal(t, http.StatusForbidden, rr.Code)
+}
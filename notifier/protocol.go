@@ -0,0 +1,85 @@
+package notifier
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strconv"
+)
+
+// Supported Pusher protocol versions.
+// Protocol 8 differs from 7 in that the data of pusher:error and
+// pusher:subscription_error are sent as JSON objects rather than
+// JSON-encoded strings.
+const (
+	minProtocolVersion = 7
+	maxProtocolVersion = 8
+
+	// Assumed if the client doesn't tell, as the clients connecting
+	// before the negotiation was introduced
+	defaultProtocolVersion = 7
+)
+
+// Pusher error codes on protocol negotiation.
+const (
+	pusherCodeInvalidVersion      = 4006 // invalid version string format
+	pusherCodeUnsupportedProtocol = 4007 // unsupported protocol version
+)
+
+var (
+	clientNamePattern    = regexp.MustCompile(`^[a-zA-Z0-9_.-]{1,32}$`)
+	clientVersionPattern = regexp.MustCompile(`^(\d+)\.(\d+)`)
+)
+
+// ClientInfo is what the client tells about itself in the query
+// parameters of the connect URL, e.g.
+// /app/{key}?protocol=7&client=js&version=8.3.0&flash=false
+// The flash parameter is obsolete, and ignored.
+type ClientInfo struct {
+	Protocol int
+	Client   string
+	Version  string
+}
+
+// parseClientInfo validates the query parameters of the connect URL.
+// The protocol version defaults to 7 if not given.
+func parseClientInfo(query url.Values) (ClientInfo, error) {
+	var ci ClientInfo
+	p := query.Get("protocol")
+	if p == "" {
+		p = strconv.Itoa(defaultProtocolVersion)
+	}
+	protocol, err := strconv.Atoi(p)
+	if err != nil {
+		return ci, pusherErr(400, pusherCodeInvalidVersion,
+			fmt.Sprintf("Invalid version string format `%s'", p))
+	}
+	if protocol < minProtocolVersion || protocol > maxProtocolVersion {
+		return ci, pusherErr(400, pusherCodeUnsupportedProtocol,
+			fmt.Sprintf("Unsupported protocol version %d", protocol))
+	}
+	ci.Protocol = protocol
+	ci.Client = query.Get("client")
+	ci.Version = query.Get("version")
+	return ci, nil
+}
+
+// structuredErrors returns true if the client expects the data of
+// error events as JSON objects.
+func (ci ClientInfo) structuredErrors() bool {
+	return ci.Protocol >= 8
+}
+
+// observe records the client in the metrics.  The label values are
+// sanitized to keep cardinality under control.
+func (ci ClientInfo) observe() {
+	client := "unknown"
+	if clientNamePattern.MatchString(ci.Client) {
+		client = clientLabels.get(ci.Client)
+	}
+	version := "unknown"
+	if m := clientVersionPattern.FindStringSubmatch(ci.Version); m != nil {
+		version = clientVersionLabels.get(m[1] + "." + m[2])
+	}
+	clientConnections.WithLabelValues(strconv.Itoa(ci.Protocol), client, version).Inc()
+}
@@ -0,0 +1,419 @@
+// Package pushertest provides a Pusher protocol client for tests of the
+// notifier, which speaks to it over WebSocket as pusher-js does.
+//
+//	d := pushertest.Dialer{Key: "1234567890", Secret: "abcdefghij"}
+//	c := d.Dial(t, server.URL)
+//	defer c.Close()
+//	c.Subscribe("private-my-channel")
+//	// trigger my-event via the REST API
+//	ev := c.ExpectEvent("private-my-channel", "my-event")
+//
+// Failures are reported to the testing.TB given on Dial.
+package pushertest
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// DefaultProtocol is the version of Pusher protocol spoken by default.
+	DefaultProtocol = 7
+
+	// DefaultTimeout is how long events are waited for by default.
+	DefaultTimeout = 5 * time.Second
+)
+
+// Event is a message sent by the server.
+type Event struct {
+	Event   string `json:"event"`
+	Channel string `json:"channel,omitempty"`
+	Data    string `json:"data"` // JSON text of the data if not a string
+	UserID  string `json:"user_id,omitempty"`
+}
+
+// UnmarshalJSON accepts data given as an object as well as a string;
+// protocol 8 sends pusher:error with an object.
+func (ev *Event) UnmarshalJSON(b []byte) error {
+	var raw struct {
+		Event   string          `json:"event"`
+		Channel string          `json:"channel"`
+		Data    json.RawMessage `json:"data"`
+		UserID  string          `json:"user_id"`
+	}
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return err
+	}
+	ev.Event, ev.Channel, ev.UserID = raw.Event, raw.Channel, raw.UserID
+	ev.Data = string(raw.Data)
+	if len(raw.Data) > 0 && raw.Data[0] == '"' {
+		return json.Unmarshal(raw.Data, &ev.Data)
+	}
+	return nil
+}
+
+// ErrorData is the data of pusher:error.
+type ErrorData struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// SubscriptionError is the data of pusher:subscription_error.
+type SubscriptionError struct {
+	Type   string `json:"type"`
+	Error  string `json:"error"`
+	Status int    `json:"status"`
+}
+
+// Dialer holds the settings to connect to the notifier.
+type Dialer struct {
+	Key string
+
+	// Secret of the application to sign subscriptions to private
+	// channels, unless Authorizer is given.
+	Secret string
+
+	// Authorizer returns the auth of a subscription to the private
+	// channel, as the auth endpoint of an application server does.
+	Authorizer func(socketID string, channel string) (string, error)
+
+	Protocol        int           // DefaultProtocol if zero
+	Client          string        // client library name; "pushertest" if empty
+	TLSClientConfig *tls.Config   // for wss:// or https:// URLs
+	Timeout         time.Duration // DefaultTimeout if zero
+}
+
+// Client is a connection to the notifier.
+type Client struct {
+	Conn            *websocket.Conn
+	SocketID        string
+	ActivityTimeout int // seconds
+
+	t       testing.TB
+	dialer  Dialer
+	timeout time.Duration
+	events  chan Event
+	err     error // the reason why events is closed
+	closed  chan struct{}
+}
+
+// Dial connects to the notifier at serverURL, e.g. server.URL of
+// httptest.Server, and waits for pusher:connection_established.
+func (d *Dialer) Dial(t testing.TB, serverURL string) *Client {
+	t.Helper()
+	c, ev, err := d.dial(t, serverURL)
+	if err != nil {
+		t.Fatalf("pushertest: %v", err)
+	}
+	if ev.Event != "pusher:connection_established" {
+		c.Close()
+		t.Fatalf("pushertest: connection failed: %s %s", ev.Event, ev.Data)
+	}
+	var data struct {
+		SocketID        string `json:"socket_id"`
+		ActivityTimeout int    `json:"activity_timeout"`
+	}
+	if err := json.Unmarshal([]byte(ev.Data), &data); err != nil {
+		c.Close()
+		t.Fatalf("pushertest: invalid connection_established: %v", err)
+	}
+	c.SocketID, c.ActivityTimeout = data.SocketID, data.ActivityTimeout
+	return c
+}
+
+// DialError connects to the notifier expecting it to refuse the
+// connection, and returns the pusher:error sent before closing.
+func (d *Dialer) DialError(t testing.TB, serverURL string) ErrorData {
+	t.Helper()
+	c, ev, err := d.dial(t, serverURL)
+	if err != nil {
+		t.Fatalf("pushertest: %v", err)
+	}
+	defer c.Close()
+	if ev.Event != "pusher:error" {
+		t.Fatalf("pushertest: expected pusher:error, received %s %s", ev.Event, ev.Data)
+	}
+	data := c.decodeError(ev)
+	c.ExpectClose(data.Code)
+	return data
+}
+
+// dial connects and returns the first event.
+func (d *Dialer) dial(t testing.TB, serverURL string) (*Client, Event, error) {
+	protocol := d.Protocol
+	if protocol == 0 {
+		protocol = DefaultProtocol
+	}
+	client := d.Client
+	if client == "" {
+		client = "pushertest"
+	}
+	timeout := d.Timeout
+	if timeout == 0 {
+		timeout = DefaultTimeout
+	}
+	url := strings.Replace(serverURL, "http", "ws", 1) + "/app/" + d.Key +
+		"?protocol=" + strconv.Itoa(protocol) + "&client=" + client + "&version=1.0.0"
+	wd := websocket.Dialer{HandshakeTimeout: timeout, TLSClientConfig: d.TLSClientConfig}
+	conn, _, err := wd.Dial(url, nil)
+	if err != nil {
+		return nil, Event{}, err
+	}
+	c := &Client{
+		Conn:    conn,
+		t:       t,
+		dialer:  *d,
+		timeout: timeout,
+		events:  make(chan Event, 100),
+		closed:  make(chan struct{}),
+	}
+	go c.readLoop()
+	ev, err := c.next()
+	if err != nil {
+		c.Close()
+		return nil, Event{}, err
+	}
+	return c, ev, nil
+}
+
+// readLoop reads the messages in the background, so that waiting for
+// them can time out without breaking the connection.
+func (c *Client) readLoop() {
+	defer close(c.events)
+	for {
+		var ev Event
+		if err := c.Conn.ReadJSON(&ev); err != nil {
+			c.err = err
+			return
+		}
+		select {
+		case c.events <- ev:
+		case <-c.closed:
+			return
+		}
+	}
+}
+
+// next returns the next event, or an error on timeout or closing.
+func (c *Client) next() (Event, error) {
+	select {
+	case ev, ok := <-c.events:
+		if !ok {
+			return ev, fmt.Errorf("connection closed: %w", c.err)
+		}
+		return ev, nil
+	case <-time.After(c.timeout):
+		return Event{}, fmt.Errorf("no event received in %v", c.timeout)
+	}
+}
+
+// Close closes the connection.  It may be called more than once.
+func (c *Client) Close() {
+	select {
+	case <-c.closed:
+	default:
+		close(c.closed)
+	}
+	_ = c.Conn.Close()
+}
+
+// Send sends the event.  Data is sent as is if a string, or encoded in
+// JSON otherwise.
+func (c *Client) Send(event string, channel string, data any) {
+	c.t.Helper()
+	msg := map[string]any{"event": event, "data": data}
+	if channel != "" {
+		msg["channel"] = channel
+	}
+	if err := c.Conn.WriteJSON(msg); err != nil {
+		c.t.Fatalf("pushertest: send %s: %v", event, err)
+	}
+}
+
+// Next returns the next event sent by the server.
+func (c *Client) Next() Event {
+	c.t.Helper()
+	ev, err := c.next()
+	if err != nil {
+		c.t.Fatalf("pushertest: %v", err)
+	}
+	return ev
+}
+
+// Expect returns the next event, which must be of the name.
+func (c *Client) Expect(event string) Event {
+	c.t.Helper()
+	ev := c.Next()
+	if ev.Event != event {
+		c.t.Fatalf("pushertest: expected %s, received %s %s %s", event, ev.Event, ev.Channel, ev.Data)
+	}
+	return ev
+}
+
+// ExpectEvent returns the next event, which must be of the name on the
+// channel.
+func (c *Client) ExpectEvent(channel string, event string) Event {
+	c.t.Helper()
+	ev := c.Expect(event)
+	if ev.Channel != channel {
+		c.t.Fatalf("pushertest: expected %s on %s, received on %s", event, channel, ev.Channel)
+	}
+	return ev
+}
+
+// ExpectNothing makes sure that no event is received for the duration.
+func (c *Client) ExpectNothing(d time.Duration) {
+	c.t.Helper()
+	select {
+	case ev, ok := <-c.events:
+		if ok {
+			c.t.Fatalf("pushertest: unexpected event %s %s %s", ev.Event, ev.Channel, ev.Data)
+		}
+	case <-time.After(d):
+	}
+}
+
+// ExpectError returns the data of the next event, which must be
+// pusher:error.
+func (c *Client) ExpectError() ErrorData {
+	c.t.Helper()
+	return c.decodeError(c.Expect("pusher:error"))
+}
+
+func (c *Client) decodeError(ev Event) ErrorData {
+	c.t.Helper()
+	var data ErrorData
+	if err := json.Unmarshal([]byte(ev.Data), &data); err != nil {
+		c.t.Fatalf("pushertest: invalid pusher:error data %s: %v", ev.Data, err)
+	}
+	return data
+}
+
+// ExpectClose makes sure that the server closes the connection with the
+// code.
+func (c *Client) ExpectClose(code int) {
+	c.t.Helper()
+	select {
+	case ev, ok := <-c.events:
+		if ok {
+			c.t.Fatalf("pushertest: expected closing, received %s %s", ev.Event, ev.Data)
+		}
+		closeErr, isClose := c.err.(*websocket.CloseError)
+		if !isClose {
+			c.t.Fatalf("pushertest: expected close code %d: %v", code, c.err)
+		}
+		if closeErr.Code != code {
+			c.t.Fatalf("pushertest: expected close code %d, closed with %d", code, closeErr.Code)
+		}
+	case <-time.After(c.timeout):
+		c.t.Fatalf("pushertest: not closed in %v", c.timeout)
+	}
+}
+
+// auth returns the auth of the subscription to the private channel.
+func (c *Client) auth(channel string) string {
+	c.t.Helper()
+	if c.dialer.Authorizer != nil {
+		auth, err := c.dialer.Authorizer(c.SocketID, channel)
+		if err != nil {
+			c.t.Fatalf("pushertest: authorizing %s: %v", channel, err)
+		}
+		return auth
+	}
+	return Sign(c.dialer.Key, c.dialer.Secret, c.SocketID, channel)
+}
+
+// Sign returns the auth of the subscription to the private channel
+// signed with the secret.
+func Sign(key string, secret string, socketID string, channel string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	_, _ = mac.Write([]byte(socketID + ":" + channel))
+	return key + ":" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// SignPresence returns the auth of the subscription to the presence
+// channel with channelData, the JSON of the user, signed with the secret.
+func SignPresence(key string, secret string, socketID string, channel string, channelData string) string {
+	return Sign(key, secret, socketID, channel+":"+channelData)
+}
+
+// SubscribeAuth requests the subscription with the auth, which is
+// omitted if empty, and returns the response;
+// pusher_internal:subscription_succeeded or pusher:subscription_error.
+func (c *Client) SubscribeAuth(channel string, auth string) Event {
+	c.t.Helper()
+	return c.SubscribePresence(channel, auth, "")
+}
+
+// SubscribePresence requests the subscription with the auth and the
+// channel_data, which are omitted if empty, and returns the response as
+// SubscribeAuth does.
+func (c *Client) SubscribePresence(channel string, auth string, channelData string) Event {
+	c.t.Helper()
+	data := map[string]string{"channel": channel}
+	if auth != "" {
+		data["auth"] = auth
+	}
+	if channelData != "" {
+		data["channel_data"] = channelData
+	}
+	c.Send("pusher:subscribe", "", data)
+	ev := c.Next()
+	if ev.Channel != channel ||
+		(ev.Event != "pusher_internal:subscription_succeeded" && ev.Event != "pusher:subscription_error") {
+		c.t.Fatalf("pushertest: expected the result of subscribing %s, received %s %s %s",
+			channel, ev.Event, ev.Channel, ev.Data)
+	}
+	return ev
+}
+
+// Subscribe subscribes to the channel, authorizing private ones.
+func (c *Client) Subscribe(channel string) {
+	c.t.Helper()
+	auth := ""
+	if strings.HasPrefix(channel, "private-") {
+		auth = c.auth(channel)
+	}
+	ev := c.SubscribeAuth(channel, auth)
+	if ev.Event != "pusher_internal:subscription_succeeded" {
+		c.t.Fatalf("pushertest: subscribing %s failed: %s", channel, ev.Data)
+	}
+}
+
+// ExpectSubscriptionError requests the subscription with the auth, and
+// returns the error, which must be the result.
+func (c *Client) ExpectSubscriptionError(channel string, auth string) SubscriptionError {
+	c.t.Helper()
+	ev := c.SubscribeAuth(channel, auth)
+	if ev.Event != "pusher:subscription_error" {
+		c.t.Fatalf("pushertest: subscribing %s succeeded unexpectedly", channel)
+	}
+	var data SubscriptionError
+	if err := json.Unmarshal([]byte(ev.Data), &data); err != nil {
+		c.t.Fatalf("pushertest: invalid pusher:subscription_error data %s: %v", ev.Data, err)
+	}
+	return data
+}
+
+// Unsubscribe unsubscribes from the channel.  The server doesn't reply.
+func (c *Client) Unsubscribe(channel string) {
+	c.t.Helper()
+	c.Send("pusher:unsubscribe", "", map[string]string{"channel": channel})
+}
+
+// Ping sends pusher:ping and waits for pusher:pong.
+func (c *Client) Ping() {
+	c.t.Helper()
+	c.Send("pusher:ping", "", map[string]string{})
+	c.Expect("pusher:pong")
+}
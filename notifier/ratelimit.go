@@ -0,0 +1,139 @@
+package notifier
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	defaultClientEventRate = 10 // per second, as Pusher does
+)
+
+// tokenBucket is a token bucket rate limiter.  The same algorithm is
+// implemented in takeTokenScript for distributed mode.
+type tokenBucket struct {
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time // zero if the bucket is full and untouched
+}
+
+// take takes a token at now.  Returns 0 on success, or the duration
+// to wait until a token is available.
+func (b *tokenBucket) take(rate float64, burst int, now time.Time) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.last.IsZero() {
+		b.tokens = float64(burst)
+		b.last = now
+	}
+	if now.After(b.last) {
+		b.tokens = math.Min(float64(burst),
+			b.tokens+now.Sub(b.last).Seconds()*rate)
+		b.last = now
+	}
+	if b.tokens >= 1 {
+		b.tokens--
+		return 0
+	}
+	return time.Duration(math.Ceil((1 - b.tokens) / rate * float64(time.Second)))
+}
+
+// bucketSet holds token buckets by key.
+type bucketSet struct {
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+}
+
+func newBucketSet() *bucketSet {
+	return &bucketSet{buckets: make(map[string]*tokenBucket)}
+}
+
+func (bs *bucketSet) get(key string) *tokenBucket {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+	b, ok := bs.buckets[key]
+	if !ok {
+		b = &tokenBucket{}
+		bs.buckets[key] = b
+	}
+	return b
+}
+
+// limits returns the rate and burst, with the default rate applied.
+// Rate is 0 if unlimited.
+func (rl ConfigRateLimit) limits(defaultRate float64) (float64, int) {
+	rate := rl.Rate
+	if rate == 0 {
+		rate = defaultRate
+	}
+	if rate <= 0 {
+		return 0, 0
+	}
+	burst := rl.Burst
+	if burst <= 0 {
+		burst = int(math.Ceil(rate))
+	}
+	return rate, burst
+}
+
+// takeAPIToken takes a token from the REST API rate limit of the
+// application.  In distributed mode the limit is shared among the
+// cluster via Redis.  Returns 0 on success, or the duration to wait.
+func (s *Supervisor) takeAPIToken(ca *ConfigApplication) time.Duration {
+	rate, burst := ca.APIRateLimit.limits(0)
+	if rate == 0 {
+		return 0
+	}
+	if s.db != nil {
+		wait, apperr := s.db.takeToken(ca.Name+"/api-rate-limit", rate, burst)
+		if apperr == nil {
+			return wait
+		}
+		// Don't stop the service on Redis failures
+		s.logger.Errorw("rate limit error", "app", ca.Name, "error", apperr)
+		return 0
+	}
+	return s.apiBuckets.get(ca.Name).take(rate, burst, time.Now())
+}
+
+// rateLimitMiddleware enforces the REST API rate limit of the
+// application given in the path.
+func (s *Supervisor) rateLimitMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		appname := mux.Vars(r)["app"]
+		if appname == "" || r.Method == http.MethodOptions {
+			next.ServeHTTP(w, r)
+			return
+		}
+		ca := s.config().GetApp(appname)
+		if ca == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if wait := s.takeAPIToken(ca); wait > 0 {
+			rateLimited.WithLabelValues(appname, "api").Inc()
+			secs := int(math.Ceil(wait.Seconds()))
+			w.Header().Set("Retry-After", strconv.Itoa(secs))
+			returnErr(s, w, appErr(http.StatusTooManyRequests,
+				fmt.Sprintf("Rate limit exceeded; retry after %d seconds", secs)))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// takeClientEventToken takes a token from the client event rate limit
+// of the connection.  Returns false if the event should be rejected.
+func (u *User) takeClientEventToken(ca *ConfigApplication) bool {
+	rate, burst := ca.ClientEventRateLimit.limits(defaultClientEventRate)
+	if rate == 0 {
+		return true
+	}
+	return u.clientEvents.take(rate, burst, time.Now()) == 0
+}
@@ -0,0 +1,62 @@
+package notifier
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTokenBucket(t *testing.T) {
+	var b tokenBucket
+	now := time.Now()
+
+	require.Equal(t, time.Duration(0), b.take(2, 3, now))
+	require.Equal(t, time.Duration(0), b.take(2, 3, now))
+	require.Equal(t, time.Duration(0), b.take(2, 3, now))
+	require.Equal(t, 500*time.Millisecond, b.take(2, 3, now))
+
+	now = now.Add(250 * time.Millisecond)
+	require.Equal(t, 250*time.Millisecond, b.take(2, 3, now))
+	now = now.Add(250 * time.Millisecond)
+	require.Equal(t, time.Duration(0), b.take(2, 3, now))
+
+	// Tokens don't exceed burst
+	now = now.Add(time.Hour)
+	for i := 0; i < 3; i++ {
+		require.Equal(t, time.Duration(0), b.take(2, 3, now))
+	}
+	require.NotEqual(t, time.Duration(0), b.take(2, 3, now))
+}
+
+func TestRateLimitLimits(t *testing.T) {
+	rate, burst := ConfigRateLimit{}.limits(0)
+	require.Equal(t, 0.0, rate)
+	rate, burst = ConfigRateLimit{}.limits(defaultClientEventRate)
+	require.Equal(t, 10.0, rate)
+	require.Equal(t, 10, burst)
+	rate, _ = ConfigRateLimit{Rate: -1}.limits(defaultClientEventRate)
+	require.Equal(t, 0.0, rate)
+	rate, burst = ConfigRateLimit{Rate: 0.5}.limits(0)
+	require.Equal(t, 0.5, rate)
+	require.Equal(t, 1, burst)
+}
+
+func TestAPIRateLimit(t *testing.T) {
+	s := initTest(t, DefaultConfig)
+	defer s.Finish()
+	s.Config.Applications[0].APIRateLimit = ConfigRateLimit{Rate: 0.1, Burst: 2}
+	router := newRouter(s)
+
+	_ = doRequest(t, router, "GET", "/apps/testapp/channels", "", http.StatusOK)
+	_ = doRequest(t, router, "POST", "/apps/testapp/events",
+		`{"name":"ev","channels":["chan0"],"data":"{}"}`, http.StatusOK)
+	rr := doRequest(t, router, "POST", "/apps/testapp/events",
+		`{"name":"ev","channels":["chan0"],"data":"{}"}`, http.StatusTooManyRequests)
+	require.Equal(t, "10", rr.Header().Get("Retry-After"))
+
+	// Other applications and the application list aren't affected
+	_ = doRequest(t, router, "GET", "/apps/testapp2/channels", "", http.StatusOK)
+	_ = doRequest(t, router, "GET", "/apps", "", http.StatusOK)
+}
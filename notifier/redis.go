@@ -5,11 +5,14 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/FZambia/sentinel"
 	"github.com/gomodule/redigo/redis"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // Logical databases
@@ -18,7 +21,23 @@ import (
 // Keys
 //   <application>/channels/<channel> - serialized Channel object
 //   <application>/users              - array of user ids
+//   <application>/channel-names      - set of channel names (for quota)
+//   <application>/connections-per-ip - hash of remote IP to # of connections
+//   <application>/api-rate-limit     - token bucket of REST API rate limit
+//   <application>/subscription-count/<channel>
+//                                    - hash of the last notified subscription
+//                                      count and the time of the next one
+//   applications                     - hash of application name to
+//                                      ConfigApplication registered via admin API
+//   nodes                            - hash of node ID to the status of the
+//                                      process, updated periodically
+//   nodes/<node>/connections         - hash of <application>/<uid> to the
+//                                      remote IP of the connections to the
+//                                      process, to clean up after it dies
 //   events                           - pubsub channel for events
+//   applications                     - pubsub channel to notify changes of
+//                                      the applications hash
+//   <application>/debug              - pubsub channel for the debug console
 
 // DB encapsulates Redis operation from other parts
 type DB struct {
@@ -27,7 +46,13 @@ type DB struct {
 	// If set, called when event is broadcast via Redis PubSub.
 	// Mainly used for testing.  Can return false to prevent
 	// sending actual event to the browsers.
-	eventCallback func(*EventRequest) bool
+	eventCallback atomic.Pointer[func(*EventRequest) bool]
+}
+
+// setEventCallback sets eventCallback, which may be done while
+// subscribing.
+func (db *DB) setEventCallback(f func(*EventRequest) bool) {
+	db.eventCallback.Store(&f)
 }
 
 // UIDArray is kept in <application>/users
@@ -41,6 +66,10 @@ type EventRequest struct {
 	Data        string // event payload
 	Application string // application name
 	Channel     string // target channel name
+	Exclude     string // socket ID not to deliver the event to
+
+	// W3C trace context of the publisher, if traced
+	TraceContext map[string]string `json:",omitempty"`
 }
 
 func connectDB(ctx context.Context, config *Config) (redis.Conn, error) {
@@ -178,10 +207,48 @@ func InitDB(config *Config) *DB {
 }
 
 func (db *DB) getPool() (redis.Conn, error) {
-	ctx := context.Background()
-	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	return db.getPoolContext(context.Background())
+}
+
+// getPoolContext returns a connection whose commands are traced as
+// children of the span in ctx, if any.
+func (db *DB) getPoolContext(ctx context.Context) (redis.Conn, error) {
+	timeout, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	c, err := db.pool.GetContext(timeout)
+	if err != nil {
+		return nil, err
+	}
+	return tracedConn{timedConn{c}, ctx}, nil
+}
+
+// timedConn observes the latency of the commands.
+type timedConn struct {
+	redis.Conn
+}
+
+func (c timedConn) Do(command string, args ...any) (any, error) {
+	start := time.Now()
+	r, err := c.Conn.Do(command, args...)
+	if command != "" {
+		redisDuration.WithLabelValues(redisCommandLabels.get(strings.ToUpper(command))).
+			Observe(time.Since(start).Seconds())
+	}
+	return r, err
+}
+
+// ping checks that Redis responds within timeout, including the time to
+// connect.
+func (db *DB) ping(timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
 	defer cancel()
-	return db.pool.GetContext(ctx)
+	c, err := db.pool.GetContext(ctx)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	_, err = redis.DoContext(c, ctx, "PING")
+	return err
 }
 
 // FlushDB flushes Redis commands
@@ -211,14 +278,10 @@ func (db *DB) FinishDB() {
 
 // The first half of the transaction.  WATCH the key and fetch its value.
 // On error, UNWATCH the key.
-func (db *DB) watchAndGet(key string) (any, error) {
-	c, err := db.getPool()
-	if err != nil {
-		return nil, wrapErr(500, err)
-	}
-	defer c.Close()
-
-	_, err = c.Do("WATCH", key)
+// NB: The whole transaction must be run on the same connection c,
+// for WATCH is effective only within the connection.
+func (db *DB) watchAndGet(c redis.Conn, key string) (any, error) {
+	_, err := c.Do("WATCH", key)
 	if err != nil {
 		return nil, wrapErr(500, err)
 	}
@@ -231,42 +294,37 @@ func (db *DB) watchAndGet(key string) (any, error) {
 }
 
 // A common idiom to cancel the transacitons
-func (db *DB) unwatch() {
-	c, err := db.getPool()
-	if err != nil {
-		log.Printf("unwatch: failed to get pool: %+v", err)
-		return
-	}
-
+func (db *DB) unwatch(c redis.Conn) {
 	_, _ = c.Do("UNWATCH")
-	_ = c.Close()
 }
 
 // The latter half of the transaction.  Assume the key is already
 // WATCHed.  Encode payload by json and write it then commit,
 // or discard everything if any step fails.  On success, returns
 // what EXEC returns and nil.  On error, returns nil and *appError.
-func (db *DB) updateAndCommit(key string, payload any) (any, error) {
-	c, err := db.getPool()
-	if err != nil {
-		return nil, wrapErr(500, err)
-	}
-	defer c.Close()
-
+func (db *DB) updateAndCommit(c redis.Conn, key string, payload any) (any, error) {
 	data, err := json.Marshal(payload)
 	if err != nil {
 		_, _ = c.Do("UNWATCH")
 		return nil, wrapErr(500, err)
 	}
-	_, err = c.Do("MULTI")
+	return db.commit(c, []any{"SET", key, data})
+}
+
+// Run commands in MULTI/EXEC.  Each command is a slice of the command
+// name followed by its arguments.  The keys are assumed to be WATCHed.
+func (db *DB) commit(c redis.Conn, commands ...[]any) (any, error) {
+	_, err := c.Do("MULTI")
 	if err != nil {
 		_, _ = c.Do("UNWATCH")
 		return nil, wrapErr(500, err)
 	}
-	_, err = c.Do("SET", key, data)
-	if err != nil {
-		_, _ = c.Do("DISCARD")
-		return nil, wrapErr(500, err)
+	for _, cmd := range commands {
+		_, err = c.Do(cmd[0].(string), cmd[1:]...)
+		if err != nil {
+			_, _ = c.Do("DISCARD")
+			return nil, wrapErr(500, err)
+		}
 	}
 	r, err := c.Do("EXEC")
 	if err != nil {
@@ -284,9 +342,35 @@ func (db *DB) GetChannels(appname string) (map[string]*Channel, error) {
 	}
 	defer c.Close()
 
+	keys, apperr := db.scanChannelKeys(c, appname)
+	if apperr != nil {
+		return nil, apperr
+	}
+
+	channels := make(map[string]*Channel)
+	for _, key := range keys {
+		r, err := c.Do("GET", key)
+		if err != nil {
+			return nil, wrapErr(500, err)
+		}
+		if r == nil {
+			continue // deleted after SCAN
+		}
+		var ch Channel
+		err = json.Unmarshal(r.([]byte), &ch)
+		if err != nil {
+			return nil, wrapErr(500, err)
+		}
+		channels[ch.Name] = &ch
+	}
+	return channels, nil
+}
+
+// Returns all the keys of channels in the application.
+func (db *DB) scanChannelKeys(c redis.Conn, appname string) ([]string, error) {
 	pattern := appname + "/channels/*"
 	cursor := "0"
-	channels := make(map[string]*Channel)
+	var keys []string
 
 	for {
 		r, err := c.Do("SCAN", cursor, "MATCH", pattern)
@@ -300,24 +384,12 @@ func (db *DB) GetChannels(appname string) (map[string]*Channel, error) {
 		}
 
 		next := string(ar[0].([]byte))
-		keys, ok := ar[1].([]any)
+		ks, ok := ar[1].([]any)
 		if !ok {
 			return nil, appErr(500, fmt.Sprintf("redis SCAN returned weird value: %v", r))
 		}
-		nkeys := len(keys)
-
-		for i := 0; i < nkeys; i++ {
-			key := string(keys[i].([]byte))
-			r1, err := c.Do("GET", key)
-			if err != nil {
-				return nil, wrapErr(500, err)
-			}
-			var ch Channel
-			err = json.Unmarshal(r1.([]byte), &ch)
-			if err != nil {
-				return nil, wrapErr(500, err)
-			}
-			channels[ch.Name] = &ch
+		for _, k := range ks {
+			keys = append(keys, string(k.([]byte)))
 		}
 
 		if next == "0" {
@@ -325,10 +397,11 @@ func (db *DB) GetChannels(appname string) (map[string]*Channel, error) {
 		}
 		cursor = next
 	}
-	return channels, nil
+	return keys, nil
 }
 
-// GetChannel returns the named channel.  The named channel must exist.
+// GetChannel returns the named channel.  If the channel doesn't exist,
+// 404 error is returned.
 func (db *DB) GetChannel(appname string, channame string) (*Channel, error) {
 	c, err := db.getPool()
 	if err != nil {
@@ -341,7 +414,7 @@ func (db *DB) GetChannel(appname string, channame string) (*Channel, error) {
 		return nil, wrapErr(500, err)
 	}
 	if r == nil {
-		return nil, appErr(400, fmt.Sprintf("No such channel: %s in %s", channame, appname))
+		return nil, appErr(404, fmt.Sprintf("No such channel: %s in %s", channame, appname))
 	}
 
 	var ch Channel
@@ -354,16 +427,24 @@ func (db *DB) GetChannel(appname string, channame string) (*Channel, error) {
 
 // GetOrCreateChannel returns the named channel; if the named channel
 // doesn't exist, create one.
+// NB: The created channel remains until somebody subscribes and
+// unsubscribes it, or PurgeEmptyChannels is called.
 func (db *DB) GetOrCreateChannel(appname string, channame string) (*Channel, error) {
+	c, err := db.getPool()
+	if err != nil {
+		return nil, wrapErr(500, err)
+	}
+	defer c.Close()
+
 	key := appname + "/channels/" + channame
 	var ch Channel
 
-	r, apperr := db.watchAndGet(key)
+	r, apperr := db.watchAndGet(c, key)
 	if apperr != nil {
 		return nil, apperr
 	}
 	if r != nil {
-		db.unwatch()
+		db.unwatch(c)
 		err := json.Unmarshal(r.([]byte), &ch)
 		if err != nil {
 			return nil, wrapErr(500, err)
@@ -371,7 +452,14 @@ func (db *DB) GetOrCreateChannel(appname string, channame string) (*Channel, err
 		return &ch, nil
 	}
 	ch = Channel{Name: channame, Users: make(map[int]int)}
-	r, apperr = db.updateAndCommit(key, ch)
+	data, err := json.Marshal(ch)
+	if err != nil {
+		db.unwatch(c)
+		return nil, wrapErr(500, err)
+	}
+	r, apperr = db.commit(c,
+		[]any{"SET", key, data},
+		[]any{"SADD", appname + "/channel-names", channame})
 	if apperr != nil {
 		return nil, apperr
 	}
@@ -382,76 +470,187 @@ func (db *DB) GetOrCreateChannel(appname string, channame string) (*Channel, err
 	return &ch, nil
 }
 
-// AddUserIDToChannel adds UID to the list of subscribers in the specified
-// channel.
-func (db *DB) AddUserIDToChannel(appname string, channame string, uid int) error {
+// Modify the channel in a transaction.  If the channel doesn't exist,
+// an empty channel is passed to modify if create is true; otherwise
+// errNoChannel is returned.  The channel is deleted if no subscribers
+// are left after modification.  If maxChannels is positive, a new
+// channel can't be created beyond it.
+func (db *DB) modifyChannel(appname string, channame string, create bool,
+	maxChannels int, modify func(*Channel)) error {
+	c, err := db.getPool()
+	if err != nil {
+		return wrapErr(500, err)
+	}
+	defer c.Close()
+
 	key := appname + "/channels/" + channame
+	namesKey := appname + "/channel-names"
 	var ch Channel
 
-	r, apperr := db.watchAndGet(key)
+	if create && maxChannels > 0 {
+		_, err = c.Do("WATCH", namesKey)
+		if err != nil {
+			return wrapErr(500, err)
+		}
+	}
+	r, apperr := db.watchAndGet(c, key)
 	if apperr != nil {
 		return apperr
 	}
 	if r == nil {
+		if !create {
+			db.unwatch(c)
+			return errNoChannel
+		}
+		if maxChannels > 0 {
+			n, err := redis.Int(c.Do("SCARD", namesKey))
+			if err != nil {
+				db.unwatch(c)
+				return wrapErr(500, err)
+			}
+			if n >= maxChannels {
+				db.unwatch(c)
+				return overQuotaErr("Application is over channel quota")
+			}
+		}
 		ch = Channel{Name: channame, Users: make(map[int]int)}
 	} else {
 		err := json.Unmarshal(r.([]byte), &ch)
 		if err != nil {
-			db.unwatch()
+			db.unwatch(c)
+			return wrapErr(500, err)
+		}
+	}
+	modify(&ch)
+	if ch.UserCount() == 0 {
+		r, apperr = db.commit(c,
+			[]any{"DEL", key},
+			[]any{"SREM", namesKey, channame})
+	} else {
+		data, err := json.Marshal(ch)
+		if err != nil {
+			db.unwatch(c)
 			return wrapErr(500, err)
 		}
+		// SADD is idempotent, and also registers channels created
+		// by older versions.
+		r, apperr = db.commit(c,
+			[]any{"SET", key, data},
+			[]any{"SADD", namesKey, channame})
 	}
-	ch.SubscribeUser(uid)
-	r, apperr = db.updateAndCommit(key, ch)
 	if apperr != nil {
 		return apperr
 	}
 	if r == nil {
-		// Somebody has created the channel.  Retry.
-		return db.AddUserIDToChannel(appname, channame, uid)
+		// Somebody has modified the channel.  Retry.
+		return db.modifyChannel(appname, channame, create, maxChannels, modify)
 	}
 	return nil
 }
 
-// DeleteUserIDFromChannel removes the given uid from the subscribers
-// of the specified channel.
-func (db *DB) DeleteUserIDFromChannel(appname string, channame string, uid int) error {
-	key := appname + "/channels/" + channame
-	var ch Channel
+// Returned from modifyChannel when the channel doesn't exist.
+var errNoChannel = appErr(404, "No such channel")
 
-	r, apperr := db.watchAndGet(key)
-	if apperr != nil {
-		return apperr
-	}
-	if r == nil {
-		db.unwatch()
+// AddUserIDToChannel adds UID to the list of subscribers in the specified
+// channel.  If maxChannels is positive, a new channel can't be created
+// beyond it.
+func (db *DB) AddUserIDToChannel(appname string, channame string, uid int, maxChannels int) error {
+	return db.modifyChannel(appname, channame, true, maxChannels, func(ch *Channel) {
+		ch.SubscribeUser(uid)
+	})
+}
+
+// DeleteUserIDFromChannel removes a subscription of the given uid from
+// the specified channel.  The channel is deleted when the last
+// subscription is removed.
+func (db *DB) DeleteUserIDFromChannel(appname string, channame string, uid int) error {
+	apperr := db.modifyChannel(appname, channame, false, 0, func(ch *Channel) {
+		ch.UnsubscribeUser(uid)
+	})
+	if apperr == errNoChannel {
 		return appErr(400,
 			fmt.Sprintf("Attempt to unsubscribe nonexistent channel (application: %s, uid %d, channel: %s)",
 				appname, uid, channame))
 	}
+	return apperr
+}
+
+// DropUserIDFromChannel removes all the subscriptions of the given uid
+// from the specified channel.  The channel is deleted when no
+// subscriptions are left.  It is not an error if the channel doesn't
+// exist.
+func (db *DB) DropUserIDFromChannel(appname string, channame string, uid int) error {
+	apperr := db.modifyChannel(appname, channame, false, 0, func(ch *Channel) {
+		ch.DropUser(uid)
+	})
+	if apperr == errNoChannel {
+		return nil
+	}
+	return apperr
+}
 
-	err := json.Unmarshal(r.([]byte), &ch)
+// PurgeEmptyChannels deletes channels without subscribers in the
+// application, and returns the number of deleted channels.
+// Channels used to remain after the last subscriber left, so the
+// database may contain such channels left by older versions.
+func (db *DB) PurgeEmptyChannels(appname string) (int, error) {
+	c, err := db.getPool()
 	if err != nil {
-		db.unwatch()
-		return wrapErr(500, err)
+		return 0, wrapErr(500, err)
 	}
-	ch.UnsubscribeUser(uid)
-	r, apperr = db.updateAndCommit(key, ch)
+	defer c.Close()
+
+	keys, apperr := db.scanChannelKeys(c, appname)
 	if apperr != nil {
-		return apperr
+		return 0, apperr
 	}
-	if r == nil {
-		// Somebody has created the channel.  Retry.
-		return db.DeleteUserIDFromChannel(appname, channame, uid)
+
+	purged := 0
+	for _, key := range keys {
+		r, apperr := db.watchAndGet(c, key)
+		if apperr != nil {
+			return purged, apperr
+		}
+		if r == nil {
+			db.unwatch(c)
+			continue
+		}
+		var ch Channel
+		err := json.Unmarshal(r.([]byte), &ch)
+		if err != nil {
+			db.unwatch(c)
+			return purged, wrapErr(500, err)
+		}
+		if ch.UserCount() > 0 {
+			db.unwatch(c)
+			continue
+		}
+		r, apperr = db.commit(c,
+			[]any{"DEL", key},
+			[]any{"SREM", appname + "/channel-names", ch.Name})
+		if apperr != nil {
+			return purged, apperr
+		}
+		if r != nil {
+			purged++
+		}
+		// If r is nil, somebody has subscribed the channel meanwhile.
 	}
-	return nil
+	return purged, nil
 }
 
 // Returns an unique nonnegative UID in the application.
-func (db *DB) allocateUserID(appname string) (int, error) {
+// If maxConnections is positive, no more UIDs are allocated beyond it.
+func (db *DB) allocateUserID(appname string, maxConnections int) (int, error) {
+	c, err := db.getPool()
+	if err != nil {
+		return -1, wrapErr(500, err)
+	}
+	defer c.Close()
+
 	key := appname + "/users"
 
-	r, apperr := db.watchAndGet(key)
+	r, apperr := db.watchAndGet(c, key)
 	if apperr != nil {
 		return -1, apperr
 	}
@@ -466,9 +665,13 @@ func (db *DB) allocateUserID(appname string) (int, error) {
 	} else {
 		err := json.Unmarshal(r.([]byte), &uids)
 		if err != nil {
-			db.unwatch()
+			db.unwatch(c)
 			return -1, wrapErr(500, err)
 		}
+		if maxConnections > 0 && len(uids.UIDs) >= maxConnections {
+			db.unwatch(c)
+			return -1, overQuotaErr("Application is over connection quota")
+		}
 		maxid := 0
 		for _, u := range uids.UIDs {
 			if u > maxid {
@@ -492,13 +695,13 @@ func (db *DB) allocateUserID(appname string) (int, error) {
 		uids.UIDs = append(uids.UIDs, uid)
 	}
 
-	r, apperr = db.updateAndCommit(key, uids)
+	r, apperr = db.updateAndCommit(c, key, uids)
 	if apperr != nil {
 		return -1, apperr
 	}
 	if r == nil {
 		// conflict.  retry.
-		return db.allocateUserID(appname)
+		return db.allocateUserID(appname, maxConnections)
 	}
 	return uid, nil
 }
@@ -506,27 +709,34 @@ func (db *DB) allocateUserID(appname string) (int, error) {
 // DeleteUserID deletes the given user id.  Note: The user must have been
 // unsubscribed from all the channels.  Supervisor.RemoveUser takes care of that.
 func (db *DB) DeleteUserID(appname string, uid int) error {
+	c, err := db.getPool()
+	if err != nil {
+		return wrapErr(500, err)
+	}
+	defer c.Close()
+
 	key := appname + "/users"
 
-	r, apperr := db.watchAndGet(key)
+	r, apperr := db.watchAndGet(c, key)
 	if apperr != nil {
 		return apperr
 	}
 	if r == nil {
+		db.unwatch(c)
 		return nil
 	}
 
 	var uids UIDArray
-	err := json.Unmarshal(r.([]byte), &uids)
+	err = json.Unmarshal(r.([]byte), &uids)
 	if err != nil {
-		db.unwatch()
+		db.unwatch(c)
 		return wrapErr(500, err)
 	}
 
 	for idx, u := range uids.UIDs {
 		if u == uid {
 			uids.UIDs = append(uids.UIDs[:idx], uids.UIDs[idx+1:]...)
-			r, apperr = db.updateAndCommit(key, uids)
+			r, apperr = db.updateAndCommit(c, key, uids)
 			if apperr != nil {
 				return apperr
 			}
@@ -538,11 +748,227 @@ func (db *DB) DeleteUserID(appname string, uid int) error {
 		}
 	}
 
-	db.unwatch()
+	db.unwatch(c)
 	// no such uid; we don't complain.
 	return nil
 }
 
+// Registers the connection (ARGV[3]) to the node (KEYS[2]), and
+// increments the connection count of ip (ARGV[1], may be empty) in
+// KEYS[1], unless it would exceed max (ARGV[2], when positive).
+// Returns the new count, or -1 if over quota.
+var addConnectionScript = redis.NewScript(2, `
+local n = 0
+if ARGV[1] ~= "" then
+  n = redis.call("HINCRBY", KEYS[1], ARGV[1], 1)
+  local max = tonumber(ARGV[2])
+  if max > 0 and n > max then
+    redis.call("HINCRBY", KEYS[1], ARGV[1], -1)
+    return -1
+  end
+end
+redis.call("HSET", KEYS[2], ARGV[3], ARGV[1])
+return n
+`)
+
+// Unregisters the connection (ARGV[1]) from the node (KEYS[2]), and
+// decrements the connection count of its ip in KEYS[1], removing the
+// field when it drops to zero.  Returns 0 if the connection isn't
+// registered, e.g. it has been cleaned up, or 1 otherwise.
+var removeConnectionScript = redis.NewScript(2, `
+local ip = redis.call("HGET", KEYS[2], ARGV[1])
+if not ip then
+  return 0
+end
+redis.call("HDEL", KEYS[2], ARGV[1])
+if ip ~= "" then
+  local n = redis.call("HINCRBY", KEYS[1], ip, -1)
+  if n <= 0 then
+    redis.call("HDEL", KEYS[1], ip)
+  end
+end
+return 1
+`)
+
+func nodeConnectionsKey(nodeID string) string {
+	return "nodes/" + nodeID + "/connections"
+}
+
+// Registers the connection of uid from the remote ip, which may be
+// empty, to the node.  If maxPerIP is positive and the ip already has
+// that many connections, returns an over quota error.
+func (db *DB) addConnection(appname string, nodeID string, uid int, ip string, maxPerIP int) error {
+	c, err := db.getPool()
+	if err != nil {
+		return wrapErr(500, err)
+	}
+	defer c.Close()
+
+	n, err := redis.Int(addConnectionScript.Do(c, appname+"/connections-per-ip",
+		nodeConnectionsKey(nodeID), ip, maxPerIP, appname+"/"+strconv.Itoa(uid)))
+	if err != nil {
+		return wrapErr(500, err)
+	}
+	if n < 0 {
+		return overQuotaErr("Too many connections from " + ip)
+	}
+	return nil
+}
+
+// Unregisters the connection of uid from the node.  Returns false if it
+// isn't registered.
+func (db *DB) removeConnection(appname string, nodeID string, uid int) (bool, error) {
+	c, err := db.getPool()
+	if err != nil {
+		return false, wrapErr(500, err)
+	}
+	defer c.Close()
+
+	n, err := redis.Int(removeConnectionScript.Do(c, appname+"/connections-per-ip",
+		nodeConnectionsKey(nodeID), appname+"/"+strconv.Itoa(uid)))
+	if err != nil {
+		return false, wrapErr(500, err)
+	}
+	return n == 1, nil
+}
+
+// nodeConnection is a connection registered to a node.
+type nodeConnection struct {
+	App string
+	UID int
+}
+
+// GetNodeConnections returns the connections registered to the node.
+func (db *DB) GetNodeConnections(nodeID string) ([]nodeConnection, error) {
+	c, err := db.getPool()
+	if err != nil {
+		return nil, wrapErr(500, err)
+	}
+	defer c.Close()
+
+	fields, err := redis.Strings(c.Do("HKEYS", nodeConnectionsKey(nodeID)))
+	if err != nil {
+		return nil, wrapErr(500, err)
+	}
+	conns := make([]nodeConnection, 0, len(fields))
+	for _, f := range fields {
+		i := strings.LastIndex(f, "/")
+		uid, err := strconv.Atoi(f[i+1:])
+		if i < 0 || err != nil {
+			continue
+		}
+		conns = append(conns, nodeConnection{App: f[:i], UID: uid})
+	}
+	return conns, nil
+}
+
+// Takes a token from the bucket at KEYS[1], with rate tokens per second
+// (ARGV[1]) up to burst (ARGV[2]) at time now (ARGV[3], milliseconds).
+// Returns 0 on success, or milliseconds to wait until a token is
+// available.
+var takeTokenScript = redis.NewScript(1, `
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+local b = redis.call("HMGET", KEYS[1], "tokens", "ts")
+local tokens = tonumber(b[1]) or burst
+local ts = tonumber(b[2]) or now
+if now > ts then
+  tokens = math.min(burst, tokens + (now - ts) * rate / 1000)
+  ts = now
+end
+local wait = 0
+if tokens >= 1 then
+  tokens = tokens - 1
+else
+  wait = math.ceil((1 - tokens) * 1000 / rate)
+end
+redis.call("HMSET", KEYS[1], "tokens", tostring(tokens), "ts", ts)
+redis.call("PEXPIRE", KEYS[1], math.ceil(burst * 1000 / rate) + 1000)
+return wait
+`)
+
+// takeToken takes a token from the bucket shared among the cluster.
+// Returns 0 on success, or the duration to wait until a token is
+// available.
+func (db *DB) takeToken(key string, rate float64, burst int) (time.Duration, error) {
+	c, err := db.getPool()
+	if err != nil {
+		return 0, wrapErr(500, err)
+	}
+	defer c.Close()
+
+	now := time.Now().UnixNano() / int64(time.Millisecond)
+	wait, err := redis.Int64(takeTokenScript.Do(c, key, rate, burst, now))
+	if err != nil {
+		return 0, wrapErr(500, err)
+	}
+	return time.Duration(wait) * time.Millisecond, nil
+}
+
+// Claims the notification of the subscription count ARGV[1] of the
+// channel at KEYS[1] at time now (ARGV[2], milliseconds), with the
+// interval (ARGV[3], milliseconds).  Returns 0 if the caller should
+// notify, -1 if the count has been notified, or milliseconds to wait
+// until the interval since the last notification ends.
+var claimSubscriptionCountScript = redis.NewScript(1, `
+local count = tonumber(ARGV[1])
+local now = tonumber(ARGV[2])
+local interval = tonumber(ARGV[3])
+local b = redis.call("HMGET", KEYS[1], "count", "next")
+local last = tonumber(b[1]) or 0
+local nextAt = tonumber(b[2]) or 0
+if now < nextAt then
+  return nextAt - now
+end
+if count == last then
+  return -1
+end
+redis.call("HMSET", KEYS[1], "count", count, "next", now + interval)
+if count == 0 then
+  redis.call("PEXPIRE", KEYS[1], interval)
+else
+  redis.call("PERSIST", KEYS[1])
+end
+return 0
+`)
+
+// claimSubscriptionCount decides whether this node notifies the
+// subscription count of the channel.  Returns 0 if it should, negative
+// if the count has already been notified, or the duration to wait
+// before claiming again.
+func (db *DB) claimSubscriptionCount(appname string, channame string,
+	count int, interval time.Duration) (time.Duration, error) {
+	c, err := db.getPool()
+	if err != nil {
+		return 0, wrapErr(500, err)
+	}
+	defer c.Close()
+
+	now := time.Now().UnixNano() / int64(time.Millisecond)
+	wait, err := redis.Int64(claimSubscriptionCountScript.Do(c,
+		appname+"/subscription-count/"+channame, count, now, interval.Milliseconds()))
+	if err != nil {
+		return 0, wrapErr(500, err)
+	}
+	return time.Duration(wait) * time.Millisecond, nil
+}
+
+// CountChannels returns the number of channels in the given app.
+func (db *DB) CountChannels(appname string) (int, error) {
+	c, err := db.getPool()
+	if err != nil {
+		return 0, wrapErr(500, err)
+	}
+	defer c.Close()
+
+	n, err := redis.Int(c.Do("SCARD", appname+"/channel-names"))
+	if err != nil {
+		return 0, wrapErr(500, err)
+	}
+	return n, nil
+}
+
 // GetAllUserIDs returns all user IDs in the given app.
 func (db *DB) GetAllUserIDs(appname string) ([]int, error) {
 	c, err := db.getPool()
@@ -570,6 +996,142 @@ func (db *DB) GetAllUserIDs(appname string) ([]int, error) {
 	return uids.UIDs, nil
 }
 
+// GetApplications returns the applications registered via admin API.
+func (db *DB) GetApplications() ([]ConfigApplication, error) {
+	c, err := db.getPool()
+	if err != nil {
+		return nil, wrapErr(500, err)
+	}
+	defer c.Close()
+
+	values, err := redis.ByteSlices(c.Do("HVALS", "applications"))
+	if err != nil {
+		return nil, wrapErr(500, err)
+	}
+	apps := make([]ConfigApplication, 0, len(values))
+	for _, v := range values {
+		var ca ConfigApplication
+		err = json.Unmarshal(v, &ca)
+		if err != nil {
+			return nil, wrapErr(500, err)
+		}
+		apps = append(apps, ca)
+	}
+	return apps, nil
+}
+
+// PutApplication registers the application, and notifies the other
+// processes.  If create is true and the application already exists,
+// returns 409 error.
+func (db *DB) PutApplication(ca *ConfigApplication, create bool) error {
+	data, err := json.Marshal(ca)
+	if err != nil {
+		return wrapErr(500, err)
+	}
+	c, err := db.getPool()
+	if err != nil {
+		return wrapErr(500, err)
+	}
+	defer c.Close()
+
+	if create {
+		ok, err := redis.Bool(c.Do("HSETNX", "applications", ca.Name, data))
+		if err != nil {
+			return wrapErr(500, err)
+		}
+		if !ok {
+			return appErr(409, "Application already exists")
+		}
+	} else {
+		_, err = c.Do("HSET", "applications", ca.Name, data)
+		if err != nil {
+			return wrapErr(500, err)
+		}
+	}
+	_, err = c.Do("PUBLISH", "applications", ca.Name)
+	if err != nil {
+		return wrapErr(500, err)
+	}
+	return nil
+}
+
+// DeleteApplication unregisters the application, and notifies the
+// other processes.
+func (db *DB) DeleteApplication(appname string) error {
+	c, err := db.getPool()
+	if err != nil {
+		return wrapErr(500, err)
+	}
+	defer c.Close()
+
+	n, err := redis.Int(c.Do("HDEL", "applications", appname))
+	if err != nil {
+		return wrapErr(500, err)
+	}
+	if n == 0 {
+		return appErr(404, "No such application")
+	}
+	_, err = c.Do("PUBLISH", "applications", appname)
+	if err != nil {
+		return wrapErr(500, err)
+	}
+	return nil
+}
+
+// PutNode updates the status of the process.
+func (db *DB) PutNode(nodeID string, status any) error {
+	data, err := json.Marshal(status)
+	if err != nil {
+		return wrapErr(500, err)
+	}
+	c, err := db.getPool()
+	if err != nil {
+		return wrapErr(500, err)
+	}
+	defer c.Close()
+
+	_, err = c.Do("HSET", "nodes", nodeID, data)
+	if err != nil {
+		return wrapErr(500, err)
+	}
+	return nil
+}
+
+// GetNodes returns the statuses of the processes, encoded in JSON.
+func (db *DB) GetNodes() (map[string][]byte, error) {
+	c, err := db.getPool()
+	if err != nil {
+		return nil, wrapErr(500, err)
+	}
+	defer c.Close()
+
+	values, err := redis.StringMap(c.Do("HGETALL", "nodes"))
+	if err != nil {
+		return nil, wrapErr(500, err)
+	}
+	nodes := make(map[string][]byte, len(values))
+	for id, v := range values {
+		nodes[id] = []byte(v)
+	}
+	return nodes, nil
+}
+
+// DeleteNode removes the status of the process.  Returns false if
+// it has been removed already, e.g. by another process.
+func (db *DB) DeleteNode(nodeID string) (bool, error) {
+	c, err := db.getPool()
+	if err != nil {
+		return false, wrapErr(500, err)
+	}
+	defer c.Close()
+
+	n, err := redis.Int(c.Do("HDEL", "nodes", nodeID))
+	if err != nil {
+		return false, wrapErr(500, err)
+	}
+	return n == 1, nil
+}
+
 //
 // Redis push event handling
 //
@@ -583,7 +1145,7 @@ func (s *Supervisor) redisSubscriberLoop() error {
 	defer c.Close()
 
 	psc := redis.PubSubConn{Conn: c}
-	err = psc.Subscribe("events")
+	err = psc.Subscribe("events", "applications")
 	if err != nil {
 		s.logger.Errorw("PubSubConn Subscribe failed", "error", err)
 		return err
@@ -595,17 +1157,43 @@ func (s *Supervisor) redisSubscriberLoop() error {
 		}
 	}()
 
+	defer s.subscribed.Store(false)
+
+	err = s.debug.setPubSub(&psc)
+	if err != nil {
+		s.logger.Errorw("PubSubConn Subscribe failed", "error", err)
+		return err
+	}
+	defer func() { _ = s.debug.setPubSub(nil) }()
+
 	for {
 		switch v := psc.Receive().(type) {
+		case redis.Subscription:
+			if v.Channel == "events" {
+				s.subscribed.Store(v.Kind == "subscribe")
+			}
+			// (Re)subscribed; catch up the changes while we were away.
+			if v.Channel == "applications" && v.Kind == "subscribe" {
+				s.refreshDynamicApps()
+			}
 		case redis.Message:
+			if v.Channel == "applications" {
+				s.refreshDynamicApps()
+				break
+			}
+			if strings.HasSuffix(v.Channel, "/debug") {
+				s.handleRedisDebugEvent(v.Data)
+				break
+			}
 			var er EventRequest
 			err := json.Unmarshal(v.Data, &er)
 			if err != nil {
-				s.logger.Errorw("redis message decoding error", "error", err, "message", v.Data)
+				s.logger.Errorw("redis message decoding error", "error", err, "size", len(v.Data))
 			} else {
 				apperr := s.handleRedisEventRequest(&er)
 				if apperr != nil {
-					s.logger.Errorw("redis message handle error", "appError", apperr, "message", er)
+					s.logger.Errorw("redis message handle error", "appError", apperr,
+						"app", er.Application, "channel", er.Channel, "event", er.Name)
 				}
 			}
 		case error:
@@ -621,14 +1209,20 @@ func (s *Supervisor) redisSubscriberLoopWithRetry() {
 		if strings.Contains(err.Error(), "use of closed network") {
 			return
 		}
+		redisReconnects.Inc()
 
 		time.Sleep(5 * time.Second)
 	}
 }
 
-func (s *Supervisor) handleRedisEventRequest(er *EventRequest) error {
-	if s.db.eventCallback != nil {
-		if !s.db.eventCallback(er) {
+func (s *Supervisor) handleRedisEventRequest(er *EventRequest) (err error) {
+	ctx, span := tracer().Start(er.extractTrace(context.Background()), "redis event",
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(spanApp(er.Application), spanChannel(er.Channel)))
+	defer func() { endSpan(span, err) }()
+
+	if cb := s.db.eventCallback.Load(); cb != nil {
+		if !(*cb)(er) {
 			return nil
 		}
 	}
@@ -636,8 +1230,27 @@ func (s *Supervisor) handleRedisEventRequest(er *EventRequest) error {
 	if apperr != nil {
 		return apperr
 	}
-	ev := Event{Name: er.Name, Data: er.Data}
-	return s.realBroadcast(a, &ev, er.Channel)
+	ev := Event{Name: er.Name, Data: er.Data, Exclude: er.Exclude}
+	return s.realBroadcast(ctx, a, &ev, er.Channel)
+}
+
+// PurgeEmptyChannels deletes channels without subscribers of all the
+// applications from Redis, and returns the number of deleted channels.
+// Only meaningful in distributed mode.
+func (s *Supervisor) PurgeEmptyChannels() (int, error) {
+	if s.db == nil {
+		return 0, appErr(400, "Not running in distributed mode")
+	}
+	total := 0
+	for _, a := range s.apps() {
+		n, apperr := s.db.PurgeEmptyChannels(a.Name)
+		total += n
+		if apperr != nil {
+			return total, apperr
+		}
+		s.logger.Infow("purged empty channels", "app", a.Name, "count", n)
+	}
+	return total, nil
 }
 
 // KickRedisSubscription starts goroutine to handle Redis events.
@@ -647,14 +1260,16 @@ func (s *Supervisor) KickRedisSubscription() {
 	}
 }
 
-// PublishRedisEvent pushes Redis events.
-func (s *Supervisor) PublishRedisEvent(ev *EventRequest) error {
+// PublishRedisEvent pushes Redis events.  The trace of ctx is continued
+// by the receivers.
+func (s *Supervisor) PublishRedisEvent(ctx context.Context, ev *EventRequest) error {
 	if s.db != nil {
+		ev.injectTrace(ctx)
 		data, err := json.Marshal(ev)
 		if err != nil {
 			return wrapErr(500, err)
 		}
-		c, err := s.db.getPool()
+		c, err := s.db.getPoolContext(ctx)
 		if err != nil {
 			return wrapErr(500, err)
 		}
@@ -0,0 +1,41 @@
+//go:build redis_test || redis_sentinel_test
+
+package notifier
+
+import (
+	"os/exec"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Tests stopping Redis are executed with Redis installed by Homebrew.
+
+// restartTestRedis starts the test with a fresh server, or skips it
+// without Homebrew.
+func restartTestRedis(t *testing.T) {
+	if err := exec.Command("bash", "-c", "brew list | grep redis").Run(); err != nil {
+		t.Skip("Redis isn't installed by Homebrew")
+	}
+	err := exec.Command("bash", "-c", "brew services restart redis").Run()
+	require.NoError(t, err)
+	time.Sleep(500 * time.Millisecond)
+}
+
+func stopTestRedis(t *testing.T) {
+	err := exec.Command("bash", "-c", "brew services stop redis").Run()
+	require.NoError(t, err)
+	time.Sleep(500 * time.Millisecond)
+}
+
+func startTestRedis(t *testing.T) {
+	err := exec.Command("bash", "-c", "brew services start redis").Run()
+	require.NoError(t, err)
+}
+
+// hangTestRedis needs miniredis.
+func hangTestRedis(t *testing.T, command string) func() {
+	t.Skip("hanging Redis requires miniredis")
+	return nil
+}
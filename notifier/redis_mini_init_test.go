@@ -0,0 +1,81 @@
+//go:build !redis_test && !redis_sentinel_test
+
+package notifier
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/alicebob/miniredis/v2/server"
+	"github.com/stretchr/testify/require"
+)
+
+// Without redis_test nor redis_sentinel_test tags, the tests in
+// distributed mode run against miniredis, a Redis server in the test
+// process.  The Supervisors made in a test share the server, as the
+// processes of a cluster share Redis.
+
+var (
+	miniRedisMu sync.Mutex
+	miniRedises = make(map[*testing.T]*miniredis.Miniredis)
+)
+
+// testMiniRedis returns the server of the test, starting it if not yet.
+func testMiniRedis(t *testing.T) *miniredis.Miniredis {
+	miniRedisMu.Lock()
+	defer miniRedisMu.Unlock()
+	if mr, ok := miniRedises[t]; ok {
+		return mr
+	}
+	mr := miniredis.RunT(t)
+	miniRedises[t] = mr
+	t.Cleanup(func() {
+		miniRedisMu.Lock()
+		defer miniRedisMu.Unlock()
+		delete(miniRedises, t)
+	})
+	return mr
+}
+
+func initRedisTest(t *testing.T) *Supervisor {
+	path := "../config/sample-redis-test.json"
+	config, err := ReadConfigFile(path)
+	require.Nil(t, err)
+	config.Redis.Address = testMiniRedis(t).Addr()
+
+	s := NewSupervisor(config)
+	s.db.FlushDB()
+	return s
+}
+
+// restartTestRedis starts the test with a fresh server.
+func restartTestRedis(t *testing.T) {
+	testMiniRedis(t)
+}
+
+// stopTestRedis stops the server, closing the connections.
+func stopTestRedis(t *testing.T) {
+	testMiniRedis(t).Close()
+}
+
+// startTestRedis starts the server stopped by stopTestRedis.
+func startTestRedis(t *testing.T) {
+	require.Nil(t, testMiniRedis(t).Restart())
+}
+
+// hangTestRedis makes the server hold the command without responding
+// until the returned function is called.
+func hangTestRedis(t *testing.T, command string) func() {
+	release := make(chan struct{})
+	testMiniRedis(t).Server().SetPreHook(func(c *server.Peer, cmd string, args ...string) bool {
+		if cmd == command {
+			<-release
+		}
+		return false
+	})
+	var once sync.Once
+	return func() {
+		once.Do(func() { close(release) })
+	}
+}
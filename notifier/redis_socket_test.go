@@ -1,205 +1,114 @@
-//go:build chrome_test && redis_test
-
 package notifier
 
 import (
-	"context"
-	"fmt"
 	"net/http"
 	"net/http/httptest"
-	"regexp"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
-	"github.com/chromedp/cdproto"
-	"github.com/chromedp/cdproto/page"
-	"github.com/chromedp/chromedp"
-	"github.com/gorilla/mux"
-	pusher "github.com/pusher/pusher-http-go"
+	"github.com/sony/micro-notifier/notifier/pushertest"
 	"github.com/stretchr/testify/require"
 )
 
-func spawnServersRedis(t *testing.T, contentProvider TestContentProvider) *TestServers {
-	// Start notifier server
-	s := initTest(t, RedisConfig)
-	router := newRouter(s)
-
-	var notifier *httptest.Server
-	var client *http.Client
-	notifier = httptest.NewServer(router)
-	client = &http.Client{}
-
-	// Wait for server to spin up
-	var ok = false
-	for i := 0; i < 10; i++ {
-		_, err := client.Get(notifier.URL + "/apps")
-		if err == nil {
-			ok = true
-			break
-		}
-		time.Sleep(50 * time.Millisecond)
-	}
-	require.True(t, ok)
-
-	ts := TestServers{Notifier: notifier}
-
-	// Content server
-	ts.testContent = contentProvider(&ts, false)
-
-	tsrouter := mux.NewRouter()
-	tsrouter.HandleFunc("/", ts.serveContent).Methods("GET")
-	tsrouter.HandleFunc("/auth", ts.signerHandler).Methods("POST")
-	ts.Content = httptest.NewServer(tsrouter)
-
-	var httpClient *http.Client
-	ts.PusherClient = &pusher.Client{
-		AppID:      "testapp",
-		Key:        "1234567890",
-		Secret:     "abcdefghij",
-		Host:       regexp.MustCompile("^http.*://").ReplaceAllString(ts.Notifier.URL, ""),
-		Secure:     false,
-		HTTPClient: httpClient,
-	}
-
-	return &ts
-}
-
 func TestWebsocketConnectionRedis(t *testing.T) {
-	ts := spawnServersRedis(t, websocketConnectionContent)
+	ts := spawnServers(t, RedisConfig)
 	defer ts.Close()
-	client := ts.PusherClient
-
-	users, err := client.GetChannelUsers("my-channel")
-	require.Nil(t, err)
-	require.Equal(t, 0, len(users.List))
-
-	opts := append(chromedp.DefaultExecAllocatorOptions[:],
-		chromedp.Flag("allow-insecure-localhost", true),
-	)
-
-	allocCtx, cancel := chromedp.NewExecAllocator(context.Background(), opts...)
-	defer cancel()
-
-	ctx, cancel := chromedp.NewContext(allocCtx)
-	defer cancel()
-
-	chromedp.ListenTarget(ctx, func(ev interface{}) {
-		if ev, ok := ev.(*page.EventJavascriptDialogOpening); ok {
-			fmt.Println("closing alert:", ev.Message)
-			go func() {
-				if err := chromedp.Run(ctx,
-					page.HandleJavaScriptDialog(true),
-				); err != nil {
-					panic(err)
-				}
-			}()
-		}
-		if msg, ok := ev.(*cdproto.Message); ok {
-			fmt.Println("message: ", string(msg.Result))
-		}
-	})
-
-	err = chromedp.Run(ctx,
-		chromedp.Navigate(ts.Content.URL),
-	)
-	require.Nil(t, err)
-
-	// Wait for subscription
-	var ok = false
-	for i := 0; i < 10; i++ {
-		time.Sleep(50 * time.Millisecond)
-		users, err = client.GetChannelUsers("my-channel")
-		require.Nil(t, err)
-		if len(users.List) == 1 {
-			ok = true
-			break
-		}
-	}
-	require.True(t, ok)
+	testWebsocketConnection(t, ts)
+}
 
-	data := map[string]string{"message": "knock, knock"}
-	client.Trigger("my-channel", "my-event", data)
+func TestWebsocketSignatureRedis(t *testing.T) {
+	ts := spawnServers(t, RedisConfig)
+	defer ts.Close()
+	testWebsocketSignature(t, ts)
+}
 
-	var res []string
-	for i := 0; i < 10; i++ {
-		time.Sleep(50 * time.Millisecond)
-		err = chromedp.Run(ctx,
-			chromedp.Evaluate("events", &res),
-		)
-		require.Nil(t, err)
-		if len(res) > 0 {
-			break
+// Events triggered on a node reach the subscribers on the others, and
+// the channels are shared by the nodes.
+func TestWebsocketMultiNodeRedis(t *testing.T) {
+	ts1 := spawnServers(t, RedisConfig)
+	defer ts1.Close()
+	ts2 := spawnServers(t, RedisConfig)
+	defer ts2.Close()
+	require.Eventually(t, func() bool {
+		return ts1.Supervisor.subscribed.Load() && ts2.Supervisor.subscribed.Load()
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// Subscribed concurrently on both nodes; conflicting transactions
+	// are retried.
+	const numClients = 10
+	clients := make([]*pushertest.Client, numClients)
+	var wg sync.WaitGroup
+	for i := range clients {
+		ts := ts1
+		if i%2 == 1 {
+			ts = ts2
 		}
+		clients[i] = ts.Dialer.Dial(t, ts.Notifier.URL)
+		defer clients[i].Close()
+		wg.Add(1)
+		go func(c *pushertest.Client) {
+			defer wg.Done()
+			c.Subscribe("private-my-channel")
+		}(clients[i])
 	}
-	require.Equal(t, 1, len(res))
-	require.Equal(t, "knock, knock", res[0])
+	wg.Wait()
 
-	data = map[string]string{"message": "who's there?"}
-	client.Trigger("my-channel", "my-event", data)
+	users, err := ts2.PusherClient.GetChannelUsers("private-my-channel")
+	require.Nil(t, err)
+	require.Equal(t, numClients, len(users.List))
 
-	for i := 0; i < 10; i++ {
-		time.Sleep(50 * time.Millisecond)
-		err = chromedp.Run(ctx,
-			chromedp.Evaluate("events", &res),
-		)
-		require.Nil(t, err)
-		if len(res) > 1 {
-			break
-		}
+	err = ts2.PusherClient.Trigger("private-my-channel", "my-event", map[string]string{"message": "hello"})
+	require.Nil(t, err)
+	for _, c := range clients {
+		ev := c.ExpectEvent("private-my-channel", "my-event")
+		require.JSONEq(t, `{"message":"hello"}`, ev.Data)
 	}
-	require.Equal(t, 2, len(res))
-	require.Equal(t, "who's there?", res[1])
 }
 
-func TestWebsocketSignatureRedis(t *testing.T) {
-	ts := spawnServersRedis(t, websocketSignatureContent)
-	defer ts.Close()
-	client := ts.PusherClient
-
-	users, err := client.GetChannelUsers("private-my-channel")
-	require.Nil(t, err)
-	require.Equal(t, 0, len(users.List))
-
-	opts := append(chromedp.DefaultExecAllocatorOptions[:],
-		chromedp.Flag("allow-insecure-localhost", true),
-	)
-
-	allocCtx, cancel := chromedp.NewExecAllocator(context.Background(), opts...)
-	defer cancel()
-
-	//	ctx, cancel := chromedp.NewContext(context.Background(),
-	//		chromedp.WithBrowserOption(chromedp.WithBrowserDebugf(log.Printf)))
-	ctx, cancel := chromedp.NewContext(allocCtx)
-	defer cancel()
-
-	chromedp.ListenTarget(ctx, func(ev interface{}) {
-		if ev, ok := ev.(*page.EventJavascriptDialogOpening); ok {
-			fmt.Println("closing alert:", ev.Message)
-			go func() {
-				if err := chromedp.Run(ctx,
-					page.HandleJavaScriptDialog(true),
-				); err != nil {
-					panic(err)
-				}
-			}()
+// The subscription count changed on several nodes is notified once.
+func TestSubscriptionCountMultiNodeRedis(t *testing.T) {
+	var hooks atomic.Int32
+	hookServer := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			hooks.Add(1)
+		}))
+	defer hookServer.Close()
+
+	ts1 := spawnServers(t, RedisConfig)
+	defer ts1.Close()
+	ts2 := spawnServers(t, RedisConfig)
+	defer ts2.Close()
+	for _, ts := range []*TestServers{ts1, ts2} {
+		config := *ts.Supervisor.fileConfig
+		config.Applications = append([]ConfigApplication{}, config.Applications...)
+		config.Applications[0].SubscriptionCount = ConfigSubscriptionCount{
+			Enabled:    true,
+			Interval:   500,
+			WebhookURL: hookServer.URL,
 		}
-	})
+		ts.Supervisor.Reload(&config)
+	}
 
-	err = chromedp.Run(ctx,
-		chromedp.Navigate(ts.Content.URL),
-	)
-	require.Nil(t, err)
+	c1 := ts1.Dialer.Dial(t, ts1.Notifier.URL)
+	defer c1.Close()
+	c1.Subscribe("my-channel")
+	c2 := ts2.Dialer.Dial(t, ts2.Notifier.URL)
+	defer c2.Close()
+	c2.Subscribe("my-channel")
 
-	// Wait for subscription
-	var ok = false
-	for i := 0; i < 10; i++ {
-		time.Sleep(50 * time.Millisecond)
-		users, err = client.GetChannelUsers("private-my-channel")
-		require.Nil(t, err)
-		if len(users.List) == 1 {
-			ok = true
-			break
-		}
+	for _, c := range []*pushertest.Client{c1, c2} {
+		ev := c.ExpectEvent("my-channel", "pusher_internal:subscription_count")
+		require.JSONEq(t, `{"subscription_count":2}`, ev.Data)
 	}
-	require.True(t, ok)
+	c1.ExpectNothing(time.Second)
+	c2.ExpectNothing(0)
+	require.Equal(t, int32(1), hooks.Load())
+
+	c2.Unsubscribe("my-channel")
+	ev := c1.ExpectEvent("my-channel", "pusher_internal:subscription_count")
+	require.JSONEq(t, `{"subscription_count":1}`, ev.Data)
+	c1.ExpectNothing(time.Second)
+	require.Equal(t, int32(2), hooks.Load())
 }
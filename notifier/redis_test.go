@@ -1,10 +1,10 @@
-//go:build redis_test || redis_sentinel_test
-
 package notifier
 
 import (
+	"context"
 	"net/http"
-	"os/exec"
+	"net/http/httptest"
+	"net/url"
 	"testing"
 	"time"
 
@@ -21,41 +21,167 @@ func TestRedisListChannels(t *testing.T) {
 	require.Equal(t, J(`{"channels":{}}`), jsonBody(t, rr))
 }
 
-func TestRedisCreateAndGetChannel(t *testing.T) {
+func TestRedisGetChannelDoesNotCreate(t *testing.T) {
 	s := initRedisTest(t)
 	router := newRouter(s)
 	defer s.Finish()
 
 	rr := doRequest(t, router, "GET", "/apps/testapp/channels/chan0", "",
 		http.StatusOK)
-	require.Equal(t, J(`{}`), jsonBody(t, rr))
+	require.Equal(t, J(`{"occupied": false}`), jsonBody(t, rr))
 
 	rr = doRequest(t, router, "GET", "/apps/testapp/channels/chan1", "",
 		http.StatusOK)
-	require.Equal(t, J(`{}`), jsonBody(t, rr))
+	require.Equal(t, J(`{"occupied": false}`), jsonBody(t, rr))
+
+	_ = doRequest(t, router, "GET", "/apps/testapp/channels/chan1/users", "",
+		http.StatusOK)
 
 	rr = doRequest(t, router, "GET", "/apps/testapp/channels", "",
 		http.StatusOK)
-	require.Equal(t, J(`{`+
-		`"channels":{`+
-		`  "chan0":{"user_count":0},`+
-		`  "chan1":{"user_count":0}`+
-		`}}`), jsonBody(t, rr))
+	require.Equal(t, J(`{"channels":{}}`), jsonBody(t, rr))
+}
+
+func TestRedisReady(t *testing.T) {
+	s := initRedisTest(t)
+	defer s.Finish()
+	router := newRouter(s)
+
+	// Ready once the event subscription is active
+	require.Eventually(t, func() bool {
+		req := httptest.NewRequest("GET", "/readyz", nil)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr.Code == http.StatusOK
+	}, 5*time.Second, 10*time.Millisecond)
+
+	rr := doRequest(t, router, "GET", "/status", "", http.StatusOK)
+	require.Equal(t, modeDistributed, jsonBody(t, rr).Get("mode").MustString())
+
+	// Not ready soon if Redis doesn't respond
+	release := hangTestRedis(t, "PING")
+	defer release()
+	start := time.Now()
+	rr = doRequest(t, router, "GET", "/readyz", "", http.StatusServiceUnavailable)
+	require.Less(t, time.Since(start), 2*readyTimeout)
+	require.Contains(t, rr.Body.String(), "redis unreachable")
+}
+
+func TestRedisDebugConsole(t *testing.T) {
+	s1 := initRedisTest(t)
+	defer s1.Finish()
+	s2 := initRedisTest(t)
+	defer s2.Finish()
+	server1 := httptest.NewServer(newRouter(s1))
+	defer server1.Close()
+	router2 := newRouter(s2)
+
+	// The activity of the other process is streamed
+	console := dialDebugConsole(t, server1, url.Values{})
+	defer console.Close()
+	received := make(chan debugEvent, 100)
+	go func() {
+		for {
+			var ev debugEvent
+			if console.ReadJSON(&ev) != nil {
+				return
+			}
+			received <- ev
+		}
+	}()
+	// Retried until s1 subscribes the debug channel and s2 notices it
+	require.Eventually(t, func() bool {
+		_ = doRequest(t, router2, "POST", "/apps/testapp/events",
+			`{"name":"ev","channels":["chan0"],"data":"{}"}`, http.StatusOK)
+		select {
+		case ev := <-received:
+			return ev.Type == debugAPIMessage && ev.NodeID == s2.nodeID
+		case <-time.After(100 * time.Millisecond):
+			return false
+		}
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestRedisNodes(t *testing.T) {
+	s1 := initRedisTest(t)
+	defer s1.Finish()
+	s2 := initRedisTest(t)
+
+	// Both processes are listed once s2 puts its status
+	require.Eventually(t, func() bool {
+		nodes, apperr := s1.clusterNodes()
+		require.Nil(t, apperr)
+		return len(nodes) == 2
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// Stale ones are removed
+	require.Nil(t, s1.db.PutNode("stale", nodeRecord{
+		statusResponse{NodeID: "stale"}, time.Now().Add(-2 * nodeExpiry)}))
+	s2.Finish()
+	nodes, apperr := s1.clusterNodes()
+	require.Nil(t, apperr)
+	require.Len(t, nodes, 1)
+	require.Equal(t, s1.nodeID, nodes[0].NodeID)
+	values, apperr := s1.db.GetNodes()
+	require.Nil(t, apperr)
+	require.NotContains(t, values, "stale")
+}
+
+func TestRedisDeadNode(t *testing.T) {
+	s := initRedisTest(t)
+	defer s.Finish()
+	router := newRouter(s)
+
+	// A process died with a connection subscribing a channel
+	dead := "dead-node"
+	uid, apperr := s.db.allocateUserID("testapp", 0)
+	require.Nil(t, apperr)
+	require.Nil(t, s.db.addConnection("testapp", dead, uid, "192.0.2.1", 1))
+	require.Nil(t, s.db.AddUserIDToChannel("testapp", "chan0", uid, 0))
+	require.Nil(t, s.db.PutNode(dead, nodeRecord{
+		statusResponse{NodeID: dead}, time.Now().Add(-2 * nodeExpiry)}))
+	require.NotNil(t, s.db.addConnection("testapp", s.nodeID, uid+1, "192.0.2.1", 1))
+
+	_, apperr = s.clusterNodes()
+	require.Nil(t, apperr)
+	uids, apperr := s.db.GetAllUserIDs("testapp")
+	require.Nil(t, apperr)
+	require.Empty(t, uids)
+	rr := doRequest(t, router, "GET", "/apps/testapp/channels/chan0", "", http.StatusOK)
+	require.Equal(t, J(`{"occupied": false}`), jsonBody(t, rr))
+	require.Nil(t, s.db.addConnection("testapp", s.nodeID, uid+1, "192.0.2.1", 1))
+	conns, apperr := s.db.GetNodeConnections(dead)
+	require.Nil(t, apperr)
+	require.Empty(t, conns)
+
+	// The connections left open are released on Finish
+	s2 := initRedisTest(t)
+	server := httptest.NewServer(newRouter(s2))
+	defer server.Close()
+	conn := dialTestSocket(t, server, "1234567890")
+	defer conn.Close()
+	subscribeTestSocket(t, conn, "chan1")
+	s2.Finish()
+	uids, apperr = s.db.GetAllUserIDs("testapp")
+	require.Nil(t, apperr)
+	require.Empty(t, uids)
+	rr = doRequest(t, router, "GET", "/apps/testapp/channels/chan1", "", http.StatusOK)
+	require.Equal(t, J(`{"occupied": false}`), jsonBody(t, rr))
 }
 
 func TestLowlevelUserIDManager(t *testing.T) {
 	s := initRedisTest(t)
 	defer s.Finish()
 
-	id, apperr := s.db.allocateUserID("testapp")
+	id, apperr := s.db.allocateUserID("testapp", 0)
 	require.Nil(t, apperr)
 	require.Equal(t, 0, id)
 
-	id, apperr = s.db.allocateUserID("testapp")
+	id, apperr = s.db.allocateUserID("testapp", 0)
 	require.Nil(t, apperr)
 	require.Equal(t, 1, id)
 
-	id, apperr = s.db.allocateUserID("testapp")
+	id, apperr = s.db.allocateUserID("testapp", 0)
 	require.Nil(t, apperr)
 	require.Equal(t, 2, id)
 
@@ -79,7 +205,7 @@ func TestLowlevelUserIDManager(t *testing.T) {
 	require.Nil(t, apperr)
 	require.Equal(t, []int{0, 2}, uids)
 
-	id, apperr = s.db.allocateUserID("testapp")
+	id, apperr = s.db.allocateUserID("testapp", 0)
 	require.Nil(t, apperr)
 	require.Equal(t, 1, id)
 
@@ -95,15 +221,15 @@ func TestLowlevelUserIDManager(t *testing.T) {
 	require.Nil(t, apperr)
 	require.Equal(t, []int{1}, uids)
 
-	id, apperr = s.db.allocateUserID("testapp")
+	id, apperr = s.db.allocateUserID("testapp", 0)
 	require.Nil(t, apperr)
 	require.Equal(t, 0, id)
 
-	id, apperr = s.db.allocateUserID("testapp")
+	id, apperr = s.db.allocateUserID("testapp", 0)
 	require.Nil(t, apperr)
 	require.Equal(t, 2, id)
 
-	id, apperr = s.db.allocateUserID("testapp")
+	id, apperr = s.db.allocateUserID("testapp", 0)
 	require.Nil(t, apperr)
 	require.Equal(t, 3, id)
 
@@ -161,18 +287,133 @@ func TestLowlevelSubscriptions(t *testing.T) {
 
 	apperr = s.RemoveUser("testapp", 0)
 	require.Nil(t, apperr)
-	ch, apperr = s.GetChannel("testapp", "chan0")
-	require.Nil(t, apperr)
-	require.Equal(t, map[int]int{}, ch.Users)
+	_, apperr = s.GetChannel("testapp", "chan0")
+	require.NotNil(t, apperr)
 	ch, apperr = s.GetChannel("testapp", "chan1")
 	require.Nil(t, apperr)
 	require.Equal(t, map[int]int{1: 1}, ch.Users)
 
 	apperr = s.Unsubscribe("testapp", 1, "chan1")
 	require.Nil(t, apperr)
-	ch, apperr = s.GetChannel("testapp", "chan1")
+	_, apperr = s.GetChannel("testapp", "chan1")
+	require.NotNil(t, apperr)
+
+	chs, apperr := s.GetChannels("testapp")
 	require.Nil(t, apperr)
-	require.Equal(t, map[int]int{}, ch.Users)
+	require.Equal(t, 0, len(chs))
+}
+
+func TestLowlevelPurgeEmptyChannels(t *testing.T) {
+	s := initRedisTest(t)
+	defer s.Finish()
+
+	_, apperr := s.GetOrCreateChannel("testapp", "chan0")
+	require.Nil(t, apperr)
+	_, apperr = s.GetOrCreateChannel("testapp", "chan1")
+	require.Nil(t, apperr)
+	_, apperr = s.AddUser("testapp", nil)
+	require.Nil(t, apperr)
+	apperr = s.Subscribe("testapp", 0, "chan1")
+	require.Nil(t, apperr)
+
+	n, apperr := s.db.PurgeEmptyChannels("testapp")
+	require.Nil(t, apperr)
+	require.Equal(t, 1, n)
+
+	chs, apperr := s.GetChannels("testapp")
+	require.Nil(t, apperr)
+	require.Equal(t, 1, len(chs))
+	require.Equal(t, map[int]int{0: 1}, chs["chan1"].Users)
+}
+
+func TestLowlevelQuotas(t *testing.T) {
+	s := initRedisTest(t)
+	defer s.Finish()
+
+	id, apperr := s.db.allocateUserID("testapp", 1)
+	require.Nil(t, apperr)
+	require.Equal(t, 0, id)
+	_, apperr = s.db.allocateUserID("testapp", 1)
+	require.NotNil(t, apperr)
+	require.Equal(t, pusherCodeOverQuota, apperr.(*appError).PusherCode)
+
+	require.Nil(t, s.db.addConnection("testapp", s.nodeID, 0, "192.0.2.1", 2))
+	require.Nil(t, s.db.addConnection("testapp", s.nodeID, 1, "192.0.2.1", 2))
+	require.NotNil(t, s.db.addConnection("testapp", s.nodeID, 2, "192.0.2.1", 2))
+	require.Nil(t, s.db.addConnection("testapp", s.nodeID, 2, "192.0.2.2", 2))
+	require.Nil(t, s.db.addConnection("testapp", s.nodeID, 3, "", 2))
+	registered, apperr := s.db.removeConnection("testapp", s.nodeID, 0)
+	require.Nil(t, apperr)
+	require.True(t, registered)
+	registered, apperr = s.db.removeConnection("testapp", s.nodeID, 0)
+	require.Nil(t, apperr)
+	require.False(t, registered)
+	require.Nil(t, s.db.addConnection("testapp", s.nodeID, 0, "192.0.2.1", 2))
+	conns, apperr := s.db.GetNodeConnections(s.nodeID)
+	require.Nil(t, apperr)
+	require.ElementsMatch(t, []nodeConnection{
+		{"testapp", 0}, {"testapp", 1}, {"testapp", 2}, {"testapp", 3},
+	}, conns)
+
+	require.Nil(t, s.db.AddUserIDToChannel("testapp", "chan0", 0, 2))
+	require.Nil(t, s.db.AddUserIDToChannel("testapp", "chan1", 0, 2))
+	require.Nil(t, s.db.AddUserIDToChannel("testapp", "chan1", 0, 2))
+	apperr = s.db.AddUserIDToChannel("testapp", "chan2", 0, 2)
+	require.NotNil(t, apperr)
+	require.Equal(t, pusherCodeOverQuota, apperr.(*appError).PusherCode)
+	n, apperr := s.db.CountChannels("testapp")
+	require.Nil(t, apperr)
+	require.Equal(t, 2, n)
+
+	require.Nil(t, s.db.DropUserIDFromChannel("testapp", "chan0", 0))
+	require.Nil(t, s.db.AddUserIDToChannel("testapp", "chan2", 0, 2))
+}
+
+func TestLowlevelRateLimit(t *testing.T) {
+	s := initRedisTest(t)
+	defer s.Finish()
+
+	for i := 0; i < 2; i++ {
+		wait, apperr := s.db.takeToken("testapp/api-rate-limit", 0.1, 2)
+		require.Nil(t, apperr)
+		require.Equal(t, time.Duration(0), wait)
+	}
+	wait, apperr := s.db.takeToken("testapp/api-rate-limit", 0.1, 2)
+	require.Nil(t, apperr)
+	require.True(t, wait > 9*time.Second && wait <= 10*time.Second, wait)
+
+	wait, apperr = s.db.takeToken("testapp2/api-rate-limit", 0.1, 2)
+	require.Nil(t, apperr)
+	require.Equal(t, time.Duration(0), wait)
+}
+
+func TestLowlevelApplications(t *testing.T) {
+	s := initRedisTest(t)
+	defer s.Finish()
+
+	apps, apperr := s.db.GetApplications()
+	require.Nil(t, apperr)
+	require.Equal(t, 0, len(apps))
+
+	ca := ConfigApplication{Name: "newapp", Key: "newkey", Secret: "s"}
+	require.Nil(t, s.db.PutApplication(&ca, true))
+	require.NotNil(t, s.db.PutApplication(&ca, true))
+	ca.Secret = "s2"
+	require.Nil(t, s.db.PutApplication(&ca, false))
+	apps, apperr = s.db.GetApplications()
+	require.Nil(t, apperr)
+	require.Equal(t, []ConfigApplication{ca}, apps)
+
+	// Other processes pick it up via pubsub
+	require.Eventually(t, func() bool {
+		return s.config().GetAppFromKey("newkey") != nil
+	}, 5*time.Second, 100*time.Millisecond)
+
+	require.Nil(t, s.db.DeleteApplication("newapp"))
+	require.NotNil(t, s.db.DeleteApplication("newapp"))
+	require.Eventually(t, func() bool {
+		return s.config().GetAppFromKey("newkey") == nil
+	}, 5*time.Second, 100*time.Millisecond)
 }
 
 func TestLowlevelBroadcast(t *testing.T) {
@@ -189,36 +430,30 @@ func TestLowlevelBroadcast(t *testing.T) {
 	_, apperr = s.GetOrCreateChannel("testapp", "chan0")
 	require.Nil(t, apperr)
 
-	var ersave *EventRequest
-	s.db.eventCallback = func(er *EventRequest) bool {
-		ersave = er
+	received := make(chan *EventRequest, 10)
+	s.db.setEventCallback(func(er *EventRequest) bool {
+		received <- er
 		return false
-	}
+	})
 
 	a, _ := s.GetApp("testapp")
-	apperr = s.Broadcast(a,
+	apperr = s.Broadcast(context.Background(), a,
 		&Event{Name: "event-name", Data: "event-data"},
 		"chan0")
 	require.Nil(t, apperr)
 
-	time.Sleep(500 * time.Millisecond)
-
-	require.Equal(t, &EventRequest{Name: "event-name",
+	requireEventRequest(t, &EventRequest{Name: "event-name",
 		Data:        "event-data",
 		Application: "testapp",
 		Channel:     "chan0"},
-		ersave)
+		received)
 }
 
 func TestLowlevelBroadcast_reconnection(t *testing.T) {
-	// execute this integration test with Redis installed by Homebrew
-	if err := exec.Command("bash", "-c", "brew list | grep redis").Run(); err != nil {
-		return // skip this test
+	if testing.Short() {
+		t.Skip("waits for the subscription to be resumed")
 	}
-
-	err := exec.Command("bash", "-c", "brew services restart redis").Run()
-	require.NoError(t, err)
-	time.Sleep(500 * time.Millisecond)
+	restartTestRedis(t)
 
 	s := initRedisTest(t)
 	defer s.Finish()
@@ -233,51 +468,47 @@ func TestLowlevelBroadcast_reconnection(t *testing.T) {
 	_, apperr = s.GetOrCreateChannel("testapp", "chan0")
 	require.Nil(t, apperr)
 
-	var ersave *EventRequest
-	s.db.eventCallback = func(er *EventRequest) bool {
-		ersave = er
+	received := make(chan *EventRequest, 10)
+	s.db.setEventCallback(func(er *EventRequest) bool {
+		received <- er
 		return false
-	}
-
-	a, _ := s.GetApp("testapp")
-	apperr = s.Broadcast(a, &Event{Name: "event-name", Data: "event-data"}, "chan0")
-	require.NoError(t, apperr)
-	time.Sleep(500 * time.Millisecond)
-
-	require.Equal(t, &EventRequest{
+	})
+	expected := &EventRequest{
 		Name:        "event-name",
 		Data:        "event-data",
 		Application: "testapp",
-		Channel:     "chan0"},
-		ersave)
+		Channel:     "chan0"}
 
-	// re-send to stopped redis
-	err = exec.Command("bash", "-c", "brew services stop redis").Run()
-	require.NoError(t, err)
-	time.Sleep(500 * time.Millisecond)
+	a, _ := s.GetApp("testapp")
+	apperr = s.Broadcast(context.Background(), a, &Event{Name: "event-name", Data: "event-data"}, "chan0")
+	require.NoError(t, apperr)
+	requireEventRequest(t, expected, received)
 
-	ersave = nil
-	apperr = s.Broadcast(a, &Event{Name: "event-name", Data: "event-data"}, "chan0")
+	// re-send to stopped redis
+	stopTestRedis(t)
+	apperr = s.Broadcast(context.Background(), a, &Event{Name: "event-name", Data: "event-data"}, "chan0")
 	require.Error(t, apperr)
-
-	time.Sleep(5000 * time.Millisecond)
-	require.Nil(t, ersave)
-
-	// start redis
-	err = exec.Command("bash", "-c", "brew services start redis").Run()
-	require.NoError(t, err)
-	time.Sleep(5000 * time.Millisecond)
-
-	apperr = s.Broadcast(a, &Event{Name: "event-name", Data: "event-data"}, "chan0")
+	require.Eventually(t, func() bool { return !s.subscribed.Load() },
+		10*time.Second, 10*time.Millisecond)
+
+	// start redis; the subscription is resumed, possibly after failing
+	// with stale connections in the pool
+	startTestRedis(t)
+	require.Eventually(t, func() bool { return s.subscribed.Load() },
+		20*time.Second, 10*time.Millisecond)
+	apperr = s.Broadcast(context.Background(), a, &Event{Name: "event-name", Data: "event-data"}, "chan0")
 	require.NoError(t, apperr)
-	time.Sleep(500 * time.Millisecond)
+	requireEventRequest(t, expected, received)
+	require.Empty(t, received)
+}
 
-	require.Equal(t, &EventRequest{
-		Name:        "event-name",
-		Data:        "event-data",
-		Application: "testapp",
-		Channel:     "chan0"},
-		ersave)
+func requireEventRequest(t *testing.T, expected *EventRequest, received chan *EventRequest) {
+	select {
+	case er := <-received:
+		require.Equal(t, expected, er)
+	case <-time.After(5 * time.Second):
+		t.Fatal("event request not received")
+	}
 }
 
 func TestLowlevelSubscribeNonexitentChannel(t *testing.T) {
@@ -0,0 +1,214 @@
+package notifier
+
+import (
+	"os"
+	"os/signal"
+	"path/filepath"
+	"reflect"
+	"syscall"
+	"time"
+
+	mapset "github.com/deckarep/golang-set"
+	"github.com/fsnotify/fsnotify"
+)
+
+const (
+	// Changes of the config file within this period are applied at once,
+	// for editors may write a file in several steps.
+	configWatchDelay = 500 * time.Millisecond
+)
+
+// Reload applies the new configuration.  Applications are matched by
+// name; existing ones keep their connections even if the key or the
+// secret is changed, new ones are created, and the connections to the
+// removed ones are closed.  Applications registered via the admin API
+// remain.  Settings that can't be changed at runtime, namely the
+// listeners, the certificate paths, Redis, tracing and logging except
+// the level, are kept as they were; the certificate files themselves
+// are reloaded on modification.
+func (s *Supervisor) Reload(config *Config) {
+	s.reloadMu.Lock()
+	defer s.reloadMu.Unlock()
+
+	old := s.fileConfig
+	if config.Host != old.Host || config.Port != old.Port || config.Socket != old.Socket ||
+		config.Listeners != old.Listeners {
+		s.logger.Warnw("change of listeners requires restart")
+	}
+	if config.Certificate != old.Certificate || config.PrivateKey != old.PrivateKey ||
+		config.ClientCA != old.ClientCA {
+		s.logger.Warnw("change of certificate paths requires restart")
+	}
+	if config.Redis != old.Redis {
+		s.logger.Warnw("change of redis requires restart")
+	}
+	if config.Admin.AppsFile != old.Admin.AppsFile {
+		s.logger.Warnw("change of admin apps-file requires restart")
+	}
+	if config.Tracing != old.Tracing {
+		s.logger.Warnw("change of tracing requires restart")
+	}
+	if config.Logging.Format != old.Logging.Format || config.Logging.Output != old.Logging.Output ||
+		!reflect.DeepEqual(config.Logging.Sampling, old.Logging.Sampling) ||
+		config.Logging.AccessLog != old.Logging.AccessLog {
+		s.logger.Warnw("change of logging other than level requires restart")
+	}
+	if config.Logging.Level != old.Logging.Level {
+		// validated on reading
+		level, _ := parseLogLevel(config.Logging.Level)
+		s.logLevel.SetLevel(level)
+		s.logger.Infow("log level changed", "level", level.String())
+	}
+	merged := *config
+	merged.Host, merged.Port, merged.Socket = old.Host, old.Port, old.Socket
+	merged.Listeners = old.Listeners
+	merged.Certificate, merged.PrivateKey = old.Certificate, old.PrivateKey
+	merged.ClientCA = old.ClientCA
+	merged.Redis = old.Redis
+	merged.Admin.AppsFile = old.Admin.AppsFile
+	merged.Tracing = old.Tracing
+	merged.Logging.Format, merged.Logging.Output = old.Logging.Format, old.Logging.Output
+	merged.Logging.Sampling, merged.Logging.AccessLog = old.Logging.Sampling, old.Logging.AccessLog
+	s.fileConfig = &merged
+	s.applyConfig()
+}
+
+// applyConfig makes the effective configuration from the config file
+// and the applications registered via the admin API, and updates the
+// applications accordingly.  The caller must hold reloadMu.
+func (s *Supervisor) applyConfig() {
+	config := *s.fileConfig
+	config.Applications = append([]ConfigApplication{}, s.fileConfig.Applications...)
+	for _, ca := range s.dynamicApps {
+		if s.fileConfig.GetApp(ca.Name) != nil {
+			s.logger.Warnw("application registered via admin API is shadowed by config file",
+				"app", ca.Name)
+			continue
+		}
+		config.Applications = append(config.Applications, ca)
+	}
+
+	current := make(map[string]*Application)
+	for _, a := range s.apps() {
+		current[a.Name] = a
+	}
+	apps := make([]*Application, 0, len(config.Applications))
+	added := 0
+	for _, ca := range config.Applications {
+		a, ok := current[ca.Name]
+		if ok {
+			delete(current, ca.Name)
+		} else {
+			a = &Application{
+				Name:     ca.Name,
+				Channels: make(map[string]*Channel),
+				Users:    mapset.NewSet(),
+			}
+			added++
+		}
+		apps = append(apps, a)
+	}
+
+	s.mu.Lock()
+	s.Config = &config
+	s.Apps = apps
+	s.mu.Unlock()
+
+	for _, a := range current {
+		s.retireApp(a)
+	}
+	s.logger.Infow("configuration applied",
+		"num-applications", len(apps),
+		"added", added,
+		"removed", len(current))
+}
+
+// ReloadFile reads the config file and applies it.  If the file is
+// invalid, the current configuration remains.
+func (s *Supervisor) ReloadFile(file string) error {
+	config, err := ReadConfigFile(file)
+	if err != nil {
+		return err
+	}
+	s.Reload(config)
+	return nil
+}
+
+// retireApp closes all the connections to the removed application.
+// The users are cleaned up as the connections are closed.
+func (s *Supervisor) retireApp(a *Application) {
+	s.logger.Infow("retiring application", "app", a.Name,
+		"num-users", a.Users.Cardinality())
+	for _, x := range a.Users.ToSlice() {
+		u := x.(*User)
+		if u.Connection == nil {
+			_ = s.removeUser(a, u.ID)
+			continue
+		}
+		u.writeMu.Lock()
+		closeWithError(u.Connection, a.Name, u.Client, pusherCodeAppNotFound,
+			"Application has been removed")
+		u.writeMu.Unlock()
+		_ = u.Connection.Close()
+	}
+}
+
+// ReloadOnSignal reloads the config file whenever SIGHUP is received.
+func (s *Supervisor) ReloadOnSignal(file string) {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGHUP)
+	go func() {
+		for range ch {
+			s.logger.Infow("SIGHUP received; reloading", "file", file)
+			if err := s.ReloadFile(file); err != nil {
+				s.logger.Errorw("reload failed", "file", file, "error", err)
+			}
+		}
+	}()
+}
+
+// WatchConfigFile reloads the config file whenever it is modified.
+// The directory is watched instead of the file, so that replacing the
+// file (as editors and Kubernetes ConfigMaps do) is also noticed.
+func (s *Supervisor) WatchConfigFile(file string) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	err = watcher.Add(filepath.Dir(file))
+	if err != nil {
+		_ = watcher.Close()
+		return err
+	}
+	target := filepath.Clean(file)
+	go func() {
+		var timer *time.Timer
+		for {
+			select {
+			case ev, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if filepath.Clean(ev.Name) != target ||
+					ev.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Rename) == 0 {
+					continue
+				}
+				if timer != nil {
+					timer.Stop()
+				}
+				timer = time.AfterFunc(configWatchDelay, func() {
+					s.logger.Infow("config file changed; reloading", "file", file)
+					if err := s.ReloadFile(file); err != nil {
+						s.logger.Errorw("reload failed", "file", file, "error", err)
+					}
+				})
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				s.logger.Errorw("config watch error", "error", err)
+			}
+		}
+	}()
+	return nil
+}
@@ -0,0 +1,72 @@
+package notifier
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestReload(t *testing.T) {
+	s := initTest(t, DefaultConfig)
+	defer s.Finish()
+	server := httptest.NewServer(newRouter(s))
+	defer server.Close()
+	router := newRouter(s)
+
+	conn1 := dialTestSocket(t, server, "1234567890")
+	defer conn1.Close()
+	subscribeTestSocket(t, conn1, "my-channel")
+	conn2 := dialTestSocket(t, server, "anystringwilldo")
+	defer conn2.Close()
+
+	config := *s.Config
+	config.Port = 9999
+	config.Applications = []ConfigApplication{
+		{Name: "testapp", Key: "1234567890", Secret: "newsecret"},
+		{Name: "testapp3", Key: "newkey", Secret: "xyzzy"},
+	}
+	s.Reload(&config)
+
+	require.Equal(t, 8150, s.Config.Port)
+	require.Equal(t, "newsecret", s.Config.GetApp("testapp").Secret)
+	rr := doRequest(t, router, "GET", "/apps", "", http.StatusOK)
+	require.Equal(t, J(`{"applications":["testapp","testapp3"]}`), jsonBody(t, rr))
+
+	// Connections to the removed application are closed
+	data := readTestError(t, conn2)
+	require.Equal(t, pusherCodeAppNotFound, data.Code)
+	requireTestClose(t, conn2, pusherCodeAppNotFound)
+
+	// Connections to the remaining application survive
+	_ = doRequest(t, router, "POST", "/apps/testapp/events",
+		`{"name":"ev","channels":["my-channel"],"data":"{}"}`, http.StatusOK)
+	ev := readTestEvent(t, conn1)
+	require.Equal(t, "ev", ev.Event)
+
+	conn3 := dialTestSocket(t, server, "newkey")
+	defer conn3.Close()
+}
+
+func TestReloadFile(t *testing.T) {
+	s := initTest(t, DefaultConfig)
+	defer s.Finish()
+
+	file := filepath.Join(t.TempDir(), "config.json")
+	require.Nil(t, os.WriteFile(file, []byte("{not a json"), 0o600))
+	require.NotNil(t, s.ReloadFile(file))
+	require.Equal(t, 2, len(s.Config.Applications))
+
+	require.Nil(t, s.WatchConfigFile(file))
+	require.Nil(t, os.WriteFile(file,
+		[]byte(`{"applications":[{"name":"testapp","key":"k","secret":"s"}]}`), 0o600))
+	require.Eventually(t, func() bool {
+		return len(s.config().Applications) == 1
+	}, 5*time.Second, 100*time.Millisecond)
+	_, apperr := s.GetApp("testapp2")
+	require.NotNil(t, apperr)
+}
@@ -1,55 +1,268 @@
 package notifier
 
 import (
-	"fmt"
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
 	"net/http"
+	"net/http/pprof"
 	"os"
+	"sync"
 	"time"
 
 	"github.com/gorilla/handlers"
 	"github.com/gorilla/mux"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.uber.org/zap"
 )
 
+// DefaultPort is the port listened on if not configured.
+const DefaultPort = 8111
+
+// Groups of routes served by a listener
 const (
-	defaultPort = 8111
+	routeSocket  = 1 << iota // WebSocket connections
+	routeAPI                 // REST and admin API
+	routeOps                 // metrics and node status
+	routeProfile             // pprof; only on the dedicated ops listener
 )
 
-// NewServer creates a new HTTP server for MicroNotifier.
-func NewServer(s *Supervisor) *http.Server {
-	host := s.Config.Host
-	port := s.Config.Port
-	if port == 0 {
-		port = defaultPort
-	}
+// Server is the set of HTTP servers of MicroNotifier, one for each
+// listener.  They are started and shut down together.
+type Server struct {
+	servers   []*listenerServer
+	accessLog io.Writer // nil if disabled
+	logger    *zap.SugaredLogger
+}
 
-	router := handlers.LoggingHandler(os.Stdout, newRouter(s))
+type listenerServer struct {
+	name     string
+	listener ConfigListener
+	server   *http.Server
+}
 
-	s.logger.Infow("starting server", "host", host, "port", port,
-		"num-applications", len(s.Config.Applications),
-		"secure", s.Config.Certificate != "")
+// NewServer creates the HTTP servers for MicroNotifier.  The main
+// listener serves WebSocket connections, and also the REST API and the
+// metrics unless they have their own listeners.
+func NewServer(s *Supervisor) (*Server, error) {
+	config := s.config()
+	main := config.mainListener()
+	routes := routeSocket | routeAPI | routeOps
+	accessLog, err := openAccessLog(config.Logging.AccessLog)
+	if err != nil {
+		return nil, err
+	}
+	srv := &Server{accessLog: accessLog, logger: s.logger}
+	if config.Listeners.API.configured() {
+		routes &^= routeAPI
+		err := srv.add(s, "api", config.Listeners.API, routeAPI)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if config.Listeners.Ops.configured() {
+		routes &^= routeOps
+		err := srv.add(s, "ops", config.Listeners.Ops, routeOps|routeProfile)
+		if err != nil {
+			return nil, err
+		}
+	}
+	err = srv.add(s, "main", main, routes)
+	if err != nil {
+		return nil, err
+	}
+	s.logger.Infow("starting server",
+		"num-applications", len(config.Applications))
+	return srv, nil
+}
 
-	return &http.Server{
-		Handler:      router,
-		Addr:         fmt.Sprintf("%s:%d", host, port),
+// add adds a server of the listener serving the routes.
+func (srv *Server) add(s *Supervisor, name string, l ConfigListener, routes int) error {
+	tlsConfig, err := s.newTLSConfig(l)
+	if err != nil {
+		return err
+	}
+	handler := s.newRouterFor(routes, l.ClientCA != "")
+	if srv.accessLog != nil {
+		handler = handlers.CustomLoggingHandler(srv.accessLog, handler, writeAccessLog)
+	}
+	_, addr := l.address()
+	server := &http.Server{
+		Handler:      handler,
+		Addr:         addr,
+		TLSConfig:    tlsConfig,
 		WriteTimeout: 15 * time.Second,
 		ReadTimeout:  15 * time.Second,
 	}
+	if routes&routeProfile != 0 {
+		// CPU profiles take 30 seconds by default
+		server.WriteTimeout = 0
+	}
+	srv.servers = append(srv.servers, &listenerServer{
+		name:     name,
+		listener: l,
+		server:   server,
+	})
+	return nil
+}
+
+// listen opens the listener.  A stale Unix domain socket file left by
+// the previous process is removed.
+func (ls *listenerServer) listen() (net.Listener, error) {
+	network, addr := ls.listener.address()
+	if network == "unix" {
+		if fi, err := os.Stat(addr); err == nil && fi.Mode()&os.ModeSocket != 0 {
+			_ = os.Remove(addr)
+		}
+	}
+	ln, err := net.Listen(network, addr)
+	if err != nil {
+		return nil, err
+	}
+	if ls.server.TLSConfig != nil {
+		ln = tls.NewListener(ln, ls.server.TLSConfig)
+	}
+	return ln, nil
+}
+
+// ListenAndServe starts all the servers and blocks until they stop.
+// If any of them fails, the others are shut down and the error is
+// returned.  Returns http.ErrServerClosed after Shutdown.
+func (srv *Server) ListenAndServe() error {
+	listeners := make([]net.Listener, 0, len(srv.servers))
+	for _, ls := range srv.servers {
+		ln, err := ls.listen()
+		if err != nil {
+			for _, opened := range listeners {
+				_ = opened.Close()
+			}
+			return err
+		}
+		listeners = append(listeners, ln)
+	}
+
+	errCh := make(chan error, len(srv.servers))
+	var once sync.Once
+	for i, ls := range srv.servers {
+		network, addr := ls.listener.address()
+		srv.logger.Infow("listening", "listener", ls.name,
+			"network", network, "address", addr,
+			"secure", ls.server.TLSConfig != nil,
+			"client-ca", ls.listener.ClientCA != "")
+		go func(ls *listenerServer, ln net.Listener) {
+			err := ls.server.Serve(ln)
+			if !errors.Is(err, http.ErrServerClosed) {
+				srv.logger.Errorw("server stopped", "listener", ls.name, "error", err)
+			}
+			once.Do(func() {
+				// stop the others as well
+				ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+				defer cancel()
+				_ = srv.Shutdown(ctx)
+			})
+			errCh <- err
+		}(ls, listeners[i])
+	}
+	var first error
+	for range srv.servers {
+		err := <-errCh
+		if first == nil || errors.Is(first, http.ErrServerClosed) {
+			first = err
+		}
+	}
+	return first
+}
+
+// Shutdown gracefully shuts down all the servers.
+func (srv *Server) Shutdown(ctx context.Context) error {
+	var first error
+	for _, ls := range srv.servers {
+		if err := ls.server.Shutdown(ctx); err != nil && first == nil {
+			first = err
+		}
+	}
+	if f, ok := srv.accessLog.(*os.File); ok && f != os.Stdout && f != os.Stderr {
+		_ = f.Close()
+	}
+	return first
 }
 
 func newRouter(s *Supervisor) http.Handler {
+	return s.newRouterFor(routeSocket|routeAPI|routeOps, s.config().ClientCA != "")
+}
+
+// newRouterFor creates a router serving the routes.  If requireCert is
+// true, the REST API requires client certificates.
+func (s *Supervisor) newRouterFor(routes int, requireCert bool) http.Handler {
 	router := mux.NewRouter()
+	router.Use(tracingMiddleware)
+
+	if routes&routeAPI != 0 {
+		// Meta functions
+		// OPTIONS is for CORS preflight requests, handled by corsMiddleware.
+		api := router.PathPrefix("/apps").Subrouter()
+		api.Use(s.corsMiddleware)
+		if requireCert {
+			api.Use(s.clientCertMiddleware)
+		}
+		// Signatures are verified first, so that forged requests are
+		// rejected without using up the rate limit of the application.
+		// Unsigned ones, if accepted, still take the tokens.
+		api.Use(s.authMiddleware)
+		api.Use(s.rateLimitMiddleware)
+		api.HandleFunc("", s.listApplications).Methods("GET", "OPTIONS")
+		api.HandleFunc("/{app}/channels", s.appChannels).Methods("GET", "OPTIONS")
+		api.HandleFunc("/{app}/channels/{chan}", s.getChannel).Methods("GET", "OPTIONS")
+		api.HandleFunc("/{app}/channels/{chan}/users", s.getChannelUsers).Methods("GET", "OPTIONS")
+		api.HandleFunc("/{app}/events", s.trigger).Methods("POST", "OPTIONS")
+		api.HandleFunc("/{app}/usage", s.appUsage).Methods("GET", "OPTIONS")
+		api.HandleFunc("/{app}/debug", s.debugConsole).Methods("GET")
 
-	// Meta functions
-	router.HandleFunc("/apps", s.listApplications).Methods("GET")
-	router.HandleFunc("/apps/{app}/channels", s.appChannels).Methods("GET")
-	router.HandleFunc("/apps/{app}/channels/{chan}", s.getChannel).Methods("GET")
-	router.HandleFunc("/apps/{app}/channels/{chan}/users", s.getChannelUsers).Methods("GET")
-	router.HandleFunc("/apps/{app}/events", s.trigger).Methods("POST")
+		admin := router.PathPrefix("/admin").Subrouter()
+		if requireCert {
+			admin.Use(s.clientCertMiddleware)
+		}
+		admin.Use(s.adminMiddleware)
+		admin.HandleFunc("/apps", s.adminListApps).Methods("GET")
+		admin.HandleFunc("/apps", s.adminCreateApp).Methods("POST")
+		admin.HandleFunc("/apps/{app}", s.adminUpdateApp).Methods("PUT")
+		admin.HandleFunc("/apps/{app}", s.adminDeleteApp).Methods("DELETE")
+		admin.HandleFunc("/log-level", s.adminGetLogLevel).Methods("GET")
+		admin.HandleFunc("/log-level", s.adminSetLogLevel).Methods("PUT")
+		admin.HandleFunc("/overview", s.adminOverview).Methods("GET")
+		admin.HandleFunc("/apps/{app}/recent-events", s.adminRecentEvents).Methods("GET")
+		admin.HandleFunc("/apps/{app}/channels", s.appChannels).Methods("GET")
+		admin.HandleFunc("/apps/{app}/channels/{chan}", s.getChannel).Methods("GET")
+		admin.HandleFunc("/apps/{app}/channels/{chan}/users", s.getChannelUsers).Methods("GET")
+		admin.HandleFunc("/apps/{app}/events", s.trigger).Methods("POST")
+		admin.HandleFunc("/apps/{app}/usage", s.appUsage).Methods("GET")
 
-	router.HandleFunc("/app/{key}", s.establishConnection).Methods("GET")
+		router.Handle("/dashboard", http.RedirectHandler("/dashboard/", http.StatusMovedPermanently))
+		router.PathPrefix("/dashboard/").Handler(s.dashboardHandler()).Methods("GET", "HEAD")
+	}
 
-	router.Handle("/metrics", promhttp.Handler()).Methods("GET")
+	// Every listener serves health checks for its load balancer
+	router.HandleFunc("/healthz", s.healthz).Methods("GET")
+	router.HandleFunc("/readyz", s.readyz).Methods("GET")
+
+	if routes&routeSocket != 0 {
+		router.HandleFunc("/app/{key}", s.establishConnection).Methods("GET")
+	}
+
+	if routes&routeOps != 0 {
+		router.Handle("/metrics", promhttp.Handler()).Methods("GET")
+		router.HandleFunc("/status", s.nodeStatus).Methods("GET")
+	}
+
+	if routes&routeProfile != 0 {
+		router.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+		router.HandleFunc("/debug/pprof/profile", pprof.Profile)
+		router.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+		router.HandleFunc("/debug/pprof/trace", pprof.Trace)
+		router.PathPrefix("/debug/pprof/").HandlerFunc(pprof.Index)
+	}
 
-	return handlers.LoggingHandler(os.Stdout, router)
+	return router
 }
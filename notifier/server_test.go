@@ -0,0 +1,83 @@
+package notifier
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// unixClient returns an HTTP client connecting to the Unix domain socket.
+func unixClient(socket string) *http.Client {
+	return &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", socket)
+		},
+	}}
+}
+
+func TestListeners(t *testing.T) {
+	dir := t.TempDir()
+	config, err := ReadConfigFile("../config/sample.json")
+	require.Nil(t, err)
+	config.Host, config.Port = "", 0
+	config.Socket = filepath.Join(dir, "main.sock")
+	config.Listeners.API.Socket = filepath.Join(dir, "api.sock")
+	config.Listeners.Ops.Socket = filepath.Join(dir, "ops.sock")
+	require.Nil(t, config.validate())
+	s := NewSupervisor(config)
+	defer s.Finish()
+
+	server, err := NewServer(s)
+	require.Nil(t, err)
+	done := make(chan error, 1)
+	go func() {
+		done <- server.ListenAndServe()
+	}()
+
+	get := func(socket, path string) int {
+		client := unixClient(socket)
+		var resp *http.Response
+		var err error
+		for i := 0; i < 50; i++ {
+			resp, err = client.Get("http://notifier" + path)
+			if err == nil {
+				break
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		require.Nil(t, err)
+		defer resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	require.Equal(t, http.StatusBadRequest, get(config.Socket, "/app/1234567890"))
+	require.Equal(t, http.StatusNotFound, get(config.Socket, "/apps"))
+	require.Equal(t, http.StatusNotFound, get(config.Socket, "/metrics"))
+
+	require.Equal(t, http.StatusOK, get(config.Listeners.API.Socket, "/apps"))
+	require.Equal(t, http.StatusNotFound, get(config.Listeners.API.Socket, "/app/1234567890"))
+	require.Equal(t, http.StatusNotFound, get(config.Listeners.API.Socket, "/metrics"))
+
+	require.Equal(t, http.StatusOK, get(config.Listeners.Ops.Socket, "/metrics"))
+	require.Equal(t, http.StatusOK, get(config.Listeners.Ops.Socket, "/debug/pprof/"))
+	require.Equal(t, http.StatusNotFound, get(config.Listeners.Ops.Socket, "/apps"))
+	require.Equal(t, http.StatusOK, get(config.Listeners.Ops.Socket, "/status"))
+	require.Equal(t, http.StatusNotFound, get(config.Socket, "/status"))
+
+	// Health checks are served by all the listeners
+	for _, socket := range []string{config.Socket, config.Listeners.API.Socket, config.Listeners.Ops.Socket} {
+		require.Equal(t, http.StatusOK, get(socket, "/healthz"))
+		require.Equal(t, http.StatusOK, get(socket, "/readyz"))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	require.Nil(t, server.Shutdown(ctx))
+	require.ErrorIs(t, <-done, http.ErrServerClosed)
+}
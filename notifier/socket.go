@@ -1,17 +1,19 @@
 package notifier
 
 import (
-	"crypto/hmac"
-	"crypto/sha256"
-	"encoding/hex"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"math/rand"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/gorilla/websocket"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // PusherEvent is a struct to receive Pusher message.
@@ -19,7 +21,20 @@ import (
 type PusherEvent struct {
 	Event   string `json:"event"`
 	Data    string `json:"data"`
-	Channel string `json:"channel",omitempty`
+	Channel string `json:"channel,omitempty"`
+}
+
+// PusherErrorData is the payload of pusher:error event.
+type PusherErrorData struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// SubscriptionErrorData is the payload of pusher:subscription_error event.
+type SubscriptionErrorData struct {
+	Type   string `json:"type"`
+	Error  string `json:"error"`
+	Status int    `json:"status"`
 }
 
 // ConnectionEstablishedData is a struct to return pusher:connection_established
@@ -49,80 +64,205 @@ func encodePusherEvent(eventName string, chanName string, data any) ([]byte, err
 	return js, nil
 }
 
+// encodePusherEventObject is like encodePusherEvent, but the data is
+// encoded as a JSON object rather than a JSON-encoded string.
+func encodePusherEventObject(eventName string, chanName string, data any) ([]byte, error) {
+	js, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(struct {
+		Event   string          `json:"event"`
+		Data    json.RawMessage `json:"data"`
+		Channel string          `json:"channel,omitempty"`
+	}{
+		Event:   eventName,
+		Data:    js,
+		Channel: chanName,
+	})
+}
+
 func (s *Supervisor) socketFinish(u *User, logmsg string, err error) {
 	if err != nil {
 		s.logger.Debugw(logmsg, "uid", u.ID, "err", err)
 	} else {
 		s.logger.Infow(logmsg, "uid", u.ID)
 	}
-	apperr := s.RemoveUser(u.App.Name, u.ID)
+	apperr := s.removeUser(u.App, u.ID)
 	if apperr != nil {
 		s.logger.Infow("RemoveUser failed", "apperr", apperr)
+	} else {
+		s.emitDebug(&debugEvent{Type: debugDisconnection, App: u.App.Name, SocketID: u.SocketID})
 	}
 	_ = u.Connection.Close()
 }
 
 func (s *Supervisor) socketSend(u *User, eventName string, chanName string, data any) {
 	msg, err := encodePusherEvent(eventName, chanName, data)
+	s.socketWrite(u, msg, err)
+}
+
+// socketSendErrorEvent sends an error event, whose data format depends
+// on the protocol version.
+func (s *Supervisor) socketSendErrorEvent(u *User, eventName string, chanName string, data any) {
+	if !u.Client.structuredErrors() {
+		s.socketSend(u, eventName, chanName, data)
+		return
+	}
+	msg, err := encodePusherEventObject(eventName, chanName, data)
+	s.socketWrite(u, msg, err)
+}
+
+func (s *Supervisor) socketWrite(u *User, msg []byte, err error) {
 	if err != nil {
 		s.socketFinish(u, "[internal] marshalling send packet error", err)
 		return
 	}
+	u.writeMu.Lock()
 	err = u.Connection.WriteMessage(websocket.TextMessage, msg)
+	u.writeMu.Unlock()
 	if err != nil {
 		s.socketFinish(u, "writeMessage error", err)
+		return
 	}
+	sentBytes.WithLabelValues(u.App.Name).Add(float64(len(msg)))
 }
 
-func (s *Supervisor) socketSendInvalid(u *User, event string, received any) {
+func (s *Supervisor) socketSendInvalid(u *User, event string) {
 	s.logger.Debugw("invalid event",
-		"event", event,
-		"data", received)
-	s.socketSend(u, "pusher:error", "", "unrecognized message")
+		"uid", u.ID,
+		"event", event)
+	s.socketSendError(u, pusherErr(400, pusherCodeGeneric,
+		fmt.Sprintf("Invalid %s message", event)))
 }
 
-func (s *Supervisor) checkSignature(u *User, channel string, socketID string, auth string) bool {
-	appConfig := s.Config.GetApp(u.App.Name)
-	if appConfig == nil {
-		return false
+// socketSendError sends pusher:error with the Pusher error code and
+// the message of apperr.
+func (s *Supervisor) socketSendError(u *User, err error) {
+	apperr, ok := err.(*appError)
+	if !ok || apperr.PusherCode == 0 {
+		apperr = &appError{Message: err.Error(), PusherCode: pusherCodeGeneric}
+	}
+	s.logger.Debugw("sending error",
+		"user", u.ID, "code", apperr.PusherCode, "message", apperr.Message)
+	s.emitDebug(&debugEvent{Type: debugError, App: u.App.Name, SocketID: u.SocketID,
+		Code: apperr.PusherCode, Message: apperr.Message})
+	s.socketSendErrorEvent(u, "pusher:error", "",
+		PusherErrorData{Code: apperr.PusherCode, Message: apperr.Message})
+}
+
+// socketClose sends pusher:error, then closes the connection with the
+// Pusher error code as the close code.
+func (s *Supervisor) socketClose(u *User, err error) {
+	apperr, ok := err.(*appError)
+	if !ok || apperr.PusherCode == 0 {
+		apperr = &appError{Message: err.Error(), PusherCode: pusherCodeOverCapacity}
 	}
-	signString := socketID + ":" + channel
-	digest := hmac.New(sha256.New, []byte(appConfig.Secret))
-	_, _ = digest.Write([]byte(signString))
-	expected := appConfig.Key + ":" + hex.EncodeToString(digest.Sum(nil))
+	u.writeMu.Lock()
+	closeWithError(u.Connection, u.App.Name, u.Client, apperr.PusherCode, apperr.Message)
+	u.writeMu.Unlock()
+	s.socketFinish(u, "closing connection: "+apperr.Message, nil)
+}
 
-	s.logger.Infow("authenticate",
-		"result", auth == expected,
-		"auth", auth,
-		"expects", expected)
+// closeWithError sends pusher:error and the close message to the
+// connection.  The connection may not be associated with a user yet,
+// in which case ci may be zero value.  appname is used for metrics,
+// and may be empty if the application is unknown.
+func closeWithError(conn *websocket.Conn, appname string, ci ClientInfo, code int, message string) {
+	observeWebsocketError(appname, code)
+	encode := encodePusherEvent
+	if ci.structuredErrors() {
+		encode = encodePusherEventObject
+	}
+	msg, err := encode("pusher:error", "",
+		PusherErrorData{Code: code, Message: message})
+	if err == nil {
+		_ = conn.WriteMessage(websocket.TextMessage, msg)
+	}
+	_ = conn.WriteControl(websocket.CloseMessage,
+		websocket.FormatCloseMessage(code, message),
+		time.Now().Add(time.Second))
+}
 
-	return auth == expected
+// socketSendSubscriptionError tells the client that subscribing the
+// channel failed.
+func (s *Supervisor) socketSendSubscriptionError(u *User, channel string, errType string, err error) {
+	status := 500
+	if apperr, ok := err.(*appError); ok {
+		status = apperr.Code
+	}
+	s.logger.Debugw("subscription error",
+		"user", u.ID, "app", u.App.Name, "channel", channel,
+		"type", errType, "error", err)
+	s.emitDebug(&debugEvent{Type: debugError, App: u.App.Name, SocketID: u.SocketID,
+		Channel: channel, Event: "pusher:subscription_error", Message: errType + ": " + err.Error()})
+	s.socketSendErrorEvent(u, "pusher:subscription_error", channel,
+		SubscriptionErrorData{Type: errType, Error: err.Error(), Status: status})
 }
 
-func (s *Supervisor) socketSendUnauthorized(u *User) {
-	s.logger.Debugw("user unauthorized",
-		"user", u.ID, "app", u.App.Name, "socket", u.SocketID)
-	s.socketSend(u, "pusher:error", "", "unauthorized")
+// checkSignature verifies the auth of the subscription.  channelData is
+// signed as well for presence channels, and empty otherwise.
+func (s *Supervisor) checkSignature(u *User, channel string, socketID string, channelData string, auth string) bool {
+	appConfig := s.config().GetApp(u.App.Name)
+	if appConfig == nil {
+		return false
+	}
+	// auth is <key>:<signature>, signed with any active secret of the key.
+	key := auth
+	if i := strings.Index(auth, ":"); i >= 0 {
+		key = auth[:i]
+	}
+	ok := false
+	for _, secret := range appConfig.secretsFor(key, time.Now()) {
+		expected := ChannelAuth(key, secret, socketID, channel)
+		if channelData != "" {
+			expected = PresenceChannelAuth(key, secret, socketID, channel, channelData)
+		}
+		if auth == expected {
+			ok = true
+			break
+		}
+	}
+	if !ok {
+		authFailures.WithLabelValues(u.App.Name, "channel").Inc()
+	}
+
+	s.logger.Debugw("authenticate",
+		"app", u.App.Name,
+		"channel", channel,
+		"result", ok,
+		"auth", redactAuth(auth))
+
+	return ok
 }
 
 func (s *Supervisor) socketMessageHandleLoop(u *User) {
 	for {
 		_, p, err := u.Connection.ReadMessage()
 		if err != nil {
+			var ce *websocket.CloseError
+			if errors.As(err, &ce) && websocket.IsUnexpectedCloseError(err,
+				websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				observeWebsocketError(u.App.Name, ce.Code)
+			}
 			s.socketFinish(u, "readMessage error", err)
 			return
 		}
-		s.logger.Infow("received", "message", string(p))
-
 		var ev struct {
-			Name string `json:"event"`
-			Data any    `json:"data"`
+			Name    string `json:"event"`
+			Channel string `json:"channel"`
+			Data    any    `json:"data"`
 		}
 		err = json.Unmarshal(p, &ev)
 		if err != nil {
-			s.socketFinish(u, "message decode error", err)
-			return
+			s.logger.Debugw("message decode error", "uid", u.ID, "err", err)
+			s.socketSendError(u, pusherErr(400, pusherCodeGeneric,
+				"Invalid message format: "+err.Error()))
+			continue
 		}
+		// Data may contain signatures or user payloads
+		s.logger.Debugw("received",
+			"uid", u.ID, "event", ev.Name, "channel", ev.Channel, "size", len(p))
 
 		switch ev.Name {
 		case "pusher:ping":
@@ -130,78 +270,137 @@ func (s *Supervisor) socketMessageHandleLoop(u *User) {
 		case "pusher:subscribe":
 			m, ok := ev.Data.(map[string]any)
 			if !ok {
-				s.socketSendInvalid(u, ev.Name, ev.Data)
+				s.socketSendInvalid(u, ev.Name)
 				break
 			}
-			channel, ok := m["channel"]
+			channel, ok := m["channel"].(string)
 			if !ok {
-				s.socketSendInvalid(u, ev.Name, ev.Data)
+				s.socketSendInvalid(u, ev.Name)
 				break
 			}
 			s.logger.Debugw("subscribe request",
 				"channel", channel)
+			if apperr := validateChannelName(channel); apperr != nil {
+				s.socketSendSubscriptionError(u, channel, "InvalidChannel", apperr)
+				break
+			}
 
-			if strings.HasPrefix(channel.(string), "private-") {
-				auth, ok := m["auth"]
-				if !ok || !s.checkSignature(u, channel.(string), u.SocketID, auth.(string)) {
-					s.socketSendUnauthorized(u)
+			if isAuthenticatedChannel(channel) {
+				channelData := ""
+				if strings.HasPrefix(channel, "presence-") {
+					channelData, _ = m["channel_data"].(string)
+					if !validPresenceData(channelData) {
+						s.socketSendSubscriptionError(u, channel, "AuthError",
+							appErr(401, "channel_data with user_id is required for "+channel))
+						break
+					}
+				}
+				auth, ok := m["auth"].(string)
+				if !ok || !s.checkSignature(u, channel, u.SocketID, channelData, auth) {
+					s.socketSendSubscriptionError(u, channel, "AuthError",
+						appErr(401, "Invalid signature for "+u.SocketID+":"+channel))
 					break
 				}
 			}
-			apperr := s.Subscribe(u.App.Name, u.ID, channel.(string))
+			apperr := s.Subscribe(u.App.Name, u.ID, channel)
 			if apperr != nil {
-				s.socketSendInvalid(u, ev.Name, ev.Data)
+				s.socketSendSubscriptionError(u, channel, "SubscriptionError", apperr)
 				break
 			}
-			s.socketSend(u, "pusher_internal:subscription_succeeded", channel.(string), "ok")
+			s.socketSend(u, "pusher_internal:subscription_succeeded", channel, "ok")
+			s.emitDebug(&debugEvent{Type: debugSubscribed, App: u.App.Name,
+				SocketID: u.SocketID, Channel: channel})
 		case "pusher:unsubscribe":
 			m, ok := ev.Data.(map[string]any)
 			if !ok {
-				s.socketSendInvalid(u, ev.Name, ev.Data)
+				s.socketSendInvalid(u, ev.Name)
 				break
 			}
-			channel, ok := m["channel"]
+			channel, ok := m["channel"].(string)
 			if !ok {
-				s.socketSendInvalid(u, ev.Name, ev.Data)
+				s.socketSendInvalid(u, ev.Name)
 				break
 			}
 			s.logger.Debugw("unsubscribe request",
 				"channel", channel)
-			_ = s.Unsubscribe(u.App.Name, u.ID, channel.(string))
+			if s.Unsubscribe(u.App.Name, u.ID, channel) == nil {
+				s.emitDebug(&debugEvent{Type: debugUnsubscribed, App: u.App.Name,
+					SocketID: u.SocketID, Channel: channel})
+			}
 		default:
-			s.socketSend(u, "pusher:error", "", "not implemented")
+			if strings.HasPrefix(ev.Name, "client-") {
+				s.handleClientEvent(u, ev.Name, ev.Channel, ev.Data)
+				break
+			}
+			s.socketSendError(u, pusherErr(400, pusherCodeGeneric,
+				"Unsupported event received: "+ev.Name))
 		}
 	}
 }
 
 func (s *Supervisor) establishConnection(w http.ResponseWriter, r *http.Request) {
 	app, apperr := s.GetAppFromKey(mux.Vars(r)["key"])
-	if apperr != nil {
-		returnErr(s, w, apperr)
-		return
+	if apperr == nil {
+		ca := s.config().GetApp(app.Name)
+		origin := r.Header.Get("Origin")
+		if ca == nil || !ca.socketOriginAllowed(origin) {
+			s.logger.Infow("origin not allowed", "app", app.Name, "origin", origin)
+			rejectedOrigins.WithLabelValues(app.Name).Inc()
+			returnErr(s, w, appErr(http.StatusForbidden, "Origin not allowed"))
+			return
+		}
 	}
 
 	upgrader := websocket.Upgrader{
 		ReadBufferSize:  1024,
 		WriteBufferSize: 1024,
-		CheckOrigin:     func(r *http.Request) bool { return true },
+		// Checked above against allowed-origins of the application
+		CheckOrigin: func(r *http.Request) bool { return true },
 	}
 
 	conn, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
-		returnErr(s, w, wrapErr(500, err))
+		s.logger.Infow("websocket upgrade failed", "error", err)
+		return
+	}
+
+	// Errors are reported over WebSocket, so that the client library
+	// can tell whether to reconnect.
+	if apperr != nil {
+		closeWithError(conn, "", ClientInfo{}, pusherCodeAppNotFound, apperr.Error())
+		_ = conn.Close()
 		return
 	}
 
+	ci, apperr := parseClientInfo(r.URL.Query())
+	if apperr != nil {
+		s.logger.Infow("protocol negotiation failed",
+			"app", app.Name, "query", r.URL.RawQuery, "error", apperr)
+		closeWithError(conn, app.Name, ClientInfo{}, apperr.(*appError).PusherCode, apperr.Error())
+		_ = conn.Close()
+		return
+	}
+	ci.observe()
+
 	u, apperr := s.AddUser(app.Name, conn)
 	if apperr != nil {
-		returnErr(s, w, apperr)
+		s.logger.Infow("AddUser failed", "app", app.Name, "error", apperr)
+		code := pusherCodeOverCapacity
+		if e, ok := apperr.(*appError); ok && e.PusherCode != 0 {
+			code = e.PusherCode
+		}
+		closeWithError(conn, app.Name, ci, code, apperr.Error())
+		_ = conn.Close()
 		return
 	}
+	u.Client = ci
 
 	s.logger.Infow("New connection",
 		"app", app.Name,
-		"userId", u.ID)
+		"userId", u.ID,
+		"protocol", ci.Protocol,
+		"client", ci.Client,
+		"version", ci.Version)
 
 	// Handkshake
 	sockid := fmt.Sprintf("%d.%d", rand.Uint64(), rand.Uint64())
@@ -216,13 +415,15 @@ func (s *Supervisor) establishConnection(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	s.logger.Infow("sending", "msg", msg)
-
+	u.writeMu.Lock()
 	err = conn.WriteMessage(websocket.TextMessage, msg)
+	u.writeMu.Unlock()
 	if err != nil {
 		s.socketFinish(u, "pusher write message error", err)
 		return
 	}
+	sentBytes.WithLabelValues(app.Name).Add(float64(len(msg)))
+	s.emitDebug(&debugEvent{Type: debugConnection, App: app.Name, SocketID: sockid})
 
 	conn.SetCloseHandler(func(code int, text string) error {
 		msg := fmt.Sprintf("peer closed connection (%d): %s",
@@ -237,33 +438,57 @@ func (s *Supervisor) establishConnection(w http.ResponseWriter, r *http.Request)
 // Broadcast sends out the event to the users who subscribe the given channel.
 // In distributed mode, we don't know which process is managing the user,
 // so we use Redis Keyspace Notification.
-func (s *Supervisor) Broadcast(a *Application, e *Event, cn string) error {
+func (s *Supervisor) Broadcast(ctx context.Context, a *Application, e *Event, cn string) (err error) {
+	ctx, span := tracer().Start(ctx, "broadcast",
+		trace.WithAttributes(spanApp(a.Name), spanChannel(cn),
+			attribute.String("notifier.event", e.Name)))
+	defer func() { endSpan(span, err) }()
+
 	if s.db != nil {
 		s.logger.Debugw("queueing",
-			"event", e,
-			"channel", cn)
-		return s.PublishRedisEvent(&EventRequest{
+			"event", e.Name,
+			"channel", cn,
+			"size", len(e.Data))
+		return s.PublishRedisEvent(ctx, &EventRequest{
 			Name:        e.Name,
 			Data:        e.Data,
 			Application: a.Name,
-			Channel:     cn})
+			Channel:     cn,
+			Exclude:     e.Exclude})
 	}
-	return s.realBroadcast(a, e, cn)
+	return s.realBroadcast(ctx, a, e, cn)
 }
 
-func (s *Supervisor) realBroadcast(a *Application, e *Event, cn string) error {
+func (s *Supervisor) realBroadcast(ctx context.Context, a *Application, e *Event, cn string) (err error) {
 	s.logger.Debugw("broadcasting",
-		"event", e,
-		"channel", cn)
-	ch, apperr := s.GetOrCreateChannel(a.Name, cn)
+		"event", e.Name,
+		"channel", cn,
+		"size", len(e.Data))
+	ctx, span := tracer().Start(ctx, "deliver",
+		trace.WithAttributes(spanApp(a.Name), spanChannel(cn)))
+	defer func() { endSpan(span, err) }()
+
+	s.recordEvent(a, e, cn)
+	start := time.Now()
+	_, lookupSpan := tracer().Start(ctx, "lookup channel")
+	ch, apperr := s.LookupChannel(a.Name, cn)
+	endSpan(lookupSpan, apperr)
 	if apperr != nil {
 		return apperr
 	}
+	delivered := 0
 	for uid := range ch.Users {
 		u := a.GetUserByID(uid)
-		if u != nil {
+		if u != nil && (e.Exclude == "" || u.SocketID != e.Exclude) {
+			_, writeSpan := tracer().Start(ctx, "websocket write",
+				trace.WithAttributes(attribute.String("notifier.socket_id", u.SocketID)))
 			s.socketSend(u, e.Name, cn, e.Data)
+			writeSpan.End()
+			delivered++
 		}
 	}
+	span.SetAttributes(attribute.Int("notifier.delivered", delivered))
+	messagesDelivered.WithLabelValues(a.Name).Add(float64(delivered))
+	broadcastDuration.WithLabelValues(a.Name).Observe(time.Since(start).Seconds())
 	return nil
 }
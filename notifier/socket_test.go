@@ -1,84 +1,46 @@
-//go:build chrome_test
-
 package notifier
 
 import (
-	"context"
 	"crypto/tls"
-	"fmt"
-	"io/ioutil"
-	"log"
+	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"regexp"
-	"strconv"
 	"testing"
 	"time"
 
-	"github.com/chromedp/cdproto"
-	"github.com/chromedp/cdproto/page"
-	"github.com/chromedp/chromedp"
-	"github.com/gorilla/mux"
 	pusher "github.com/pusher/pusher-http-go"
+	"github.com/sony/micro-notifier/notifier/pushertest"
 	"github.com/stretchr/testify/require"
 )
 
+// TestServers is the notifier under test, with a Pusher server library
+// calling its REST API and Pusher protocol clients connecting to it.
 type TestServers struct {
+	Supervisor   *Supervisor
 	Notifier     *httptest.Server
-	Content      *httptest.Server
 	PusherClient *pusher.Client
-
-	testContent []byte
+	Dialer       *pushertest.Dialer
 }
 
-type TestContentProvider func(*TestServers, bool) []byte
-
-func spawnServers(t *testing.T, contentProvider TestContentProvider, isSecure bool) *TestServers {
-	// Start notifier server
-	configType := DefaultConfig
-	if isSecure {
-		configType = SecureConfig
-	}
-	s := initTest(t, configType)
-	router := newRouter(s)
-
-	var notifier *httptest.Server
-	var client *http.Client
-	if isSecure {
-		nts := httptest.NewUnstartedServer(router)
-		nts.StartTLS()
-		notifier = nts
-		client = &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}}
+func spawnServers(t *testing.T, configType int) *TestServers {
+	var s *Supervisor
+	if configType == RedisConfig {
+		s = initRedisTest(t)
 	} else {
-		notifier = httptest.NewServer(router)
-		client = &http.Client{}
-	}
-
-	// Wait for server to spin up
-	var ok = false
-	for i := 0; i < 10; i++ {
-		_, err := client.Get(notifier.URL + "/apps")
-		if err == nil {
-			ok = true
-			break
-		}
-		time.Sleep(50 * time.Millisecond)
+		s = initTest(t, configType)
 	}
-	require.True(t, ok)
-
-	ts := TestServers{Notifier: notifier}
-
-	// Content server
-	ts.testContent = contentProvider(&ts, isSecure)
-
-	tsrouter := mux.NewRouter()
-	tsrouter.HandleFunc("/", ts.serveContent).Methods("GET")
-	tsrouter.HandleFunc("/auth", ts.signerHandler).Methods("POST")
-	ts.Content = httptest.NewServer(tsrouter)
+	ts := &TestServers{Supervisor: s}
 
 	var httpClient *http.Client
-	if isSecure {
-		httpClient = &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}}
+	var tlsConfig *tls.Config
+	if configType == SecureConfig {
+		ts.Notifier = httptest.NewTLSServer(newRouter(s))
+		tlsConfig = &tls.Config{InsecureSkipVerify: true}
+		httpClient = &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig}}
+	} else {
+		ts.Notifier = httptest.NewServer(newRouter(s))
 	}
 
 	ts.PusherClient = &pusher.Client{
@@ -86,254 +48,114 @@ func spawnServers(t *testing.T, contentProvider TestContentProvider, isSecure bo
 		Key:        "1234567890",
 		Secret:     "abcdefghij",
 		Host:       regexp.MustCompile("^http.*://").ReplaceAllString(ts.Notifier.URL, ""),
-		Secure:     isSecure,
+		Secure:     configType == SecureConfig,
 		HTTPClient: httpClient,
 	}
-
-	return &ts
+	ts.Dialer = &pushertest.Dialer{
+		Key:             "1234567890",
+		Authorizer:      ts.authorize,
+		TLSClientConfig: tlsConfig,
+	}
+	return ts
 }
 
 func (ts *TestServers) Close() {
 	ts.Notifier.Close()
-	ts.Content.Close()
+	ts.Supervisor.Finish()
 }
 
-func (ts *TestServers) notifierPort() string {
-	return regexp.MustCompile(`:(\d+)$`).FindStringSubmatch(ts.Notifier.URL)[1]
-}
-
-func (ts *TestServers) serveContent(w http.ResponseWriter, req *http.Request) {
-	w.Write(ts.testContent)
-}
-
-func (ts *TestServers) signerHandler(w http.ResponseWriter, req *http.Request) {
-	params, _ := ioutil.ReadAll(req.Body)
-	response, err := ts.PusherClient.AuthenticatePrivateChannel(params)
-
+// authorize signs the subscription by the Pusher server library, as the
+// auth endpoint of an application server does.
+func (ts *TestServers) authorize(socketID string, channel string) (string, error) {
+	params := url.Values{"socket_id": {socketID}, "channel_name": {channel}}
+	response, err := ts.PusherClient.AuthenticatePrivateChannel([]byte(params.Encode()))
 	if err != nil {
-		log.Printf("signerHandler: authenticate error: %#v", err)
-		http.Error(w, "bad request", 400)
-		return
+		return "", err
 	}
-
-	log.Printf("signerHandler: returning %s", string(response))
-	w.Header().Set("Content-Type", "application/json")
-	w.Write(response)
+	var body struct {
+		Auth string `json:"auth"`
+	}
+	err = json.Unmarshal(response, &body)
+	return body.Auth, err
 }
 
-// Landing page for TestWebsocketConnection
-func websocketConnectionContent(ts *TestServers, isSecure bool) []byte {
-	return []byte(`
-<head>
-  <title>Pusher Test</title>
-  <script src="https://js.pusher.com/4.4/pusher.min.js"></script>
-  <script>
+// testWebsocketConnection checks that the events triggered by the
+// server library reach the subscriber.
+func testWebsocketConnection(t *testing.T, ts *TestServers) {
+	client := ts.PusherClient
 
-    // Enable pusher logging - don't include this in production
-    Pusher.logToConsole = true;
+	users, err := client.GetChannelUsers("my-channel")
+	require.Nil(t, err)
+	require.Equal(t, 0, len(users.List))
 
-    var pusher = new Pusher('1234567890', {
-      wsHost: 'localhost',
-      wsPort: ` + ts.notifierPort() + `,
-      wssPort: ` + ts.notifierPort() + `,
-      forceTLS: ` + strconv.FormatBool(isSecure) + `
-    });
+	c := ts.Dialer.Dial(t, ts.Notifier.URL)
+	defer c.Close()
+	c.Subscribe("my-channel")
 
-    var events = [];
+	users, err = client.GetChannelUsers("my-channel")
+	require.Nil(t, err)
+	require.Equal(t, 1, len(users.List))
 
-    var channel = pusher.subscribe('my-channel');
-    channel.bind('my-event', function(data) {
-      events.push(data.message);
-    });
-  </script>
-</head>
-<body>
-  <h1>Pusher Test</h1>
-  <p>
-    Try publishing an event to channel <code>my-channel</code>
-    with event name <code>my-event</code>.
-  </p>
-</body>
-`)
-}
-
-func TestWebsocketConnection(t *testing.T) {
-	for _, isSecure := range []bool{false, true} {
-		ts := spawnServers(t, websocketConnectionContent, isSecure)
-		defer ts.Close()
-		client := ts.PusherClient
-
-		users, err := client.GetChannelUsers("my-channel")
+	for _, message := range []string{"knock, knock", "who's there?"} {
+		err = client.Trigger("my-channel", "my-event", map[string]string{"message": message})
 		require.Nil(t, err)
-		require.Equal(t, 0, len(users.List))
-
-		opts := append(chromedp.DefaultExecAllocatorOptions[:],
-			chromedp.Flag("allow-insecure-localhost", true),
-		)
+		ev := c.ExpectEvent("my-channel", "my-event")
+		require.JSONEq(t, `{"message":"`+message+`"}`, ev.Data)
+	}
 
-		allocCtx, cancel := chromedp.NewExecAllocator(context.Background(), opts...)
-		defer cancel()
+	c.Ping()
+	c.Unsubscribe("my-channel")
+	c.Ping() // the unsubscription is done by now
+	err = client.Trigger("my-channel", "my-event", map[string]string{"message": "anybody?"})
+	require.Nil(t, err)
+	c.ExpectNothing(100 * time.Millisecond)
+}
 
-		ctx, cancel := chromedp.NewContext(allocCtx)
-		defer cancel()
+// testWebsocketSignature checks that private channels require the auth
+// signed by the server library.
+func testWebsocketSignature(t *testing.T, ts *TestServers) {
+	client := ts.PusherClient
+
+	users, err := client.GetChannelUsers("private-my-channel")
+	require.Nil(t, err)
+	require.Equal(t, 0, len(users.List))
+
+	c := ts.Dialer.Dial(t, ts.Notifier.URL)
+	defer c.Close()
+
+	serr := c.ExpectSubscriptionError("private-my-channel", "")
+	require.Equal(t, "AuthError", serr.Type)
+	require.Equal(t, http.StatusUnauthorized, serr.Status)
+	serr = c.ExpectSubscriptionError("private-my-channel",
+		pushertest.Sign("1234567890", "wrongsecret", c.SocketID, "private-my-channel"))
+	require.Equal(t, "AuthError", serr.Type)
+
+	c.Subscribe("private-my-channel")
+	users, err = client.GetChannelUsers("private-my-channel")
+	require.Nil(t, err)
+	require.Equal(t, 1, len(users.List))
+
+	err = client.Trigger("private-my-channel", "my-event", map[string]string{"message": "secret"})
+	require.Nil(t, err)
+	ev := c.ExpectEvent("private-my-channel", "my-event")
+	require.JSONEq(t, `{"message":"secret"}`, ev.Data)
+}
 
-		chromedp.ListenTarget(ctx, func(ev interface{}) {
-			if ev, ok := ev.(*page.EventJavascriptDialogOpening); ok {
-				fmt.Println("closing alert:", ev.Message)
-				go func() {
-					if err := chromedp.Run(ctx,
-						page.HandleJavaScriptDialog(true),
-					); err != nil {
-						panic(err)
-					}
-				}()
-			}
-			if msg, ok := ev.(*cdproto.Message); ok {
-				fmt.Println("message: ", string(msg.Result))
-			}
+// testPlainAndTLS runs the test over ws:// and wss://.
+func testPlainAndTLS(t *testing.T, test func(*testing.T, *TestServers)) {
+	for name, configType := range map[string]int{"plain": DefaultConfig, "tls": SecureConfig} {
+		t.Run(name, func(t *testing.T) {
+			ts := spawnServers(t, configType)
+			defer ts.Close()
+			test(t, ts)
 		})
-
-		err = chromedp.Run(ctx,
-			chromedp.Navigate(ts.Content.URL),
-		)
-		require.Nil(t, err)
-
-		// Wait for subscription
-		var ok = false
-		for i := 0; i < 10; i++ {
-			time.Sleep(50 * time.Millisecond)
-			users, err = client.GetChannelUsers("my-channel")
-			require.Nil(t, err)
-			if len(users.List) == 1 {
-				ok = true
-				break
-			}
-		}
-		require.True(t, ok, fmt.Sprintf("secure is %v", isSecure))
-
-		data := map[string]string{"message": "knock, knock"}
-		client.Trigger("my-channel", "my-event", data)
-
-		var res []string
-		for i := 0; i < 10; i++ {
-			time.Sleep(50 * time.Millisecond)
-			err = chromedp.Run(ctx,
-				chromedp.Evaluate("events", &res),
-			)
-			require.Nil(t, err)
-			if len(res) > 0 {
-				break
-			}
-		}
-		require.Equal(t, 1, len(res))
-		require.Equal(t, "knock, knock", res[0])
-
-		data = map[string]string{"message": "who's there?"}
-		client.Trigger("my-channel", "my-event", data)
-
-		for i := 0; i < 10; i++ {
-			time.Sleep(50 * time.Millisecond)
-			err = chromedp.Run(ctx,
-				chromedp.Evaluate("events", &res),
-			)
-			require.Nil(t, err)
-			if len(res) > 1 {
-				break
-			}
-		}
-		require.Equal(t, 2, len(res))
-		require.Equal(t, "who's there?", res[1])
 	}
 }
 
-// Landing page for TestWebsocketSignature
-func websocketSignatureContent(ts *TestServers, isSecure bool) []byte {
-	return []byte(`
-<head>
-  <title>Pusher Test</title>
-  <script src="https://js.pusher.com/4.4/pusher.min.js"></script>
-  <script>
-
-    // Enable pusher logging - don't include this in production
-    Pusher.logToConsole = true;
-
-    var pusher = new Pusher('1234567890', {
-      wsHost: 'localhost',
-      wsPort: ` + ts.notifierPort() + `,
-      wssPort: ` + ts.notifierPort() + `,
-      authEndpoint: '/auth',
-      forceTLS: ` + strconv.FormatBool(isSecure) + `
-    });
-
-    var events = [];
-
-    var channel = pusher.subscribe('private-my-channel');
-    channel.bind('my-event', function(data) {
-      events.push(data);
-    });
-  </script>
-</head>
-<body>
-  <h1>Pusher Test</h1>
-  <p>
-    Try publishing an event to channel <code>my-channel</code>
-    with event name <code>my-event</code>.
-  </p>
-</body>
-`)
+func TestWebsocketConnection(t *testing.T) {
+	testPlainAndTLS(t, testWebsocketConnection)
 }
 
 func TestWebsocketSignature(t *testing.T) {
-	for _, isSecure := range []bool{false, true} {
-		ts := spawnServers(t, websocketSignatureContent, isSecure)
-		defer ts.Close()
-		client := ts.PusherClient
-
-		users, err := client.GetChannelUsers("private-my-channel")
-		require.Nil(t, err)
-		require.Equal(t, 0, len(users.List))
-
-		opts := append(chromedp.DefaultExecAllocatorOptions[:],
-			chromedp.Flag("allow-insecure-localhost", true),
-		)
-
-		allocCtx, cancel := chromedp.NewExecAllocator(context.Background(), opts...)
-		defer cancel()
-
-		//	ctx, cancel := chromedp.NewContext(context.Background(),
-		//		chromedp.WithBrowserOption(chromedp.WithBrowserDebugf(log.Printf)))
-		ctx, cancel := chromedp.NewContext(allocCtx)
-		defer cancel()
-
-		chromedp.ListenTarget(ctx, func(ev interface{}) {
-			if ev, ok := ev.(*page.EventJavascriptDialogOpening); ok {
-				fmt.Println("closing alert:", ev.Message)
-				go func() {
-					if err := chromedp.Run(ctx,
-						page.HandleJavaScriptDialog(true),
-					); err != nil {
-						panic(err)
-					}
-				}()
-			}
-		})
-
-		err = chromedp.Run(ctx,
-			chromedp.Navigate(ts.Content.URL),
-		)
-		require.Nil(t, err)
-
-		// Wait for subscription
-		var ok = false
-		for i := 0; i < 10; i++ {
-			time.Sleep(50 * time.Millisecond)
-			users, err = client.GetChannelUsers("private-my-channel")
-			require.Nil(t, err)
-			if len(users.List) == 1 {
-				ok = true
-				break
-			}
-		}
-		require.True(t, ok, fmt.Sprintf("secure is %v", isSecure))
-	}
+	testPlainAndTLS(t, testWebsocketSignature)
 }
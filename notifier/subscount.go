@@ -0,0 +1,172 @@
+package notifier
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultSubscriptionCountInterval = 1000 // milliseconds
+)
+
+// subscriptionCountData is the payload of pusher_internal:subscription_count.
+type subscriptionCountData struct {
+	SubscriptionCount int `json:"subscription_count"`
+}
+
+// subscriptionCounter throttles subscription_count notifications.
+// When the subscription count of a channel changes, a notification is
+// scheduled after the configured interval; further changes within the
+// interval are coalesced into that notification.
+type subscriptionCounter struct {
+	mu      sync.Mutex
+	pending map[string]bool // <application>/<channel> -> scheduled
+	last    map[string]int  // <application>/<channel> -> last notified count
+}
+
+func newSubscriptionCounter() *subscriptionCounter {
+	return &subscriptionCounter{
+		pending: make(map[string]bool),
+		last:    make(map[string]int),
+	}
+}
+
+// schedule returns true if the caller should start a timer for the key.
+func (sc *subscriptionCounter) schedule(key string) bool {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	if sc.pending[key] {
+		return false
+	}
+	sc.pending[key] = true
+	return true
+}
+
+// done clears the pending flag.
+func (sc *subscriptionCounter) done(key string) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	delete(sc.pending, key)
+}
+
+// fire clears the pending flag and returns true if count differs from
+// the last notified one.  Channels never notified count as zero.
+func (sc *subscriptionCounter) fire(key string, count int) bool {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	delete(sc.pending, key)
+	if sc.last[key] == count {
+		return false
+	}
+	if count == 0 {
+		delete(sc.last, key)
+	} else {
+		sc.last[key] = count
+	}
+	return true
+}
+
+// subscriptionCountChanged is called whenever the subscription count
+// of a channel may have changed.  If the application enables
+// subscription count events, it schedules a notification.
+// Presence channels are excluded, for they have member events instead.
+func (s *Supervisor) subscriptionCountChanged(appname string, channame string) {
+	ca := s.config().GetApp(appname)
+	if ca == nil || !ca.SubscriptionCount.Enabled {
+		return
+	}
+	if strings.HasPrefix(channame, "presence-") {
+		return
+	}
+	if !s.subCounter.schedule(appname + "/" + channame) {
+		return
+	}
+	time.AfterFunc(ca.subscriptionCountInterval(), func() {
+		s.emitSubscriptionCount(appname, channame)
+	})
+}
+
+func (ca *ConfigApplication) subscriptionCountInterval() time.Duration {
+	interval := ca.SubscriptionCount.Interval
+	if interval <= 0 {
+		interval = defaultSubscriptionCountInterval
+	}
+	return time.Duration(interval) * time.Millisecond
+}
+
+// emitSubscriptionCount notifies the subscription count of the channel
+// if it has changed.  In distributed mode, every node seeing the changes
+// gets here; the last notified count and the time of the next
+// notification are shared in Redis, so that only one of them notifies
+// each change, at most once in the interval.
+func (s *Supervisor) emitSubscriptionCount(appname string, channame string) {
+	key := appname + "/" + channame
+	count := 0
+	ch, apperr := s.GetChannel(appname, channame)
+	if apperr == nil {
+		count = ch.SubscriptionCount()
+	}
+	if s.db != nil {
+		ca := s.config().GetApp(appname)
+		if ca == nil {
+			s.subCounter.done(key)
+			return
+		}
+		wait, apperr := s.db.claimSubscriptionCount(appname, channame, count,
+			ca.subscriptionCountInterval())
+		if apperr != nil {
+			s.logger.Errorw("subscription_count claim error",
+				"app", appname,
+				"channel", channame,
+				"error", apperr)
+			s.subCounter.done(key)
+			return
+		}
+		if wait > 0 {
+			// Notified by a node recently; retry when the interval ends.
+			time.AfterFunc(wait, func() {
+				s.emitSubscriptionCount(appname, channame)
+			})
+			return
+		}
+		s.subCounter.done(key)
+		if wait < 0 {
+			return
+		}
+	} else if !s.subCounter.fire(key, count) {
+		return
+	}
+
+	a, apperr := s.GetApp(appname)
+	if apperr != nil {
+		return
+	}
+	data, err := json.Marshal(subscriptionCountData{SubscriptionCount: count})
+	if err != nil {
+		s.logger.Errorw("subscription_count encoding error", "error", err)
+		return
+	}
+	if count > 0 {
+		apperr = s.Broadcast(context.Background(), a,
+			&Event{Name: "pusher_internal:subscription_count", Data: string(data)},
+			channame)
+		if apperr != nil {
+			s.logger.Errorw("subscription_count broadcast error",
+				"app", appname,
+				"channel", channame,
+				"error", apperr)
+		}
+	}
+
+	ca := s.config().GetApp(appname)
+	if ca != nil && ca.SubscriptionCount.WebhookURL != "" {
+		s.sendWebhook(ca, ca.SubscriptionCount.WebhookURL, []webhookEvent{{
+			Name:              "subscription_count",
+			Channel:           channame,
+			SubscriptionCount: &count,
+		}})
+	}
+}
@@ -0,0 +1,91 @@
+package notifier
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSubscriptionCount(t *testing.T) {
+	hooks := make(chan *http.Request, 10)
+	bodies := make(chan []byte, 10)
+	hookServer := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			body, _ := io.ReadAll(r.Body)
+			hooks <- r
+			bodies <- body
+		}))
+	defer hookServer.Close()
+
+	config := &Config{
+		Applications: []ConfigApplication{
+			{
+				Name:   "testapp",
+				Key:    "1234567890",
+				Secret: "abcdefghij",
+				SubscriptionCount: ConfigSubscriptionCount{
+					Enabled:    true,
+					Interval:   200,
+					WebhookURL: hookServer.URL,
+				},
+			},
+		},
+	}
+	s := NewSupervisor(config)
+	defer s.Finish()
+	server := httptest.NewServer(newRouter(s))
+	defer server.Close()
+
+	conn1 := dialTestSocket(t, server, "1234567890")
+	defer conn1.Close()
+	subscribeTestSocket(t, conn1, "my-channel")
+
+	ev := readTestEvent(t, conn1)
+	require.Equal(t, "pusher_internal:subscription_count", ev.Event)
+	require.Equal(t, "my-channel", ev.Channel)
+	require.Equal(t, J(`{"subscription_count": 1}`), J(ev.Data))
+
+	r := <-hooks
+	body := <-bodies
+	require.Equal(t, "1234567890", r.Header.Get("X-Pusher-Key"))
+	require.Equal(t, webhookSignature("abcdefghij", body),
+		r.Header.Get("X-Pusher-Signature"))
+	var wb webhookBody
+	require.Nil(t, json.Unmarshal(body, &wb))
+	require.Equal(t, 1, len(wb.Events))
+	require.Equal(t, "subscription_count", wb.Events[0].Name)
+	require.Equal(t, "my-channel", wb.Events[0].Channel)
+	require.Equal(t, 1, *wb.Events[0].SubscriptionCount)
+
+	// Changes within the interval are coalesced.
+	conn2 := dialTestSocket(t, server, "1234567890")
+	defer conn2.Close()
+	subscribeTestSocket(t, conn2, "my-channel")
+	conn3 := dialTestSocket(t, server, "1234567890")
+	defer conn3.Close()
+	subscribeTestSocket(t, conn3, "my-channel")
+
+	ev = readTestEvent(t, conn1)
+	require.Equal(t, "pusher_internal:subscription_count", ev.Event)
+	require.Equal(t, J(`{"subscription_count": 3}`), J(ev.Data))
+}
+
+func TestSubscriptionCountDisabled(t *testing.T) {
+	s := initTest(t, DefaultConfig)
+	defer s.Finish()
+	server := httptest.NewServer(newRouter(s))
+	defer server.Close()
+
+	conn := dialTestSocket(t, server, "1234567890")
+	defer conn.Close()
+	subscribeTestSocket(t, conn, "my-channel")
+
+	_ = conn.SetReadDeadline(time.Now().Add(2 * defaultSubscriptionCountInterval * time.Millisecond))
+	_, _, err := conn.ReadMessage()
+	require.NotNil(t, err)
+}
@@ -2,6 +2,9 @@ package notifier
 
 import (
 	"log"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"go.uber.org/zap"
 )
@@ -12,31 +15,86 @@ type Supervisor struct {
 	Apps   []*Application
 	Config *Config
 
-	db     *DB
-	logger *zap.SugaredLogger
+	mu       sync.RWMutex // guards Apps and Config on reload
+	reloadMu sync.Mutex   // serializes reloads; guards the below
+
+	fileConfig  *Config             // configuration read from the file
+	dynamicApps []ConfigApplication // registered via the admin API
+	db          *DB
+	logger      *zap.SugaredLogger
+	logLevel    zap.AtomicLevel
+	subCounter  *subscriptionCounter
+	apiBuckets  *bucketSet
+	debug       *debugHub
+	recent      *recentEvents
+
+	nodeID     string
+	stop       chan struct{} // closed on Finish
+	startedAt  time.Time
+	draining   atomic.Bool // shutting down; reported as not ready
+	subscribed atomic.Bool // Redis event subscription is active
 }
 
 // NewSupervisor creates a new Supervisor.
 func NewSupervisor(config *Config) *Supervisor {
-	logger, err := zap.NewProduction()
+	logger, level, err := newLogger(config.Logging)
 	if err != nil {
 		log.Fatalf("can't initialize zap logger: %v", err)
 	}
-	s := &Supervisor{Config: config, logger: logger.Sugar()}
+	s := &Supervisor{
+		Config:     config,
+		fileConfig: config,
+		logger:     logger.Sugar(),
+		logLevel:   level,
+		subCounter: newSubscriptionCounter(),
+		apiBuckets: newBucketSet(),
+		debug:      newDebugHub(),
+		recent:     newRecentEvents(),
+		nodeID:     newNodeID(),
+		stop:       make(chan struct{}),
+		startedAt:  time.Now(),
+	}
 
 	if config.Redis.Address != "" {
 		s.db = InitDB(config)
 	}
 
 	s.InitApps()
+	if s.db != nil || config.Admin.AppsFile != "" {
+		s.refreshDynamicApps()
+	}
+	s.warnUnsignedAPI()
 	s.KickRedisSubscription()
+	if s.db != nil {
+		go s.heartbeatLoop()
+	}
+	appMetrics.register(s)
 	return s
 }
 
+// config returns the current configuration.
+func (s *Supervisor) config() *Config {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.Config
+}
+
+// apps returns the current applications.
+func (s *Supervisor) apps() []*Application {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.Apps
+}
+
 // Finish finalizes the Supervisor.
 func (s *Supervisor) Finish() {
+	appMetrics.unregister(s)
+	close(s.stop)
 	_ = s.logger.Sync()
 	if s.db != nil {
+		_, _ = s.db.DeleteNode(s.nodeID)
+		// Connections not closed yet mustn't outlive the process
+		s.releaseNodeConnections(s.nodeID)
 		s.db.FinishDB()
 	}
 }
@@ -0,0 +1,155 @@
+package notifier
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// The certificate files are checked for changes at most this often.
+	certCheckInterval = 10 * time.Second
+)
+
+// certReloader serves the certificate, reloading it when the files are
+// modified, so that renewed certificates are used without restart.
+type certReloader struct {
+	certFile string
+	keyFile  string
+	interval time.Duration
+	logger   *zap.SugaredLogger
+
+	mu        sync.Mutex
+	cert      *tls.Certificate
+	stamp     string // modification times and sizes of the files
+	lastCheck time.Time
+}
+
+// fileStamp returns a string that changes when any of the files is
+// modified or replaced.
+func fileStamp(files ...string) (string, error) {
+	stamp := ""
+	for _, file := range files {
+		fi, err := os.Stat(file)
+		if err != nil {
+			return "", err
+		}
+		stamp += fmt.Sprintf("%v/%d;", fi.ModTime(), fi.Size())
+	}
+	return stamp, nil
+}
+
+func (s *Supervisor) newCertReloader(certFile, keyFile string) (*certReloader, error) {
+	cr := &certReloader{
+		certFile: certFile,
+		keyFile:  keyFile,
+		interval: certCheckInterval,
+		logger:   s.logger,
+	}
+	stamp, err := fileStamp(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	cr.cert, cr.stamp, cr.lastCheck = &cert, stamp, time.Now()
+	return cr, nil
+}
+
+// reload loads the certificate again if the files are modified.  If the
+// new files are broken, e.g. being written, the current certificate
+// remains.  The caller must hold mu.
+func (cr *certReloader) reload(now time.Time) {
+	if now.Sub(cr.lastCheck) < cr.interval {
+		return
+	}
+	cr.lastCheck = now
+	stamp, err := fileStamp(cr.certFile, cr.keyFile)
+	if err != nil {
+		cr.logger.Errorw("cannot access certificate", "error", err)
+		return
+	}
+	if stamp == cr.stamp {
+		return
+	}
+	cert, err := tls.LoadX509KeyPair(cr.certFile, cr.keyFile)
+	if err != nil {
+		cr.logger.Errorw("cannot reload certificate", "error", err)
+		return
+	}
+	cr.cert, cr.stamp = &cert, stamp
+	cr.logger.Infow("certificate reloaded", "certificate", cr.certFile)
+}
+
+// GetCertificate implements tls.Config.GetCertificate.
+func (cr *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+	cr.reload(time.Now())
+	return cr.cert, nil
+}
+
+// loadCertPool reads PEM encoded CA certificates.
+func loadCertPool(file string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, errors.New("no certificates found in " + file)
+	}
+	return pool, nil
+}
+
+// newTLSConfig returns the TLS configuration of the listener, or nil
+// if it serves plain HTTP.  The certificate is reloaded when the files
+// are modified.  If ClientCA is given, client certificates signed by it
+// are verified; they are required by the REST API, but not by WebSocket
+// connections from browsers.
+func (s *Supervisor) newTLSConfig(l ConfigListener) (*tls.Config, error) {
+	if l.Certificate == "" {
+		return nil, nil
+	}
+	cr, err := s.newCertReloader(l.Certificate, l.PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: cr.GetCertificate,
+	}
+	if l.ClientCA != "" {
+		pool, err := loadCertPool(l.ClientCA)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.ClientCAs = pool
+		tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	return tlsConfig, nil
+}
+
+// clientCertMiddleware requires a verified client certificate.
+func (s *Supervisor) clientCertMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodOptions {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+			returnErr(s, w, appErr(http.StatusForbidden, "Client certificate required"))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
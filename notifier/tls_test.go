@@ -0,0 +1,164 @@
+package notifier
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// testCert is a certificate issued for tests.
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	tls  tls.Certificate
+}
+
+// issueTestCert issues a certificate signed by parent, or a self-signed
+// CA certificate if parent is nil.
+func issueTestCert(t *testing.T, name string, parent *testCert) *testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.Nil(t, err)
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	require.Nil(t, err)
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	signer, signerKey := template, key
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage |= x509.KeyUsageCertSign
+	} else {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	require.Nil(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.Nil(t, err)
+	return &testCert{
+		cert: cert,
+		key:  key,
+		tls:  tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key},
+	}
+}
+
+// writeTestCert writes the certificate and the key in PEM.
+func writeTestCert(t *testing.T, tc *testCert, certFile, keyFile string) {
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: tc.cert.Raw})
+	require.Nil(t, os.WriteFile(certFile, certPEM, 0o600))
+	if keyFile != "" {
+		der, err := x509.MarshalECPrivateKey(tc.key)
+		require.Nil(t, err)
+		keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})
+		require.Nil(t, os.WriteFile(keyFile, keyPEM, 0o600))
+	}
+}
+
+func TestCertReload(t *testing.T) {
+	s := initTest(t, DefaultConfig)
+	defer s.Finish()
+
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	cert1 := issueTestCert(t, "server1", nil)
+	writeTestCert(t, cert1, certFile, keyFile)
+
+	cr, err := s.newCertReloader(certFile, keyFile)
+	require.Nil(t, err)
+	cr.interval = 0
+	cert, err := cr.GetCertificate(nil)
+	require.Nil(t, err)
+	require.Equal(t, cert1.cert.Raw, cert.Certificate[0])
+
+	// Broken files are ignored
+	require.Nil(t, os.WriteFile(certFile, []byte("broken"), 0o600))
+	cert, err = cr.GetCertificate(nil)
+	require.Nil(t, err)
+	require.Equal(t, cert1.cert.Raw, cert.Certificate[0])
+
+	cert2 := issueTestCert(t, "server2", nil)
+	writeTestCert(t, cert2, certFile, keyFile)
+	cert, err = cr.GetCertificate(nil)
+	require.Nil(t, err)
+	require.Equal(t, cert2.cert.Raw, cert.Certificate[0])
+
+	// Changes are checked at most once in the interval
+	cr.interval = time.Hour
+	writeTestCert(t, cert1, certFile, keyFile)
+	cert, err = cr.GetCertificate(nil)
+	require.Nil(t, err)
+	require.Equal(t, cert2.cert.Raw, cert.Certificate[0])
+}
+
+func TestClientCert(t *testing.T) {
+	dir := t.TempDir()
+	ca := issueTestCert(t, "ca", nil)
+	caFile := filepath.Join(dir, "ca.pem")
+	writeTestCert(t, ca, caFile, "")
+	serverCert := issueTestCert(t, "server", ca)
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	writeTestCert(t, serverCert, certFile, keyFile)
+	clientCert := issueTestCert(t, "client", ca)
+	otherCert := issueTestCert(t, "other", issueTestCert(t, "otherca", nil))
+
+	config, err := ReadConfigFile("../config/sample.json")
+	require.Nil(t, err)
+	config.Certificate, config.PrivateKey, config.ClientCA = certFile, keyFile, caFile
+	require.Nil(t, config.validate())
+	s := NewSupervisor(config)
+	defer s.Finish()
+
+	tlsConfig, err := s.newTLSConfig(config.mainListener())
+	require.Nil(t, err)
+	// StartTLS() would add its own certificate
+	server := httptest.NewUnstartedServer(newRouter(s))
+	server.Listener = tls.NewListener(server.Listener, tlsConfig)
+	server.Start()
+	defer server.Close()
+	url := strings.Replace(server.URL, "http://", "https://", 1)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	get := func(cert *testCert, path string) int {
+		clientTLS := &tls.Config{RootCAs: roots}
+		if cert != nil {
+			clientTLS.Certificates = []tls.Certificate{cert.tls}
+		}
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: clientTLS}}
+		resp, err := client.Get(url + path)
+		require.Nil(t, err)
+		defer resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	require.Equal(t, http.StatusOK, get(clientCert, "/apps/testapp/channels"))
+	require.Equal(t, http.StatusForbidden, get(nil, "/apps/testapp/channels"))
+	// Certificates not signed by the CA aren't even sent
+	require.Equal(t, http.StatusForbidden, get(otherCert, "/apps/testapp/channels"))
+
+	// WebSocket connections don't require client certificates
+	require.NotEqual(t, http.StatusForbidden, get(nil, "/app/1234567890"))
+}
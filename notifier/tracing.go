@@ -0,0 +1,182 @@
+package notifier
+
+import (
+	"context"
+	"net/http"
+	"os"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/gorilla/mux"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Spans of an event:
+//
+//	POST /apps/{app}/events     HTTP handler of the REST API
+//	  broadcast                 Supervisor.Broadcast
+//	    redis PUBLISH           distributed mode only
+//	    redis event             handleRedisEventRequest on each node
+//	      deliver               realBroadcast
+//	        lookup channel      from Redis in distributed mode
+//	        websocket write     for each subscriber
+//
+// The trace context crosses the nodes in EventRequest.TraceContext.
+// Without tracing configured, the global no-op tracer is used.
+
+const (
+	tracerName         = "github.com/sony/micro-notifier/notifier"
+	defaultServiceName = "micro-notifier"
+
+	exporterOTLP   = "otlp"
+	exporterStdout = "stdout"
+)
+
+func tracer() trace.Tracer {
+	return otel.Tracer(tracerName)
+}
+
+// InitTracing sets up the global tracer provider as configured, and
+// returns the function to flush and stop it.  Does nothing if tracing
+// isn't configured.
+func InitTracing(config *Config) (func(context.Context) error, error) {
+	ct := config.Tracing
+	var exporter sdktrace.SpanExporter
+	var err error
+	switch ct.Exporter {
+	case "":
+		return func(context.Context) error { return nil }, nil
+	case exporterStdout:
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+	case exporterOTLP:
+		options := []otlptracehttp.Option{}
+		if ct.Endpoint != "" {
+			options = append(options, otlptracehttp.WithEndpoint(ct.Endpoint))
+		}
+		if ct.Insecure {
+			options = append(options, otlptracehttp.WithInsecure())
+		}
+		exporter, err = otlptracehttp.New(context.Background(), options...)
+	default:
+		return nil, configErr("tracing.exporter", "Unknown exporter %q", ct.Exporter)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	name := ct.ServiceName
+	if name == "" {
+		name = defaultServiceName
+	}
+	// semconv must be of the same schema version as resource.Default
+	res, err := resource.Merge(resource.Default(),
+		resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceName(name)))
+	if err != nil {
+		return nil, err
+	}
+	ratio := ct.SampleRatio
+	if ratio == 0 {
+		ratio = 1
+	}
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(ratio))),
+	)
+	otel.SetTracerProvider(tp)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{}, propagation.Baggage{}))
+	return tp.Shutdown, nil
+}
+
+// Paths polled by probes and scrapers, which aren't worth tracing.
+var untracedPaths = map[string]bool{
+	"/healthz": true,
+	"/readyz":  true,
+	"/metrics": true,
+}
+
+// tracingMiddleware makes a span of each request, named after the
+// route, e.g. "GET /apps/{app}/channels".  Trace context in the request
+// headers is continued.
+func tracingMiddleware(next http.Handler) http.Handler {
+	return otelhttp.NewHandler(next, "",
+		otelhttp.WithFilter(func(r *http.Request) bool {
+			return !untracedPaths[r.URL.Path]
+		}),
+		otelhttp.WithSpanNameFormatter(func(_ string, r *http.Request) string {
+			if route := mux.CurrentRoute(r); route != nil {
+				if tmpl, err := route.GetPathTemplate(); err == nil {
+					return r.Method + " " + tmpl
+				}
+			}
+			return r.Method
+		}))
+}
+
+// endSpan records the error, if any, and ends the span.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// injectTrace stores the trace context of ctx into the request, so that
+// the nodes receiving it continue the trace.
+func (er *EventRequest) injectTrace(ctx context.Context) {
+	carrier := propagation.MapCarrier{}
+	otel.GetTextMapPropagator().Inject(ctx, carrier)
+	if len(carrier) > 0 {
+		er.TraceContext = carrier
+	}
+}
+
+// extractTrace returns the context continuing the trace of the request.
+func (er *EventRequest) extractTrace(ctx context.Context) context.Context {
+	if len(er.TraceContext) == 0 {
+		return ctx
+	}
+	return otel.GetTextMapPropagator().Extract(ctx, propagation.MapCarrier(er.TraceContext))
+}
+
+// tracedConn makes a span of each Redis command if the context is
+// traced.
+type tracedConn struct {
+	redis.Conn
+	ctx context.Context
+}
+
+func (c tracedConn) Do(command string, args ...any) (any, error) {
+	if command == "" || !trace.SpanFromContext(c.ctx).SpanContext().IsValid() {
+		return c.Conn.Do(command, args...)
+	}
+	_, span := tracer().Start(c.ctx, "redis "+command,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			semconv.DBSystemRedis,
+			semconv.DBOperation(command)))
+	r, err := c.Conn.Do(command, args...)
+	endSpan(span, err)
+	return r, err
+}
+
+// spanApp is the attribute of the application name.
+func spanApp(appname string) attribute.KeyValue {
+	return attribute.String("notifier.app", appname)
+}
+
+// spanChannel is the attribute of the channel name.
+func spanChannel(channame string) attribute.KeyValue {
+	return attribute.String("notifier.channel", channame)
+}
@@ -0,0 +1,111 @@
+package notifier
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// useTestTracer records the spans while the test runs.
+func useTestTracer(t *testing.T) *tracetest.SpanRecorder {
+	recorder := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	oldProvider, oldPropagator := otel.GetTracerProvider(), otel.GetTextMapPropagator()
+	otel.SetTracerProvider(tp)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	t.Cleanup(func() {
+		otel.SetTracerProvider(oldProvider)
+		otel.SetTextMapPropagator(oldPropagator)
+	})
+	return recorder
+}
+
+// endedSpans returns the ended spans by name.
+func endedSpans(recorder *tracetest.SpanRecorder) map[string]sdktrace.ReadOnlySpan {
+	spans := make(map[string]sdktrace.ReadOnlySpan)
+	for _, span := range recorder.Ended() {
+		spans[span.Name()] = span
+	}
+	return spans
+}
+
+func TestTracing(t *testing.T) {
+	recorder := useTestTracer(t)
+	s := initTest(t, DefaultConfig)
+	defer s.Finish()
+	router := newRouter(s)
+
+	// The trace of the caller is continued
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	req := httptest.NewRequest("POST", "/apps/testapp/events",
+		strings.NewReader(`{"name":"ev","channels":["chan0"],"data":"{}"}`))
+	req.Header.Set("traceparent", "00-"+traceID+"-00f067aa0ba902b7-01")
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	require.Equal(t, http.StatusOK, rr.Code)
+
+	spans := endedSpans(recorder)
+	handler := spans["POST /apps/{app}/events"]
+	require.NotNil(t, handler)
+	require.Equal(t, traceID, handler.SpanContext().TraceID().String())
+	require.Equal(t, trace.SpanKindServer, handler.SpanKind())
+
+	parents := map[string]string{
+		"broadcast":      "POST /apps/{app}/events",
+		"deliver":        "broadcast",
+		"lookup channel": "deliver",
+	}
+	for name, parent := range parents {
+		require.NotNil(t, spans[name], name)
+		require.Equal(t, spans[parent].SpanContext().SpanID(), spans[name].Parent().SpanID(), name)
+	}
+
+	// Probes aren't traced
+	_ = doRequest(t, router, "GET", "/healthz", "", http.StatusOK)
+	require.Nil(t, endedSpans(recorder)["GET /healthz"])
+}
+
+func TestEventRequestTrace(t *testing.T) {
+	useTestTracer(t)
+
+	// Not traced
+	er := &EventRequest{Name: "ev"}
+	er.injectTrace(context.Background())
+	require.Nil(t, er.TraceContext)
+
+	ctx, span := tracer().Start(context.Background(), "publish")
+	defer span.End()
+	er.injectTrace(ctx)
+	data, err := json.Marshal(er)
+	require.Nil(t, err)
+
+	var received EventRequest
+	require.Nil(t, json.Unmarshal(data, &received))
+	sc := trace.SpanContextFromContext(received.extractTrace(context.Background()))
+	require.True(t, sc.IsRemote())
+	require.Equal(t, span.SpanContext().TraceID(), sc.TraceID())
+	require.Equal(t, span.SpanContext().SpanID(), sc.SpanID())
+}
+
+func TestInitTracing(t *testing.T) {
+	useTestTracer(t) // restores the global provider
+
+	config := &Config{Tracing: ConfigTracing{Exporter: exporterStdout, SampleRatio: 0.5}}
+	shutdown, err := InitTracing(config)
+	require.Nil(t, err)
+	require.Nil(t, shutdown(context.Background()))
+
+	shutdown, err = InitTracing(&Config{})
+	require.Nil(t, err)
+	require.Nil(t, shutdown(context.Background()))
+}
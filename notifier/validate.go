@@ -0,0 +1,127 @@
+package notifier
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// Limits imposed by Pusher.
+// See https://pusher.com/docs/channels/library_auth_reference/rest-api/#post-event-trigger-an-event
+const (
+	maxChannelNameLength         = 200
+	maxEventNameLength           = 200
+	defaultMaxPayloadSize        = 10 * 1024 // bytes
+	defaultMaxChannelsPerTrigger = 100
+)
+
+var channelNamePattern = regexp.MustCompile(`^[-a-zA-Z0-9_=@,.;]+$`)
+
+// Event name prefixes reserved for the protocol.
+var reservedEventPrefixes = []string{"pusher:", "pusher_internal:"}
+
+func (ca *ConfigApplication) maxPayloadSize() int {
+	if ca.MaxPayloadSize > 0 {
+		return ca.MaxPayloadSize
+	}
+	return defaultMaxPayloadSize
+}
+
+// maxBodySize returns the limit of REST API request bodies, which
+// contain the payload escaped in JSON (up to 6 bytes per byte, e.g.
+// \u003c), the channel names and a few more fields.
+func (ca *ConfigApplication) maxBodySize() int64 {
+	return int64(6*ca.maxPayloadSize() +
+		ca.maxChannelsPerTrigger()*(maxChannelNameLength+3) + 4096)
+}
+
+func (ca *ConfigApplication) maxChannelsPerTrigger() int {
+	if ca.MaxChannelsPerTrigger > 0 {
+		return ca.MaxChannelsPerTrigger
+	}
+	return defaultMaxChannelsPerTrigger
+}
+
+func validateChannelName(name string) error {
+	if name == "" {
+		return pusherErr(http.StatusBadRequest, pusherCodeGeneric,
+			"Channel name is empty")
+	}
+	if len(name) > maxChannelNameLength {
+		return pusherErr(http.StatusBadRequest, pusherCodeGeneric,
+			fmt.Sprintf("Channel name is longer than %d characters", maxChannelNameLength))
+	}
+	if !channelNamePattern.MatchString(name) {
+		return pusherErr(http.StatusBadRequest, pusherCodeGeneric,
+			fmt.Sprintf("Invalid channel name `%s'", name))
+	}
+	return nil
+}
+
+// validPresenceData returns true if data is the channel_data of
+// a presence subscription, a JSON object with non-empty user_id.
+func validPresenceData(data string) bool {
+	var member struct {
+		UserID any `json:"user_id"`
+	}
+	if json.Unmarshal([]byte(data), &member) != nil {
+		return false
+	}
+	switch id := member.UserID.(type) {
+	case string:
+		return id != ""
+	case float64:
+		return true
+	}
+	return false
+}
+
+// validateEventName checks the name of the event triggered by
+// the server or the clients.
+func validateEventName(name string) error {
+	if name == "" {
+		return pusherErr(http.StatusBadRequest, pusherCodeGeneric,
+			"Event name is empty")
+	}
+	if len(name) > maxEventNameLength {
+		return pusherErr(http.StatusBadRequest, pusherCodeGeneric,
+			fmt.Sprintf("Event name is longer than %d characters", maxEventNameLength))
+	}
+	for _, prefix := range reservedEventPrefixes {
+		if strings.HasPrefix(name, prefix) {
+			return pusherErr(http.StatusBadRequest, pusherCodeGeneric,
+				fmt.Sprintf("Event name `%s' uses reserved prefix `%s'", name, prefix))
+		}
+	}
+	return nil
+}
+
+func validatePayload(ca *ConfigApplication, data string) error {
+	if len(data) > ca.maxPayloadSize() {
+		return pusherErr(http.StatusRequestEntityTooLarge, pusherCodeGeneric,
+			fmt.Sprintf("Event data is larger than %d bytes", ca.maxPayloadSize()))
+	}
+	return nil
+}
+
+// validateTrigger checks the event triggered via REST API.
+func validateTrigger(ca *ConfigApplication, ev *eventPayload) error {
+	if len(ev.Channels) == 0 {
+		return appErr(http.StatusBadRequest, "No channels specified")
+	}
+	if len(ev.Channels) > ca.maxChannelsPerTrigger() {
+		return appErr(http.StatusBadRequest,
+			fmt.Sprintf("Cannot trigger to more than %d channels", ca.maxChannelsPerTrigger()))
+	}
+	for _, cn := range ev.Channels {
+		if apperr := validateChannelName(cn); apperr != nil {
+			return apperr
+		}
+	}
+	if apperr := validateEventName(ev.Name); apperr != nil {
+		return apperr
+	}
+	return validatePayload(ca, ev.Data)
+}
@@ -0,0 +1,126 @@
+package notifier
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateChannelName(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		valid bool
+	}{
+		{"my-channel", true},
+		{"private-my_channel", true},
+		{"presence-a=b@c,d.e;f", true},
+		{strings.Repeat("a", maxChannelNameLength), true},
+		{strings.Repeat("a", maxChannelNameLength+1), false},
+		{"", false},
+		{"app/channels/x", false},
+		{"my channel", false},
+		{"#server-to-user-1", false},
+	} {
+		err := validateChannelName(tc.name)
+		require.Equal(t, tc.valid, err == nil, tc.name)
+	}
+}
+
+func TestValidateEventName(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		valid bool
+	}{
+		{"my-event", true},
+		{"client-event", true},
+		{strings.Repeat("a", maxEventNameLength), true},
+		{strings.Repeat("a", maxEventNameLength+1), false},
+		{"", false},
+		{"pusher:subscribe", false},
+		{"pusher_internal:subscription_succeeded", false},
+	} {
+		err := validateEventName(tc.name)
+		require.Equal(t, tc.valid, err == nil, tc.name)
+	}
+}
+
+func TestTriggerValidation(t *testing.T) {
+	s := initTest(t, DefaultConfig)
+	router := newRouter(s)
+
+	trigger := func(name string, channels []string, data string, expectedCode int) {
+		body, err := json.Marshal(eventPayload{Name: name, Channels: channels, Data: data})
+		require.Nil(t, err)
+		_ = doRequest(t, router, "POST", "/apps/testapp/events", string(body), expectedCode)
+	}
+
+	trigger("ev", []string{"chan0"}, "{}", http.StatusOK)
+	trigger("ev", []string{}, "{}", http.StatusBadRequest)
+	trigger("ev", []string{"chan/0"}, "{}", http.StatusBadRequest)
+	trigger("", []string{"chan0"}, "{}", http.StatusBadRequest)
+	trigger("pusher:ev", []string{"chan0"}, "{}", http.StatusBadRequest)
+	trigger("ev", []string{"chan0"}, strings.Repeat("x", defaultMaxPayloadSize), http.StatusOK)
+	trigger("ev", []string{"chan0"}, strings.Repeat("x", defaultMaxPayloadSize+1),
+		http.StatusRequestEntityTooLarge)
+
+	channels := make([]string, defaultMaxChannelsPerTrigger+1)
+	for i := range channels {
+		channels[i] = "chan" + strings.Repeat("x", i)
+	}
+	trigger("ev", channels[:defaultMaxChannelsPerTrigger], "{}", http.StatusOK)
+	trigger("ev", channels, "{}", http.StatusBadRequest)
+}
+
+func TestTriggerValidationConfigured(t *testing.T) {
+	config := &Config{
+		Applications: []ConfigApplication{
+			{
+				Name:                  "testapp",
+				Key:                   "1234567890",
+				Secret:                "abcdefghij",
+				MaxPayloadSize:        10,
+				MaxChannelsPerTrigger: 1,
+			},
+		},
+	}
+	s := NewSupervisor(config)
+	defer s.Finish()
+	router := newRouter(s)
+
+	_ = doRequest(t, router, "POST", "/apps/testapp/events",
+		`{"name":"ev","channels":["chan0"],"data":"0123456789"}`, http.StatusOK)
+	_ = doRequest(t, router, "POST", "/apps/testapp/events",
+		`{"name":"ev","channels":["chan0"],"data":"0123456789A"}`, http.StatusRequestEntityTooLarge)
+	_ = doRequest(t, router, "POST", "/apps/testapp/events",
+		`{"name":"ev","channels":["chan0","chan1"],"data":""}`, http.StatusBadRequest)
+}
+
+func TestSubscribeValidation(t *testing.T) {
+	s := initTest(t, DefaultConfig)
+	defer s.Finish()
+	server := httptest.NewServer(newRouter(s))
+	defer server.Close()
+
+	conn := dialTestSocket(t, server, "1234567890")
+	defer conn.Close()
+
+	err := conn.WriteJSON(map[string]any{
+		"event": "pusher:subscribe",
+		"data":  map[string]any{"channel": "app/channels/x"},
+	})
+	require.Nil(t, err)
+	ev := readTestEvent(t, conn)
+	require.Equal(t, "pusher:subscription_error", ev.Event)
+	require.Equal(t, "app/channels/x", ev.Channel)
+	var data SubscriptionErrorData
+	require.Nil(t, json.Unmarshal([]byte(ev.Data), &data))
+	require.Equal(t, "InvalidChannel", data.Type)
+	require.Equal(t, http.StatusBadRequest, data.Status)
+
+	// The connection remains usable
+	subscribeTestSocket(t, conn, "my-channel")
+}
@@ -29,7 +29,22 @@ type channelsResponse struct {
 }
 
 type channelsResponseItem struct {
-	UserCount int `json:"user_count"`
+	UserCount         *int `json:"user_count,omitempty"`
+	SubscriptionCount *int `json:"subscription_count,omitempty"`
+}
+
+type usageResponse struct {
+	Connections int         `json:"connections"`
+	Channels    int         `json:"channels"`
+	Limits      usageLimits `json:"limits"`
+}
+
+// Zero means unlimited.
+type usageLimits struct {
+	MaxConnections                int `json:"max-connections"`
+	MaxConnectionsPerIP           int `json:"max-connections-per-ip"`
+	MaxSubscriptionsPerConnection int `json:"max-subscriptions-per-connection"`
+	MaxChannels                   int `json:"max-channels"`
 }
 
 type userResponse struct {
@@ -44,13 +59,81 @@ type eventPayload struct {
 	Name     string   `json:"name"`
 	Channels []string `json:"channels"`
 	Data     string   `json:"data"`
-	SocketID *string  `json:socket_id,omitempty"`
+	SocketID *string  `json:"socket_id,omitempty"`
+	Info     string   `json:"info,omitempty"`
+}
+
+type triggerResponse struct {
+	Channels map[string]channelsResponseItem `json:"channels,omitempty"`
 }
 
 type getChannelResponse struct {
-	Occupied          bool `json:"occupied,omitempty"`
-	UserCount         int  `json:"user_count,omitempty"`
-	SubscriptionCount int  `json:"subscription_count,omitempty"`
+	Occupied          bool `json:"occupied"`
+	UserCount         *int `json:"user_count,omitempty"`
+	SubscriptionCount *int `json:"subscription_count,omitempty"`
+}
+
+// channelInfo is the set of attributes requested by `info' parameter.
+type channelInfo struct {
+	UserCount         bool
+	SubscriptionCount bool
+	Cache             bool
+}
+
+// parseChannelInfo parses comma-separated `info' attributes.
+// Unknown attributes are ignored.
+func parseChannelInfo(info string) channelInfo {
+	var ci channelInfo
+	for _, attr := range strings.Split(info, ",") {
+		switch strings.TrimSpace(attr) {
+		case "user_count":
+			ci.UserCount = true
+		case "subscription_count":
+			ci.SubscriptionCount = true
+		case "cache":
+			ci.Cache = true
+		}
+	}
+	return ci
+}
+
+// validateFor checks if the requested attributes are available for
+// channels with the given name (or prefix).  As Pusher does, user_count
+// is only available for presence channels, and cache is only
+// available for cache channels.
+func (ci channelInfo) validateFor(name string) error {
+	if ci.UserCount && !strings.HasPrefix(name, "presence-") {
+		return appErr(400, "user_count may only be requested for presence channels")
+	}
+	if ci.Cache && !isCacheChannel(name) {
+		return appErr(400, "cache may only be requested for cache channels")
+	}
+	return nil
+}
+
+func isCacheChannel(name string) bool {
+	return strings.HasPrefix(name, "cache-") ||
+		strings.HasPrefix(name, "private-cache-") ||
+		strings.HasPrefix(name, "private-encrypted-cache-") ||
+		strings.HasPrefix(name, "presence-cache-")
+}
+
+// item returns the requested attributes of the channel.
+// Channel can be nil, which is treated as an empty channel.
+func (ci channelInfo) item(ch *Channel) channelsResponseItem {
+	var userCount, subscriptionCount int
+	if ch != nil {
+		userCount = ch.UserCount()
+		subscriptionCount = ch.SubscriptionCount()
+	}
+	item := channelsResponseItem{}
+	if ci.UserCount {
+		item.UserCount = &userCount
+	}
+	if ci.SubscriptionCount {
+		item.SubscriptionCount = &subscriptionCount
+	}
+	return item
 }
 
 func returnJSON(w http.ResponseWriter, val any) {
@@ -91,13 +174,24 @@ func returnErr(s *Supervisor, w http.ResponseWriter, err error) {
 
 func (s *Supervisor) listApplications(w http.ResponseWriter, r *http.Request) {
 	apps := make([]string, 0)
-	for _, a := range s.Apps {
+	for _, a := range s.apps() {
 		apps = append(apps, a.Name)
 	}
 	returnJSON(w, listAppsResponse{Applications: apps})
 }
 
 func (s *Supervisor) appChannels(w http.ResponseWriter, r *http.Request) {
+	prefix := r.URL.Query().Get("filter_by_prefix")
+	info := parseChannelInfo(r.URL.Query().Get("info"))
+	if info.Cache {
+		returnErr(s, w, appErr(400, "cache may only be requested for a single channel"))
+		return
+	}
+	if apperr := info.validateFor(prefix); apperr != nil {
+		returnErr(s, w, apperr)
+		return
+	}
+
 	channels, apperr := s.GetChannels(mux.Vars(r)["app"])
 	if apperr != nil {
 		returnErr(s, w, apperr)
@@ -105,28 +199,59 @@ func (s *Supervisor) appChannels(w http.ResponseWriter, r *http.Request) {
 	}
 	cl := channelsResponse{Channels: make(map[string]channelsResponseItem)}
 	for _, c := range channels {
-		cl.Channels[c.Name] =
-			channelsResponseItem{UserCount: c.UserCount()}
+		if !strings.HasPrefix(c.Name, prefix) {
+			continue
+		}
+		cl.Channels[c.Name] = info.item(c)
 	}
 	returnJSON(w, cl)
 }
 
 func (s *Supervisor) getChannel(w http.ResponseWriter, r *http.Request) {
-	ch, apperr := s.GetOrCreateChannel(mux.Vars(r)["app"],
+	info := parseChannelInfo(r.URL.Query().Get("info"))
+	if apperr := info.validateFor(mux.Vars(r)["chan"]); apperr != nil {
+		returnErr(s, w, apperr)
+		return
+	}
+
+	ch, apperr := s.LookupChannel(mux.Vars(r)["app"],
 		mux.Vars(r)["chan"])
 	if apperr != nil {
 		returnErr(s, w, apperr)
 		return
 	}
-	resp := &getChannelResponse{Occupied: ch.UserCount() > 0, SubscriptionCount: ch.SubscriptionCount()}
-	if strings.HasPrefix(ch.Name, "presence-") {
-		resp.UserCount = ch.UserCount()
+	// NB: Cache channels are not supported, so there's never a cached
+	// event to report for info=cache.
+	item := info.item(ch)
+	resp := &getChannelResponse{
+		Occupied:          ch.UserCount() > 0,
+		UserCount:         item.UserCount,
+		SubscriptionCount: item.SubscriptionCount,
+	}
+	returnJSON(w, resp)
+}
+
+func (s *Supervisor) appUsage(w http.ResponseWriter, r *http.Request) {
+	appname := mux.Vars(r)["app"]
+	conns, chans, apperr := s.GetUsage(appname)
+	if apperr != nil {
+		returnErr(s, w, apperr)
+		return
+	}
+	resp := usageResponse{Connections: conns, Channels: chans}
+	if ca := s.config().GetApp(appname); ca != nil {
+		resp.Limits = usageLimits{
+			MaxConnections:                ca.MaxConnections,
+			MaxConnectionsPerIP:           ca.MaxConnectionsPerIP,
+			MaxSubscriptionsPerConnection: ca.MaxSubscriptionsPerConnection,
+			MaxChannels:                   ca.MaxChannels,
+		}
 	}
 	returnJSON(w, resp)
 }
 
 func (s *Supervisor) getChannelUsers(w http.ResponseWriter, r *http.Request) {
-	ch, apperr := s.GetOrCreateChannel(mux.Vars(r)["app"],
+	ch, apperr := s.LookupChannel(mux.Vars(r)["app"],
 		mux.Vars(r)["chan"])
 	if apperr != nil {
 		returnErr(s, w, apperr)
@@ -145,7 +270,7 @@ func (s *Supervisor) trigger(w http.ResponseWriter, r *http.Request) {
 	var ev eventPayload
 	err := json.NewDecoder(r.Body).Decode(&ev)
 	if err != nil {
-		returnErr(s, w, wrapErr(400, err))
+		returnErr(s, w, bodyErr(err))
 		return
 	}
 
@@ -155,10 +280,35 @@ func (s *Supervisor) trigger(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	ca := s.config().GetApp(a.Name)
+	if ca == nil {
+		returnErr(s, w, appErr(404, "No such application"))
+		return
+	}
+	if apperr := validateTrigger(ca, &ev); apperr != nil {
+		returnErr(s, w, apperr)
+		return
+	}
+
+	info := parseChannelInfo(ev.Info)
+	if info.Cache {
+		returnErr(s, w, appErr(400, "cache may not be requested on trigger"))
+		return
+	}
+	for _, cn := range ev.Channels {
+		if apperr := info.validateFor(cn); apperr != nil {
+			returnErr(s, w, apperr)
+			return
+		}
+	}
+
 	e := &Event{Name: ev.Name, Data: ev.Data}
 
 	for _, cn := range ev.Channels {
-		apperr := s.Broadcast(a, e, cn)
+		eventsTriggered.WithLabelValues(a.Name, "api").Inc()
+		s.emitDebug(&debugEvent{Type: debugAPIMessage, App: a.Name,
+			Channel: cn, Event: e.Name, Data: e.Data})
+		apperr := s.Broadcast(r.Context(), a, e, cn)
 		if apperr != nil {
 			s.logger.Errorw("Broadcast error",
 				"app", a.Name,
@@ -166,5 +316,18 @@ func (s *Supervisor) trigger(w http.ResponseWriter, r *http.Request) {
 				"error", apperr)
 		}
 	}
-	returnJSON(w, nil)
+
+	resp := triggerResponse{}
+	if ev.Info != "" {
+		resp.Channels = make(map[string]channelsResponseItem)
+		for _, cn := range ev.Channels {
+			ch, apperr := s.LookupChannel(a.Name, cn)
+			if apperr != nil {
+				returnErr(s, w, apperr)
+				return
+			}
+			resp.Channels[cn] = info.item(ch)
+		}
+	}
+	returnJSON(w, resp)
 }
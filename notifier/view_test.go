@@ -24,8 +24,6 @@ func initTest(t *testing.T, kind int) *Supervisor {
 	switch kind {
 	case SecureConfig:
 		path = "../config/sample-secure.json"
-	case RedisConfig:
-		path = "../config/sample-redis-test.json"
 	}
 	config, err := ReadConfigFile(path)
 	require.Nil(t, err)
@@ -87,7 +85,7 @@ func TestGetChannel(t *testing.T) {
 	router := newRouter(s)
 
 	rr := doRequest(t, router, "GET", "/apps/testapp/channels/testchan", "", http.StatusOK)
-	require.Equal(t, J(`{}`), jsonBody(t, rr))
+	require.Equal(t, J(`{"occupied": false}`), jsonBody(t, rr))
 
 	c, err := s.GetOrCreateChannel("testapp", "testchan")
 	require.Nil(t, err)
@@ -95,13 +93,79 @@ func TestGetChannel(t *testing.T) {
 	c.SubscribeUser(u.ID)
 
 	rr = doRequest(t, router, "GET", "/apps/testapp/channels/testchan", "", http.StatusOK)
+	require.Equal(t, J(`{"occupied": true}`), jsonBody(t, rr))
+
+	rr = doRequest(t, router, "GET", "/apps/testapp/channels/testchan?info=subscription_count", "", http.StatusOK)
 	require.Equal(t, J(`{"occupied": true, "subscription_count": 1}`), jsonBody(t, rr))
 
+	_ = doRequest(t, router, "GET", "/apps/testapp/channels/testchan?info=user_count", "", http.StatusBadRequest)
+	_ = doRequest(t, router, "GET", "/apps/testapp/channels/testchan?info=cache", "", http.StatusBadRequest)
+
 	c, err = s.GetOrCreateChannel("testapp", "presence-testchan")
 	require.Nil(t, err)
 	c.SubscribeUser(u.ID)
 	c.SubscribeUser(u.ID)
 
-	rr = doRequest(t, router, "GET", "/apps/testapp/channels/presence-testchan", "", http.StatusOK)
+	rr = doRequest(t, router, "GET", "/apps/testapp/channels/presence-testchan?info=user_count,subscription_count", "", http.StatusOK)
 	require.Equal(t, J(`{"occupied": true, "subscription_count": 2, "user_count": 1}`), jsonBody(t, rr))
+
+	rr = doRequest(t, router, "GET", "/apps/testapp/channels/cache-testchan?info=cache", "", http.StatusOK)
+	require.Equal(t, J(`{"occupied": false}`), jsonBody(t, rr))
+}
+
+func TestListChannelsInfo(t *testing.T) {
+	s := initTest(t, DefaultConfig)
+	router := newRouter(s)
+
+	for _, cn := range []string{"chan0", "presence-chan1", "presence-chan2"} {
+		c, err := s.GetOrCreateChannel("testapp", cn)
+		require.Nil(t, err)
+		c.SubscribeUser(0)
+		c.SubscribeUser(1)
+	}
+
+	rr := doRequest(t, router, "GET", "/apps/testapp/channels", "", http.StatusOK)
+	require.Equal(t, J(`{"channels":{"chan0":{}, "presence-chan1":{}, "presence-chan2":{}}}`),
+		jsonBody(t, rr))
+
+	rr = doRequest(t, router, "GET", "/apps/testapp/channels?filter_by_prefix=presence-&info=user_count", "", http.StatusOK)
+	require.Equal(t, J(`{"channels":{`+
+		`"presence-chan1":{"user_count":2},`+
+		`"presence-chan2":{"user_count":2}}}`),
+		jsonBody(t, rr))
+
+	rr = doRequest(t, router, "GET", "/apps/testapp/channels?filter_by_prefix=chan&info=subscription_count", "", http.StatusOK)
+	require.Equal(t, J(`{"channels":{"chan0":{"subscription_count":2}}}`),
+		jsonBody(t, rr))
+
+	_ = doRequest(t, router, "GET", "/apps/testapp/channels?info=user_count", "", http.StatusBadRequest)
+	_ = doRequest(t, router, "GET", "/apps/testapp/channels?filter_by_prefix=chan&info=user_count", "", http.StatusBadRequest)
+}
+
+func TestTriggerInfo(t *testing.T) {
+	s := initTest(t, DefaultConfig)
+	router := newRouter(s)
+
+	c, err := s.GetOrCreateChannel("testapp", "presence-chan1")
+	require.Nil(t, err)
+	c.SubscribeUser(0)
+	c.SubscribeUser(0)
+
+	rr := doRequest(t, router, "POST", "/apps/testapp/events",
+		`{"name":"ev","channels":["chan0"],"data":"{}"}`, http.StatusOK)
+	require.Equal(t, J(`{}`), jsonBody(t, rr))
+
+	rr = doRequest(t, router, "POST", "/apps/testapp/events",
+		`{"name":"ev","channels":["chan0"],"data":"{}","info":"subscription_count"}`, http.StatusOK)
+	require.Equal(t, J(`{"channels":{"chan0":{"subscription_count":0}}}`), jsonBody(t, rr))
+
+	_ = doRequest(t, router, "POST", "/apps/testapp/events",
+		`{"name":"ev","channels":["chan0","presence-chan1"],"data":"{}","info":"user_count"}`,
+		http.StatusBadRequest)
+
+	rr = doRequest(t, router, "POST", "/apps/testapp/events",
+		`{"name":"ev","channels":["presence-chan1"],"data":"{}","info":"user_count,subscription_count"}`,
+		http.StatusOK)
+	require.Equal(t, J(`{"channels":{"presence-chan1":{"user_count":1,"subscription_count":2}}}`),
+		jsonBody(t, rr))
 }
@@ -0,0 +1,70 @@
+package notifier
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// NB: JSON mapping of those structures must match pusher webhook format
+// See https://pusher.com/docs/channels/server_api/webhooks
+
+type webhookBody struct {
+	TimeMs int64          `json:"time_ms"`
+	Events []webhookEvent `json:"events"`
+}
+
+type webhookEvent struct {
+	Name              string `json:"name"`
+	Channel           string `json:"channel"`
+	SubscriptionCount *int   `json:"subscription_count,omitempty"`
+}
+
+var webhookClient = &http.Client{Timeout: 10 * time.Second}
+
+// webhookSignature returns HMAC SHA256 hex digest of the body signed
+// with the application secret, sent as X-Pusher-Signature.
+func webhookSignature(secret string, body []byte) string {
+	digest := hmac.New(sha256.New, []byte(secret))
+	_, _ = digest.Write(body)
+	return hex.EncodeToString(digest.Sum(nil))
+}
+
+// sendWebhook posts the events to the url asynchronously.
+// Failures are logged but not retried.
+func (s *Supervisor) sendWebhook(ca *ConfigApplication, url string, events []webhookEvent) {
+	body, err := json.Marshal(webhookBody{
+		TimeMs: time.Now().UnixNano() / int64(time.Millisecond),
+		Events: events,
+	})
+	if err != nil {
+		s.logger.Errorw("webhook encoding error", "error", err)
+		return
+	}
+	key := ca.Key
+	signature := webhookSignature(ca.Secret, body)
+
+	go func() {
+		req, err := http.NewRequest("POST", url, bytes.NewReader(body))
+		if err != nil {
+			s.logger.Errorw("webhook request error", "url", url, "error", err)
+			return
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Pusher-Key", key)
+		req.Header.Set("X-Pusher-Signature", signature)
+		resp, err := webhookClient.Do(req)
+		if err != nil {
+			s.logger.Infow("webhook delivery failed", "url", url, "error", err)
+			return
+		}
+		_ = resp.Body.Close()
+		if resp.StatusCode/100 != 2 {
+			s.logger.Infow("webhook rejected", "url", url, "status", resp.StatusCode)
+		}
+	}()
+}
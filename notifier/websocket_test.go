@@ -0,0 +1,246 @@
+package notifier
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/sony/micro-notifier/notifier/pushertest"
+	"github.com/stretchr/testify/require"
+)
+
+const testClientQuery = "?protocol=7&client=go-test&version=1.0.0"
+
+func dialTestSocket(t *testing.T, server *httptest.Server, key string) *websocket.Conn {
+	return dialTestSocketQuery(t, server, key, testClientQuery)
+}
+
+func dialTestSocketQuery(t *testing.T, server *httptest.Server, key string, query string) *websocket.Conn {
+	conn, _ := dialTestSocketID(t, server, key, query)
+	return conn
+}
+
+// dialTestSocketID also returns the socket ID of the connection.
+func dialTestSocketID(t *testing.T, server *httptest.Server, key string, query string) (*websocket.Conn, string) {
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/app/" + key + query
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	require.Nil(t, err)
+
+	ev := readTestEvent(t, conn)
+	require.Equal(t, "pusher:connection_established", ev.Event)
+	var data ConnectionEstablishedData
+	require.Nil(t, json.Unmarshal([]byte(ev.Data), &data))
+	return conn, data.SocketID
+}
+
+func readTestEvent(t *testing.T, conn *websocket.Conn) PusherEvent {
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var ev PusherEvent
+	err := conn.ReadJSON(&ev)
+	require.Nil(t, err)
+	return ev
+}
+
+func subscribeTestSocket(t *testing.T, conn *websocket.Conn, channel string) {
+	err := conn.WriteJSON(map[string]any{
+		"event": "pusher:subscribe",
+		"data":  map[string]any{"channel": channel},
+	})
+	require.Nil(t, err)
+	ev := readTestEvent(t, conn)
+	require.Equal(t, "pusher_internal:subscription_succeeded", ev.Event)
+}
+
+func readTestError(t *testing.T, conn *websocket.Conn) PusherErrorData {
+	ev := readTestEvent(t, conn)
+	require.Equal(t, "pusher:error", ev.Event)
+	var data PusherErrorData
+	require.Nil(t, json.Unmarshal([]byte(ev.Data), &data))
+	return data
+}
+
+func TestSocketUnknownKey(t *testing.T) {
+	s := initTest(t, DefaultConfig)
+	defer s.Finish()
+	server := httptest.NewServer(newRouter(s))
+	defer server.Close()
+
+	d := &pushertest.Dialer{Key: "nosuchkey"}
+	data := d.DialError(t, server.URL)
+	require.Equal(t, pusherCodeAppNotFound, data.Code)
+}
+
+func requireTestClose(t *testing.T, conn *websocket.Conn, code int) {
+	_, _, err := conn.ReadMessage()
+	closeErr, ok := err.(*websocket.CloseError)
+	require.True(t, ok, err)
+	require.Equal(t, code, closeErr.Code)
+}
+
+func TestSocketProtocolNegotiation(t *testing.T) {
+	s := initTest(t, DefaultConfig)
+	defer s.Finish()
+	server := httptest.NewServer(newRouter(s))
+	defer server.Close()
+
+	for _, tc := range []struct {
+		query string
+		code  int
+	}{
+		{"?protocol=seven", pusherCodeInvalidVersion},
+		{"?protocol=6", pusherCodeUnsupportedProtocol},
+		{"?protocol=9", pusherCodeUnsupportedProtocol},
+	} {
+		url := "ws" + strings.TrimPrefix(server.URL, "http") + "/app/1234567890" + tc.query
+		conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+		require.Nil(t, err)
+		data := readTestError(t, conn)
+		require.Equal(t, tc.code, data.Code, tc.query)
+		requireTestClose(t, conn, tc.code)
+		conn.Close()
+	}
+
+	conn := dialTestSocketQuery(t, server, "1234567890", "?protocol=8&client=js&version=8.3.0")
+	defer conn.Close()
+
+	var u *User
+	a, _ := s.GetApp("testapp")
+	for e := range a.Users.Iterator().C {
+		u = e.(*User)
+	}
+	require.NotNil(t, u)
+	require.Equal(t, ClientInfo{Protocol: 8, Client: "js", Version: "8.3.0"}, u.Client)
+
+	// Protocol 8 receives error data as JSON objects
+	require.Nil(t, conn.WriteJSON(map[string]any{"event": "no-such-event"}))
+	var ev struct {
+		Event string          `json:"event"`
+		Data  PusherErrorData `json:"data"`
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	require.Nil(t, conn.ReadJSON(&ev))
+	require.Equal(t, "pusher:error", ev.Event)
+	require.Equal(t, pusherCodeGeneric, ev.Data.Code)
+}
+
+func TestSocketDefaultProtocol(t *testing.T) {
+	s := initTest(t, DefaultConfig)
+	defer s.Finish()
+	server := httptest.NewServer(newRouter(s))
+	defer server.Close()
+
+	// As the clients connecting before the negotiation was introduced
+	conn := dialTestSocketQuery(t, server, "1234567890", "")
+	defer conn.Close()
+
+	var u *User
+	a, _ := s.GetApp("testapp")
+	for e := range a.Users.Iterator().C {
+		u = e.(*User)
+	}
+	require.NotNil(t, u)
+	require.Equal(t, ClientInfo{Protocol: 7}, u.Client)
+	subscribeTestSocket(t, conn, "my-channel")
+}
+
+func TestSocketErrors(t *testing.T) {
+	s := initTest(t, DefaultConfig)
+	defer s.Finish()
+	server := httptest.NewServer(newRouter(s))
+	defer server.Close()
+
+	conn := dialTestSocket(t, server, "1234567890")
+	defer conn.Close()
+
+	// Malformed messages don't close the connection
+	require.Nil(t, conn.WriteMessage(websocket.TextMessage, []byte("{not a json")))
+	data := readTestError(t, conn)
+	require.Equal(t, pusherCodeGeneric, data.Code)
+
+	require.Nil(t, conn.WriteJSON(map[string]any{"event": "pusher:subscribe", "data": 1}))
+	data = readTestError(t, conn)
+	require.Equal(t, pusherCodeGeneric, data.Code)
+
+	require.Nil(t, conn.WriteJSON(map[string]any{"event": "no-such-event"}))
+	data = readTestError(t, conn)
+	require.Equal(t, pusherCodeGeneric, data.Code)
+
+	// Unauthorized subscription
+	require.Nil(t, conn.WriteJSON(map[string]any{
+		"event": "pusher:subscribe",
+		"data":  map[string]any{"channel": "private-chan", "auth": "1234567890:bad"},
+	}))
+	ev := readTestEvent(t, conn)
+	require.Equal(t, "pusher:subscription_error", ev.Event)
+	require.Equal(t, "private-chan", ev.Channel)
+	var serr SubscriptionErrorData
+	require.Nil(t, json.Unmarshal([]byte(ev.Data), &serr))
+	require.Equal(t, "AuthError", serr.Type)
+	require.Equal(t, http.StatusUnauthorized, serr.Status)
+
+	subscribeTestSocket(t, conn, "my-channel")
+}
+
+func TestSocketConnectionQuota(t *testing.T) {
+	s := initTest(t, DefaultConfig)
+	defer s.Finish()
+	s.Config.Applications[0].MaxConnectionsPerIP = 1
+	server := httptest.NewServer(newRouter(s))
+	defer server.Close()
+
+	conn := dialTestSocket(t, server, "1234567890")
+	defer conn.Close()
+
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/app/1234567890" + testClientQuery
+	conn2, _, err := websocket.DefaultDialer.Dial(url, nil)
+	require.Nil(t, err)
+	defer conn2.Close()
+	data := readTestError(t, conn2)
+	require.Equal(t, pusherCodeOverQuota, data.Code)
+	requireTestClose(t, conn2, pusherCodeOverQuota)
+
+	// The first connection is intact
+	subscribeTestSocket(t, conn, "my-channel")
+}
+
+func TestSocketPresenceAuth(t *testing.T) {
+	s := initTest(t, DefaultConfig)
+	defer s.Finish()
+	s.Config.Applications[0].ClientEvents = true
+	server := httptest.NewServer(newRouter(s))
+	defer server.Close()
+
+	d := &pushertest.Dialer{Key: "1234567890", Secret: "abcdefghij"}
+	c := d.Dial(t, server.URL)
+	defer c.Close()
+	channel := "presence-my-channel"
+	userData := `{"user_id":"alice"}`
+
+	serr := c.ExpectSubscriptionError(channel, "")
+	require.Equal(t, "AuthError", serr.Type)
+	require.Equal(t, http.StatusUnauthorized, serr.Status)
+	// Signed as a private channel, without channel_data
+	serr = c.ExpectSubscriptionError(channel,
+		pushertest.Sign("1234567890", "abcdefghij", c.SocketID, channel))
+	require.Equal(t, "AuthError", serr.Type)
+
+	auth := pushertest.SignPresence("1234567890", "abcdefghij", c.SocketID, channel, userData)
+	for _, data := range []string{`{"user_id":"mallory"}`, `{"user_id":""}`, `"alice"`} {
+		ev := c.SubscribePresence(channel, auth, data)
+		require.Equal(t, "pusher:subscription_error", ev.Event, data)
+	}
+	// Client events require the subscription
+	c.Send("client-my-event", channel, map[string]string{})
+	require.Contains(t, c.ExpectError().Message, "not subscribed")
+
+	ev := c.SubscribePresence(channel, auth, userData)
+	require.Equal(t, "pusher_internal:subscription_succeeded", ev.Event)
+	numericID := `{"user_id":42,"user_info":{"name":"bob"}}`
+	ev = c.SubscribePresence("presence-other",
+		pushertest.SignPresence("1234567890", "abcdefghij", c.SocketID, "presence-other", numericID), numericID)
+	require.Equal(t, "pusher_internal:subscription_succeeded", ev.Event)
+}
@@ -0,0 +1,370 @@
+// notifierctl is a command-line client of the notifier.  It reads the
+// credentials of the applications from the config file of the notifier.
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sony/micro-notifier/notifier"
+)
+
+const usage = `usage: notifierctl <command> [flags] [arguments]
+
+Commands:
+  trigger -channel <channel> -event <name> [-data <data>]
+                         trigger an event; -data - reads the data from stdin
+  apps                   list the applications
+  channels [-prefix <prefix>] [-info <attributes>]
+                         list the occupied channels
+  channel [-info <attributes>] <channel>
+                         show the state of the channel
+  users <channel>        list the users of the presence channel
+  tail <channel>...      subscribe to the channels and print the events
+  check <config file>    validate the config file
+  sign-request [-body <body>] <method> <path>
+                         print the signed path of the REST API request
+  sign-channel -socket-id <socket ID> [-channel-data <JSON>] <channel>
+                         print the auth to subscribe to the private or presence channel
+
+Run 'notifierctl <command> -h' for the flags of the command.
+`
+
+// Output of the commands; replaced in tests
+var (
+	stdout io.Writer = os.Stdout
+	stderr io.Writer = os.Stderr
+)
+
+// command is the common settings of the commands talking to the server.
+type command struct {
+	flags      *flag.FlagSet
+	configFile string
+	appName    string
+	serverURL  string
+	insecure   bool
+
+	app    *notifier.ConfigApplication
+	config *notifier.Config
+}
+
+func newCommand(name string) *command {
+	cmd := &command{flags: flag.NewFlagSet(name, flag.ExitOnError)}
+	cmd.flags.StringVar(&cmd.configFile, "c", "", "Config file of the notifier")
+	cmd.flags.StringVar(&cmd.appName, "app", "", "Application name [default: the first one in the config]")
+	cmd.flags.StringVar(&cmd.serverURL, "url", "",
+		"URL of the server, e.g. https://notifier.example.com [default: the listener in the config]")
+	cmd.flags.BoolVar(&cmd.insecure, "insecure", false, "Skip verification of the server certificate")
+	return cmd
+}
+
+// parse parses the arguments and reads the config file.
+func (cmd *command) parse(args []string) error {
+	_ = cmd.flags.Parse(args)
+	config, err := notifier.ReadConfigFile(cmd.configFile)
+	if err != nil {
+		return fmt.Errorf("cannot read config file: %w", err)
+	}
+	cmd.config = config
+	if cmd.appName == "" {
+		if len(config.Applications) == 0 {
+			return errors.New("no applications in the config")
+		}
+		cmd.appName = config.Applications[0].Name
+	}
+	cmd.app = config.GetApp(cmd.appName)
+	if cmd.app == nil {
+		return fmt.Errorf("no such application in the config: %s", cmd.appName)
+	}
+	return nil
+}
+
+// baseURL returns the URL of the server, given by -url or the listener
+// in the config.  The REST API may be served on a separate listener.
+func (cmd *command) baseURL(api bool) (*url.URL, error) {
+	if cmd.serverURL != "" {
+		return url.Parse(cmd.serverURL)
+	}
+	c := cmd.config
+	host, port, socket, secure := c.Host, c.Port, c.Socket, c.Certificate != ""
+	if l := c.Listeners.API; api && (l.Port != 0 || l.Socket != "") {
+		host, port, socket, secure = l.Host, l.Port, l.Socket, l.Certificate != ""
+	}
+	if socket != "" {
+		return nil, errors.New("the server listens on a Unix domain socket; give -url")
+	}
+	if host == "" || net.ParseIP(host).IsUnspecified() {
+		host = "localhost"
+	}
+	if port == 0 {
+		port = notifier.DefaultPort
+	}
+	scheme := "http"
+	if secure {
+		scheme = "https"
+	}
+	return &url.URL{Scheme: scheme, Host: net.JoinHostPort(host, strconv.Itoa(port))}, nil
+}
+
+func (cmd *command) tlsConfig() *tls.Config {
+	return &tls.Config{InsecureSkipVerify: cmd.insecure}
+}
+
+// appPath returns the path of the REST API of the application.
+func (cmd *command) appPath(elems ...string) string {
+	return "/apps/" + strings.Join(append([]string{cmd.app.Name}, elems...), "/")
+}
+
+// request sends the signed REST API request and prints the response.
+func (cmd *command) request(method string, path string, query url.Values, body []byte) error {
+	base, err := cmd.baseURL(true)
+	if err != nil {
+		return err
+	}
+	u := *base
+	u.Path = path
+	u.RawQuery = notifier.SignRESTQuery(method, path, query, body,
+		cmd.app.Key, cmd.app.Secret, time.Now()).Encode()
+
+	req, err := http.NewRequest(method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	client := &http.Client{
+		Timeout:   30 * time.Second,
+		Transport: &http.Transport{TLSClientConfig: cmd.tlsConfig()},
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(data)))
+	}
+	return printJSON(data)
+}
+
+// printJSON prints the JSON indented.
+func printJSON(data []byte) error {
+	var out bytes.Buffer
+	if err := json.Indent(&out, data, "", "  "); err != nil {
+		_, err = stdout.Write(data)
+		return err
+	}
+	out.WriteByte('\n')
+	_, err := out.WriteTo(stdout)
+	return err
+}
+
+// stringList is a flag given multiple times.
+type stringList []string
+
+func (sl *stringList) String() string {
+	return strings.Join(*sl, ",")
+}
+
+func (sl *stringList) Set(v string) error {
+	*sl = append(*sl, v)
+	return nil
+}
+
+func runTrigger(args []string) error {
+	cmd := newCommand("trigger")
+	var channels stringList
+	cmd.flags.Var(&channels, "channel", "Channel to trigger the event on; may be repeated")
+	event := cmd.flags.String("event", "", "Event name")
+	data := cmd.flags.String("data", "{}", "Event data, or - to read it from stdin")
+	socketID := cmd.flags.String("socket-id", "", "Socket ID to exclude from the recipients")
+	if err := cmd.parse(args); err != nil {
+		return err
+	}
+	if len(channels) == 0 || *event == "" {
+		return errors.New("-channel and -event are required")
+	}
+	payload := map[string]any{"name": *event, "channels": channels, "data": *data}
+	if *data == "-" {
+		in, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			return err
+		}
+		payload["data"] = strings.TrimSuffix(string(in), "\n")
+	}
+	if *socketID != "" {
+		payload["socket_id"] = *socketID
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	return cmd.request("POST", cmd.appPath("events"), nil, body)
+}
+
+func runApps(args []string) error {
+	cmd := newCommand("apps")
+	if err := cmd.parse(args); err != nil {
+		return err
+	}
+	return cmd.request("GET", "/apps", nil, nil)
+}
+
+func runChannels(args []string) error {
+	cmd := newCommand("channels")
+	prefix := cmd.flags.String("prefix", "", "Only the channels starting with the prefix")
+	info := cmd.flags.String("info", "", "Attributes to return, e.g. subscription_count")
+	if err := cmd.parse(args); err != nil {
+		return err
+	}
+	query := url.Values{}
+	if *prefix != "" {
+		query.Set("filter_by_prefix", *prefix)
+	}
+	if *info != "" {
+		query.Set("info", *info)
+	}
+	return cmd.request("GET", cmd.appPath("channels"), query, nil)
+}
+
+func runChannel(args []string) error {
+	cmd := newCommand("channel")
+	info := cmd.flags.String("info", "", "Attributes to return, e.g. user_count,subscription_count")
+	if err := cmd.parse(args); err != nil {
+		return err
+	}
+	if cmd.flags.NArg() != 1 {
+		return errors.New("a channel is required")
+	}
+	query := url.Values{}
+	if *info != "" {
+		query.Set("info", *info)
+	}
+	return cmd.request("GET", cmd.appPath("channels", cmd.flags.Arg(0)), query, nil)
+}
+
+func runUsers(args []string) error {
+	cmd := newCommand("users")
+	if err := cmd.parse(args); err != nil {
+		return err
+	}
+	if cmd.flags.NArg() != 1 {
+		return errors.New("a channel is required")
+	}
+	return cmd.request("GET", cmd.appPath("channels", cmd.flags.Arg(0), "users"), nil, nil)
+}
+
+func runCheck(args []string) error {
+	flags := flag.NewFlagSet("check", flag.ExitOnError)
+	_ = flags.Parse(args)
+	if flags.NArg() != 1 {
+		return errors.New("a config file is required")
+	}
+	if _, err := notifier.ReadConfigFile(flags.Arg(0)); err != nil {
+		return err
+	}
+	fmt.Fprintf(stdout, "%s: OK\n", flags.Arg(0))
+	return nil
+}
+
+func runSignRequest(args []string) error {
+	cmd := newCommand("sign-request")
+	body := cmd.flags.String("body", "", "Body of the request")
+	if err := cmd.parse(args); err != nil {
+		return err
+	}
+	if cmd.flags.NArg() != 2 {
+		return errors.New("a method and a path are required")
+	}
+	method := strings.ToUpper(cmd.flags.Arg(0))
+	u, err := url.Parse(cmd.flags.Arg(1))
+	if err != nil {
+		return err
+	}
+	var b []byte
+	if *body != "" {
+		b = []byte(*body)
+	}
+	query := notifier.SignRESTQuery(method, u.Path, u.Query(), b, cmd.app.Key, cmd.app.Secret, time.Now())
+	fmt.Fprintln(stdout, u.Path+"?"+query.Encode())
+	return nil
+}
+
+func runSignChannel(args []string) error {
+	cmd := newCommand("sign-channel")
+	socketID := cmd.flags.String("socket-id", "", "Socket ID of the connection")
+	channelData := cmd.flags.String("channel-data", "",
+		`User of the presence channel, e.g. {"user_id":"alice"}`)
+	if err := cmd.parse(args); err != nil {
+		return err
+	}
+	if cmd.flags.NArg() != 1 || *socketID == "" {
+		return errors.New("-socket-id and a channel are required")
+	}
+	channel := cmd.flags.Arg(0)
+	res := map[string]string{}
+	if strings.HasPrefix(channel, "presence-") {
+		if *channelData == "" {
+			return errors.New("-channel-data is required for presence channels")
+		}
+		res["auth"] = notifier.PresenceChannelAuth(cmd.app.Key, cmd.app.Secret,
+			*socketID, channel, *channelData)
+		res["channel_data"] = *channelData
+	} else {
+		res["auth"] = notifier.ChannelAuth(cmd.app.Key, cmd.app.Secret, *socketID, channel)
+	}
+	out, err := json.Marshal(res)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(stdout, string(out))
+	return nil
+}
+
+var commands = map[string]func([]string) error{
+	"trigger":      runTrigger,
+	"apps":         runApps,
+	"channels":     runChannels,
+	"channel":      runChannel,
+	"users":        runUsers,
+	"tail":         runTail,
+	"check":        runCheck,
+	"sign-request": runSignRequest,
+	"sign-channel": runSignChannel,
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	run, ok := commands[os.Args[1]]
+	if !ok {
+		if os.Args[1] != "-h" && os.Args[1] != "help" {
+			fmt.Fprintf(os.Stderr, "unknown command: %s\n", os.Args[1])
+		}
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	if err := run(os.Args[2:]); err != nil {
+		fmt.Fprintf(os.Stderr, "notifierctl %s: %v\n", os.Args[1], err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/sony/micro-notifier/notifier"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBaseURL(t *testing.T) {
+	tests := []struct {
+		name   string
+		url    string
+		config notifier.Config
+		api    bool
+		want   string
+		err    bool
+	}{
+		{name: "url flag", url: "https://notifier.example.com",
+			config: notifier.Config{Socket: "/run/notifier.sock"},
+			want:   "https://notifier.example.com"},
+		{name: "default", want: "http://localhost:8111"},
+		{name: "unspecified host", config: notifier.Config{Host: "0.0.0.0", Port: 8150},
+			want: "http://localhost:8150"},
+		{name: "host", config: notifier.Config{Host: "notifier.local", Port: 8150},
+			want: "http://notifier.local:8150"},
+		{name: "certificate", config: notifier.Config{Port: 8150, Certificate: "cert.pem"},
+			want: "https://localhost:8150"},
+		{name: "socket", config: notifier.Config{Socket: "/run/notifier.sock"},
+			err: true},
+		{name: "api listener",
+			config: notifier.Config{Port: 8150, Listeners: notifier.ConfigListeners{
+				API: notifier.ConfigListener{Host: "127.0.0.1", Port: 8151, Certificate: "cert.pem"}}},
+			api: true, want: "https://127.0.0.1:8151"},
+		{name: "api listener only for api",
+			config: notifier.Config{Port: 8150, Listeners: notifier.ConfigListeners{
+				API: notifier.ConfigListener{Port: 8151}}},
+			want: "http://localhost:8150"},
+		{name: "api listener on socket",
+			config: notifier.Config{Port: 8150, Listeners: notifier.ConfigListeners{
+				API: notifier.ConfigListener{Socket: "/run/notifier-api.sock"}}},
+			api: true, err: true},
+		{name: "api on main socket",
+			config: notifier.Config{Socket: "/run/notifier.sock", Listeners: notifier.ConfigListeners{
+				API: notifier.ConfigListener{Port: 8151}}},
+			api: true, want: "http://localhost:8151"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := tt.config
+			cmd := &command{serverURL: tt.url, config: &config}
+			u, err := cmd.baseURL(tt.api)
+			if tt.err {
+				require.NotNil(t, err)
+				return
+			}
+			require.Nil(t, err)
+			require.Equal(t, tt.want, u.String())
+		})
+	}
+}
+
+// syncBuffer is a bytes.Buffer safe for concurrent use.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// freePort returns a TCP port nobody listens on.
+func freePort(t *testing.T) int {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	defer ln.Close()
+	return ln.Addr().(*net.TCPAddr).Port
+}
+
+func TestTriggerTail(t *testing.T) {
+	port := freePort(t)
+	file := filepath.Join(t.TempDir(), "config.json")
+	err := os.WriteFile(file, []byte(fmt.Sprintf(`{
+  "host": "127.0.0.1",
+  "port": %d,
+  "applications": [
+    {"name": "testapp", "key": "1234567890", "secret": "abcdefghij"}
+  ]
+}`, port)), 0o600)
+	require.Nil(t, err)
+
+	config, err := notifier.ReadConfigFile(file)
+	require.Nil(t, err)
+	s := notifier.NewSupervisor(config)
+	defer s.Finish()
+	server, err := notifier.NewServer(s)
+	require.Nil(t, err)
+	served := make(chan error, 1)
+	go func() {
+		served <- server.ListenAndServe()
+	}()
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		require.Nil(t, server.Shutdown(ctx))
+		require.ErrorIs(t, <-served, http.ErrServerClosed)
+	}()
+
+	out, errOut := &syncBuffer{}, &syncBuffer{}
+	stdout, stderr = out, errOut
+	defer func() {
+		stdout, stderr = os.Stdout, os.Stderr
+	}()
+
+	require.Eventually(t, func() bool {
+		conn, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+		if err != nil {
+			return false
+		}
+		conn.Close()
+		return true
+	}, 5*time.Second, 10*time.Millisecond)
+
+	tailed := make(chan error, 1)
+	go func() {
+		tailed <- runTail([]string{"-c", file, "my-channel", "private-channel", "presence-channel"})
+	}()
+	require.Eventually(t, func() bool {
+		return strings.Count(errOut.String(), "subscribed to") == 3
+	}, 5*time.Second, 10*time.Millisecond, errOut.String())
+	require.Contains(t, errOut.String(), "subscribed to private-channel")
+	require.Contains(t, errOut.String(), "subscribed to presence-channel")
+
+	err = runTrigger([]string{"-c", file, "-channel", "my-channel", "-channel", "private-channel",
+		"-event", "my-event", "-data", `{"message":"hello"}`})
+	require.Nil(t, err)
+	require.Contains(t, out.String(), "{}")
+
+	require.Eventually(t, func() bool {
+		return strings.Contains(out.String(), `private-channel my-event {"message":"hello"}`)
+	}, 5*time.Second, 10*time.Millisecond, out.String())
+	require.Contains(t, out.String(), `my-channel my-event {"message":"hello"}`)
+
+	// tail ends on interrupt
+	require.Nil(t, syscall.Kill(os.Getpid(), syscall.SIGINT))
+	select {
+	case err := <-tailed:
+		require.Nil(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("tail didn't end on interrupt")
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/sony/micro-notifier/notifier"
+)
+
+// Pusher protocol version spoken by tail
+const protocolVersion = "7"
+
+// runTail subscribes to the channels as a Pusher client does, and prints
+// the events received.  Private and presence channels are authenticated
+// with the secret of the application.
+func runTail(args []string) error {
+	cmd := newCommand("tail")
+	jsonOutput := cmd.flags.Bool("json", false, "Print the events as JSON lines")
+	if err := cmd.parse(args); err != nil {
+		return err
+	}
+	if cmd.flags.NArg() == 0 {
+		return errors.New("channels are required")
+	}
+	base, err := cmd.baseURL(false)
+	if err != nil {
+		return err
+	}
+	u := *base
+	u.Scheme = strings.Replace(u.Scheme, "http", "ws", 1)
+	u.Path = "/app/" + cmd.app.Key
+	u.RawQuery = "protocol=" + protocolVersion + "&client=notifierctl&version=1.0"
+
+	dialer := websocket.Dialer{
+		HandshakeTimeout: 30 * time.Second,
+		TLSClientConfig:  cmd.tlsConfig(),
+	}
+	conn, _, err := dialer.Dial(u.String(), nil)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	var ev notifier.PusherEvent
+	if err := conn.ReadJSON(&ev); err != nil {
+		return err
+	}
+	if ev.Event != "pusher:connection_established" {
+		return fmt.Errorf("unexpected event: %s %s", ev.Event, ev.Data)
+	}
+	var established notifier.ConnectionEstablishedData
+	if err := json.Unmarshal([]byte(ev.Data), &established); err != nil {
+		return err
+	}
+	fmt.Fprintf(stderr, "connected to %s as %s\n", base.Host, established.SocketID)
+
+	for _, channel := range cmd.flags.Args() {
+		data := map[string]string{"channel": channel}
+		switch {
+		case strings.HasPrefix(channel, "private-"):
+			data["auth"] = notifier.ChannelAuth(cmd.app.Key, cmd.app.Secret,
+				established.SocketID, channel)
+		case strings.HasPrefix(channel, "presence-"):
+			channelData := `{"user_id":"notifierctl-` + established.SocketID + `"}`
+			data["auth"] = notifier.PresenceChannelAuth(cmd.app.Key, cmd.app.Secret,
+				established.SocketID, channel, channelData)
+			data["channel_data"] = channelData
+		}
+		err := conn.WriteJSON(map[string]any{"event": "pusher:subscribe", "data": data})
+		if err != nil {
+			return err
+		}
+	}
+
+	// Closed on interrupt so that the loop below ends
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt)
+	defer signal.Stop(sig)
+	interrupted := make(chan struct{})
+	go func() {
+		<-sig
+		close(interrupted)
+		_ = conn.WriteControl(websocket.CloseMessage,
+			websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""),
+			time.Now().Add(time.Second))
+		conn.Close()
+	}()
+	if established.ActivityTimeout > 0 {
+		go keepAlive(conn, time.Duration(established.ActivityTimeout)*time.Second)
+	}
+
+	for {
+		var ev notifier.PusherEvent
+		if err := conn.ReadJSON(&ev); err != nil {
+			select {
+			case <-interrupted:
+				return nil
+			default:
+				return err
+			}
+		}
+		switch ev.Event {
+		case "pusher:pong":
+		case "pusher_internal:subscription_succeeded":
+			fmt.Fprintf(stderr, "subscribed to %s\n", ev.Channel)
+		case "pusher:error", "pusher:subscription_error":
+			fmt.Fprintf(stderr, "%s %s %s\n", ev.Event, ev.Channel, ev.Data)
+		default:
+			if err := printEvent(&ev, *jsonOutput); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// keepAlive sends pings so that the connection isn't regarded as
+// inactive.
+func keepAlive(conn *websocket.Conn, interval time.Duration) {
+	for range time.Tick(interval) {
+		err := conn.WriteJSON(map[string]any{"event": "pusher:ping", "data": map[string]any{}})
+		if err != nil {
+			return
+		}
+	}
+}
+
+func printEvent(ev *notifier.PusherEvent, jsonOutput bool) error {
+	if jsonOutput {
+		out, err := json.Marshal(ev)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(stdout, string(out))
+		return nil
+	}
+	fmt.Fprintf(stdout, "%s %s %s %s\n", time.Now().Format(time.RFC3339), ev.Channel, ev.Event, ev.Data)
+	return nil
+}